@@ -1,24 +1,52 @@
+// goose-migrate applies the embedded migrations to the configured database.
+//
+//	go run ./cmd/goose-migrate [-config file] [up|down|redo|status|version]
 package main
 
 import (
-	"database/sql"
-	"embed"
-
-	"github.com/pressly/goose/v3"
+	"chat-app/config"
+	"chat-app/database"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
 )
 
-var embedMigrations embed.FS
-
 func main() {
-	var db *sql.DB
+	configPath := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-config file] [command]\ncommands: %s\n", os.Args[0], database.MigrateCommands)
+	}
+	flag.Parse()
+
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	goose.SetBaseFS(embedMigrations)
+	command := "up"
+	if flag.NArg() == 1 {
+		command = flag.Arg(0)
+	}
 
-	if err := goose.SetDialect("postgres"); err != nil {
-		panic(err)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
 	}
 
-	if err := goose.Up(db, "migrations"); err != nil {
-		panic(err)
+	ctx := context.Background()
+
+	db, err := database.New(ctx, cfg)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	sqlDB := db.SQLDB()
+	defer sqlDB.Close()
+
+	if err := database.Migrate(ctx, sqlDB, cfg.Database.MigrationsTable, command, os.Stdout); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,130 @@
+// hub-bench drives thousands of simulated clients through an in-process Hub
+// and reports fan-out latency percentiles.
+//
+//	go run ./cmd/hub-bench -clients 10000 -chats 1000 -messages 20000
+package main
+
+import (
+	"chat-app/internal/broker"
+	"chat-app/internal/names"
+	"chat-app/internal/repository"
+	"chat-app/ws"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func main() {
+	clients := flag.Int("clients", 10000, "number of simulated clients")
+	chats := flag.Int("chats", 1000, "number of chats clients are spread across")
+	messages := flag.Int("messages", 20000, "messages to broadcast")
+	producers := flag.Int("producers", 8, "concurrent senders")
+	buffer := flag.Int("buffer", 256, "per-client send buffer")
+	policy := flag.String("policy", string(ws.PolicyDisconnect), "slow consumer policy: disconnect or drop_oldest")
+	flag.Parse()
+
+	slowPolicy, err := ws.ParseSlowConsumerPolicy(*policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hub := ws.NewHub(ws.Deps{
+		Broker: broker.NewMemory(),
+		Names:  names.New(repository.NewMemoryUsers(), nil),
+		Chats:  repository.NewMemoryChats(),
+	}, ws.Options{SendBuffer: *buffer, SlowPolicy: slowPolicy})
+	go hub.Run()
+
+	chatIDs := make([]string, *chats)
+	for i := range chatIDs {
+		chatIDs[i] = uuid.NewString()
+	}
+
+	// Клиенты распределяются по чатам по кругу, ожидаемое число доставок считаем заранее
+	perChat := make(map[string]int, *chats)
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		wg        sync.WaitGroup
+	)
+
+	joined := make([]*ws.Client, 0, *clients)
+	for i := 0; i < *clients; i++ {
+		chat := chatIDs[i%*chats]
+		perChat[chat]++
+
+		c := hub.Join(uuid.New(), chat, *buffer)
+		joined = append(joined, c)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]time.Duration, 0, 64)
+			for msg := range c.Messages() {
+				local = append(local, time.Since(msg.CreatedAt))
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			mu.Unlock()
+		}()
+	}
+
+	expected := 0
+	for i := 0; i < *messages; i++ {
+		expected += perChat[chatIDs[i%*chats]]
+	}
+
+	log.Printf("clients=%d chats=%d messages=%d expected deliveries=%d", *clients, *chats, *messages, expected)
+
+	start := time.Now()
+	var pwg sync.WaitGroup
+	for p := 0; p < *producers; p++ {
+		pwg.Add(1)
+		go func(p int) {
+			defer pwg.Done()
+			for i := p; i < *messages; i += *producers {
+				hub.Broadcast(ws.WMessage{
+					UUID:      uuid.NewString(),
+					ChatUUID:  chatIDs[i%*chats],
+					Content:   "bench",
+					CreatedAt: time.Now(),
+				})
+			}
+		}(p)
+	}
+	pwg.Wait()
+	sent := time.Since(start)
+
+	// Даём доставкам дойти, затем отключаем клиентов, чтобы закрылись их каналы
+	time.Sleep(500 * time.Millisecond)
+	for _, c := range joined {
+		hub.Leave(c)
+	}
+	wg.Wait()
+
+	slices.Sort(latencies)
+	fmt.Printf("sent %d messages in %s (%.0f msg/s)\n", *messages, sent, float64(*messages)/sent.Seconds())
+	fmt.Printf("delivered %d of %d (%.2f%%)\n", len(latencies), expected, 100*float64(len(latencies))/float64(max(expected, 1)))
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Printf("p%-5v %s\n", p, percentile(latencies, p))
+	}
+	if len(latencies) > 0 {
+		fmt.Printf("max    %s\n", latencies[len(latencies)-1])
+	}
+
+	stats := hub.Stats()
+	fmt.Printf("dropped %d, slow disconnects %d\n", stats.DroppedMessages, stats.SlowDisconnects)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
@@ -3,13 +3,25 @@ package main
 import (
 	"chat-app/config"
 	"chat-app/database"
-	_ "chat-app/database"
 	"chat-app/handlers"
+	"chat-app/internal/account"
+	"chat-app/internal/auth"
+	"chat-app/internal/blocks"
+	"chat-app/internal/broker"
+	"chat-app/internal/chatarchive"
+	"chat-app/internal/contacts"
+	"chat-app/internal/mail"
+	"chat-app/internal/names"
+	"chat-app/internal/oidc"
+	"chat-app/internal/persistence"
+	"chat-app/internal/presence"
+	"chat-app/internal/ratelimit"
 	"chat-app/internal/redis"
+	"chat-app/internal/repository"
 	"chat-app/middleware"
 	"chat-app/ws"
 	"context"
-	_ "database/sql"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -18,10 +30,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
-	"github.com/pressly/goose/v3"
 )
 
 func main() {
@@ -30,36 +39,159 @@ func main() {
 		log.Printf("Error loading .env file")
 	}
 
-	cfg, err := config.Load()
+	configPath := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE); env variables override it")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets masked and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatal("Failed to load config:", err)
+		log.Fatal("Failed to load config: ", err)
 	}
 
-	// Устанавливаем секрет для WebSocket
-	ws.SetJWTSecret([]byte(cfg.JWT.Secret)) // <-- ДОБАВИТЬ ЭТУ СТРОКУ
+	if *printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			log.Fatal("Failed to print config: ", err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
 
-	dsn := os.Getenv("GOOSE_DBSTRING")
-	if dsn == "" {
-		log.Fatal("DATABASE_URL не задан в .env или окружении")
+	// chat-app [-config file] migrate [up|down|redo|status|version]
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(cfg, flag.Args()[1:]); err != nil {
+			log.Fatal("Migrate failed:", err)
+		}
+		return
 	}
 
-	db, err := goose.OpenDBWithDriver("postgres", dsn)
+	keys, err := loadKeySet(cfg)
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+
+	tokens := auth.NewTokenService(keys, cfg.JWT.TokenExpiry)
+
+	db, err := database.New(context.Background(), cfg)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
-	database.DB = db
+	users := repository.NewPostgresUsers(db)
+	chats := repository.NewPostgresChats(db)
+	messages := repository.NewPostgresMessages(db)
+	userBlocks := repository.NewPostgresBlocks(db)
+	userContacts := repository.NewPostgresContacts(db)
+	userAccounts := repository.NewPostgresAccounts(db)
+	chatImports := repository.NewPostgresImports(db)
+	twoFactor := repository.NewPostgresTwoFactor(db)
+	identities := repository.NewPostgresIdentities(db)
+
+	if cfg.Database.AutoMigrate {
+		sqlDB := db.SQLDB()
+		err := database.Migrate(context.Background(), sqlDB, cfg.Database.MigrationsTable, "up", log.Writer())
+		sqlDB.Close()
+		if err != nil {
+			log.Fatal("Миграция упала:", err)
+		}
+		log.Println("Миграции успешно применены!")
+	}
+
+	messageWriter, err := persistence.NewWriter(db, persistence.Options{
+		QueueSize:     cfg.Persistence.QueueSize,
+		BatchSize:     cfg.Persistence.BatchSize,
+		FlushInterval: cfg.Persistence.FlushInterval,
+		MaxRetries:    cfg.Persistence.MaxRetries,
+		WALPath:       cfg.Persistence.WALPath,
+	})
+	if err != nil {
+		log.Fatal("Failed to start message writer:", err)
+	}
+	messageWriter.Start()
 
-	//if err := goose.Up(db, "migrations"); err != nil {
-	//	log.Fatal("Миграция упала:", err)
-	//}
+	redis.Init(cfg.Redis) // инициализируем редис до Hub!
 
-	log.Println("Миграции успешно применены!")
+	// Отзыв всех сессий пользователя, например при удалении аккаунта
+	revocations := auth.NewRevocations(redis.Client, tokens.AccessTTL())
 
-	redis.Init() // инициализируем редис до Hub!
+	// Подписи отправителей: кэш сбрасывается на всех инстансах через Redis
+	displayNames := names.New(users, redis.Client)
+	if err := displayNames.Start(context.Background()); err != nil {
+		log.Fatal("Failed to subscribe to name invalidations:", err)
+	}
 
-	go ws.HubInstance.Run() // запускаем Hub
+	// Чёрные списки: кэш на инстансе, изменения рассылаются через Redis
+	blockList := blocks.New(userBlocks, redis.Client)
+	if err := blockList.Start(context.Background()); err != nil {
+		log.Fatal("Failed to subscribe to block list changes:", err)
+	}
+
+	// Контакты и настройка «писать могут только контакты», кэшируются так же
+	contactList := contacts.New(userContacts, users, redis.Client)
+	if err := contactList.Start(context.Background()); err != nil {
+		log.Fatal("Failed to subscribe to contact changes:", err)
+	}
+
+	// Кто онлайн: отметки инстансов в Redis, продлеваются сердцебиением
+	onlineUsers := presence.New(redis.Client)
+	onlineUsers.Start()
+
+	msgBroker, err := newBroker(cfg)
+	if err != nil {
+		log.Fatal("Failed to start message broker:", err)
+	}
+
+	slowPolicy, err := ws.ParseSlowConsumerPolicy(cfg.WebSocket.SlowConsumerPolicy)
+	if err != nil {
+		log.Fatal("Invalid WebSocket config:", err)
+	}
+
+	hub := ws.NewHub(ws.Deps{
+		Broker:   msgBroker,
+		Names:    displayNames,
+		Blocks:   blockList,
+		Contacts: contactList,
+		Presence: onlineUsers,
+		Chats:    chats,
+		Tokens:   tokens,
+		Revoked:  revocations,
+		Messages: messageWriter,
+		Redis:    redis.Client,
+	}, ws.Options{
+		SendBuffer:     cfg.WebSocket.SendBuffer,
+		SlowPolicy:     slowPolicy,
+		WriteWait:      cfg.WebSocket.WriteWait,
+		PongWait:       cfg.WebSocket.PongWait,
+		PingInterval:   cfg.WebSocket.PingInterval,
+		MaxMessageSize: cfg.WebSocket.MaxMessageSize,
+		IdleTimeout:    cfg.WebSocket.IdleTimeout,
+		AllowedOrigins: cfg.WebSocket.AllowedOrigins,
+	})
+	go hub.Run() // запускаем Hub
+
+	mailer := mail.New(cfg.Mail)
+
+	// Выгрузка данных и удаление аккаунтов по истечении срока
+	accountService := account.New(account.Deps{
+		Accounts: userAccounts,
+		Users:    users,
+		Chats:    chats,
+		Messages: messages,
+		Contacts: userContacts,
+		Blocks:   userBlocks,
+		Names:    displayNames,
+		Tokens:   tokens,
+		Revoked:  revocations,
+		Notifier: hub,
+		Mail:     mailer,
+	}, account.Options{
+		StorageDir:    cfg.Storage.Dir,
+		PublicURL:     cfg.Server.PublicURL,
+		DeletionGrace: cfg.Account.DeletionGrace,
+		ExportTTL:     cfg.Account.ExportTTL,
+	})
+	accountService.Start()
 
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -70,7 +202,7 @@ func main() {
 
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if c.Request.Method == "OPTIONS" {
@@ -80,34 +212,131 @@ func main() {
 		c.Next()
 	})
 
-	authHandler := handlers.NewAuthHandler([]byte(cfg.JWT.Secret))
+	loginGuard := ratelimit.NewLoginGuard(redis.Client, ratelimit.DefaultLoginPolicy)
+	authHandler := handlers.NewAuthHandler(users, twoFactor, tokens, loginGuard)
+	chatHandler := handlers.NewChatHandler(users, chats, messages, displayNames, blockList, contactList)
+	blockHandler := handlers.NewBlockHandler(blockList, users, displayNames)
+	contactHandler := handlers.NewContactHandler(handlers.ContactDeps{
+		Contacts: contactList,
+		Blocks:   blockList,
+		Users:    users,
+		Names:    displayNames,
+		Presence: onlineUsers,
+		Notifier: hub,
+	})
+	reauth := handlers.NewReauth(tokens, twoFactor)
+	profileHandler := handlers.NewProfileHandler(handlers.ProfileDeps{
+		Users:     users,
+		Names:     displayNames,
+		Redis:     redis.Client,
+		Mail:      mailer,
+		Reauth:    reauth,
+		Storage:   cfg.Storage,
+		PublicURL: cfg.Server.PublicURL,
+	})
+	accountHandler := handlers.NewAccountHandler(accountService, users, reauth)
+	archiveHandler := handlers.NewArchiveHandler(users, chats, messages, displayNames,
+		chatarchive.NewImporter(users, chats, chatImports))
+
+	// публичные ключи для других наших сервисов
+	r.GET("/.well-known/jwks.json", handlers.JWKS(keys))
 
 	public := r.Group("/api/v1")
 	{
-		public.POST("/register", authHandler.Register)
-		public.POST("/login", authHandler.Login)
+		if cfg.Features.Registration {
+			public.POST("/register", authHandler.Register)
+		}
+		public.POST("/login", middleware.RateLimiter(redis.Client, 30, time.Minute), authHandler.Login)
+		public.POST("/login/2fa", middleware.RateLimiter(redis.Client, 30, time.Minute), authHandler.VerifyTwoFactor)
+		public.GET("/email/confirm", middleware.RateLimiter(redis.Client, 30, time.Minute), profileHandler.ConfirmEmailChange)
+		public.GET("/account/exports/:export_id", middleware.RateLimiter(redis.Client, 30, time.Minute), accountHandler.DownloadExport)
+	}
+
+	if cfg.OIDC.IssuerURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.NewProvider(ctx, oidc.Config{
+			IssuerURL:    cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+		})
+		cancel()
+		if err != nil {
+			log.Fatal("Failed to configure OIDC provider:", err)
+		}
+
+		oidcHandler := handlers.NewOIDCHandler(provider, authHandler, identities, redis.Client)
+		public.GET("/oidc/login", oidcHandler.Login)
+		public.GET("/oidc/callback", oidcHandler.Callback)
 	}
 
 	protected := r.Group("/api/v1")
-	//protected.Use(middleware.RateLimiter())
-	protected.Use(middleware.AuthMiddleware([]byte(cfg.JWT.Secret)))
+	protected.Use(middleware.AuthMiddleware(tokens, revocations))
+	protected.Use(middleware.RateLimiter(redis.Client, 300, time.Minute))
 	{
 		protected.POST("/refresh-token", authHandler.RefreshToken)
 		protected.POST("/logout", authHandler.Logout)
-		protected.GET("/profile", handlers.GetUserProfile)
+		protected.GET("/profile", profileHandler.GetUserProfile)
+		protected.PATCH("/profile", profileHandler.UpdateProfile)
+		protected.PUT("/profile/discoverability", profileHandler.UpdateDiscoverability)
+		protected.PUT("/profile/password", middleware.RateLimiter(redis.Client, 10, time.Minute), profileHandler.ChangePassword)
+		protected.POST("/profile/email", middleware.RateLimiter(redis.Client, 5, time.Minute), profileHandler.RequestEmailChange)
+		protected.POST("/profile/avatar", profileHandler.UploadAvatar)
+		protected.DELETE("/profile/avatar", profileHandler.DeleteAvatar)
+		protected.GET("/users/:user_uuid/avatar", profileHandler.GetAvatar)
+
+		if cfg.Features.TwoFactor {
+			protected.POST("/2fa/enroll", authHandler.EnrollTwoFactor)
+			protected.POST("/2fa/confirm", authHandler.ConfirmTwoFactor)
+		}
+		protected.POST("/2fa/disable", authHandler.DisableTwoFactor)
+
+		protected.POST("/message", chatHandler.SendMessage)
+
+		protected.POST("/chats/direct", chatHandler.CreateDirectChat)
+		protected.POST("/chats/group", chatHandler.CreateGroupChat)
+		protected.GET("/chats", chatHandler.GetUserChats)
+		protected.GET("/chats/:chat_uuid/messages", chatHandler.GetChatMessages)
+		protected.GET("/messages/search", chatHandler.SearchMessages)
+		protected.GET("/users/search", chatHandler.SearchUsers)
+		protected.GET("/chats/:chat_uuid/read", chatHandler.MarkChatAsRead)
+		protected.GET("/chats/:chat_uuid/export", middleware.RateLimiter(redis.Client, 10, time.Minute), archiveHandler.ExportChat)
 
-		protected.POST("/message", authHandler.SendMessage)
+		protected.GET("/blocks", blockHandler.ListBlocks)
+		protected.POST("/blocks", blockHandler.BlockUser)
+		protected.DELETE("/blocks/:user_uuid", blockHandler.UnblockUser)
 
-		protected.POST("/chats/direct", handlers.CreateDirectChat)
-		protected.POST("/chats/group", handlers.CreateGroupChat)
-		protected.GET("/chats", handlers.GetUserChats)
-		protected.GET("/chats/:chat_uuid/messages", handlers.GetChatMessages)
-		protected.GET("/users/search", handlers.SearchUsers)
-		protected.GET("/chats/:chat_uuid/read", handlers.MarkChatAsRead)
+		protected.GET("/contacts", contactHandler.ListContacts)
+		protected.DELETE("/contacts/:user_uuid", contactHandler.RemoveContact)
+		protected.GET("/contacts/requests", contactHandler.ListRequests)
+		protected.POST("/contacts/requests", middleware.RateLimiter(redis.Client, 30, time.Minute), contactHandler.SendRequest)
+		protected.POST("/contacts/requests/:user_uuid/accept", contactHandler.AcceptRequest)
+		protected.POST("/contacts/requests/:user_uuid/decline", contactHandler.DeclineRequest)
+		protected.DELETE("/contacts/requests/:user_uuid", contactHandler.CancelRequest)
+		protected.PUT("/profile/message-privacy", contactHandler.UpdateMessagePrivacy)
+
+		protected.POST("/account/export", middleware.RateLimiter(redis.Client, 3, time.Hour), accountHandler.StartExport)
+		protected.GET("/account/export", accountHandler.GetExport)
+		protected.POST("/account/deletion", middleware.RateLimiter(redis.Client, 10, time.Minute), accountHandler.ScheduleDeletion)
+		protected.DELETE("/account/deletion", accountHandler.CancelDeletion)
+
+		protected.POST("/ws/ticket", hub.IssueTicket)
+	}
+
+	admin := protected.Group("/admin")
+	admin.Use(middleware.AdminOnly(users))
+	{
+		admin.POST("/login-unlock", authHandler.UnlockLogin)
+		admin.GET("/ws-stats", hub.HandleStats)
+		admin.GET("/persistence-stats", hub.HandlePersistenceStats)
+		admin.GET("/chats/:chat_uuid/export", archiveHandler.AdminExportChat)
+		admin.POST("/chats/import", archiveHandler.ImportChat)
 	}
 
 	// веб сокет, для фронта
-	r.GET("/ws/chat/:chat_uuid", ws.HandleChat)
+	r.GET("/ws/chat/:chat_uuid", hub.HandleChat)
+	r.GET("/ws/notifications", hub.HandleNotifications)
 
 	serverAddr := cfg.Server.Host + ":" + cfg.Server.Port
 	log.Printf("Server starting on %s", serverAddr)
@@ -119,16 +348,102 @@ func main() {
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
 
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatal("Server failed to start:", err)
-	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server failed to start:", err)
+		}
+	}()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-	srv.Shutdown(ctx)
 	log.Println("Server shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Закрываем listener и ждём REST-запросы; WebSocket-соединения http.Server не отслеживает
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+
+	// Сокеты получают close 1012, принятые сообщения дописываются в брокер
+	if err := hub.Shutdown(ctx); err != nil {
+		log.Printf("Hub shutdown: %v", err)
+	}
+
+	// Читателей больше нет — сбрасываем очередь в БД, остаток уходит в WAL
+	if err := messageWriter.Close(ctx); err != nil {
+		log.Printf("Message writer close: %v", err)
+	}
+
+	// Дописываем или бросаем начатые выгрузки до закрытия Redis и БД
+	if err := accountService.Close(); err != nil {
+		log.Printf("Account service close: %v", err)
+	}
+
+	if err := msgBroker.Close(); err != nil {
+		log.Printf("Broker close: %v", err)
+	}
+	if err := displayNames.Close(); err != nil {
+		log.Printf("Name cache close: %v", err)
+	}
+	if err := blockList.Close(); err != nil {
+		log.Printf("Block list close: %v", err)
+	}
+	if err := contactList.Close(); err != nil {
+		log.Printf("Contact list close: %v", err)
+	}
+	// Сокетов уже нет: снимаем отметки «онлайн» этого инстанса, не дожидаясь их истечения
+	if err := onlineUsers.Close(); err != nil {
+		log.Printf("Presence close: %v", err)
+	}
+	if err := redis.Client.Close(); err != nil {
+		log.Printf("Redis close: %v", err)
+	}
+
+	log.Println("Server stopped")
+}
+
+// runMigrate applies a migrate subcommand and exits without starting the server
+func runMigrate(cfg *config.Config, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	db, err := database.New(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sqlDB := db.SQLDB()
+	defer sqlDB.Close()
+
+	return database.Migrate(context.Background(), sqlDB, cfg.Database.MigrationsTable, command, os.Stdout)
+}
+
+// loadKeySet loads the configured signing keys, falling back to an ephemeral
+// key outside production so local development works without setup
+func loadKeySet(cfg *config.Config) (*auth.KeySet, error) {
+	if cfg.JWT.PrivateKeyFile != "" {
+		return auth.LoadKeySet(cfg.JWT.PrivateKeyFile, cfg.JWT.KeyID, cfg.JWT.PublicKeyFiles)
+	}
+
+	log.Println("JWT_PRIVATE_KEY_FILE не задан, используем временный ключ (токены не переживут рестарт)")
+	return auth.GenerateKeySet()
+}
+
+// newBroker picks the message bus between hub instances
+func newBroker(cfg *config.Config) (broker.Broker, error) {
+	switch cfg.Broker.Type {
+	case "memory":
+		return broker.NewMemory(), nil
+	case "streams":
+		return broker.NewRedisStreams(redis.Client, cfg.Broker.NodeID, cfg.Broker.StreamMaxLen)
+	default:
+		return broker.NewRedisPubSub(redis.Client), nil
+	}
 }
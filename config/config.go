@@ -1,76 +1,281 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	toml "github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+
 	"github.com/joho/godotenv"
 )
 
+// Config is the whole application configuration. Значения берутся из умолчаний,
+// затем из файла (YAML или TOML), затем из переменных окружения из тега env.
 type Config struct {
-	Server struct {
-		Port         string
-		Host         string
-		ReadTimeout  time.Duration
-		WriteTimeout time.Duration
-	}
+	Environment string `yaml:"environment" env:"ENV"`
 
-	Database struct {
-		Host     string
-		Port     string
-		User     string
-		Password string
-		DBName   string
-		SSLMode  string
-	}
+	Server      Server      `yaml:"server"`
+	Database    Database    `yaml:"database"`
+	Redis       Redis       `yaml:"redis"`
+	JWT         JWT         `yaml:"jwt"`
+	Broker      Broker      `yaml:"broker"`
+	WebSocket   WebSocket   `yaml:"websocket"`
+	Persistence Persistence `yaml:"persistence"`
+	Storage     Storage     `yaml:"storage"`
+	OIDC        OIDC        `yaml:"oidc"`
+	Mail        Mail        `yaml:"mail"`
+	Account     Account     `yaml:"account"`
+	Features    Features    `yaml:"features"`
+}
 
-	JWT struct {
-		Secret        string
-		TokenExpiry   time.Duration
-		RefreshExpiry time.Duration
-	}
+type Server struct {
+	Port            string        `yaml:"port" env:"SERVER_PORT"`
+	Host            string        `yaml:"host" env:"SERVER_HOST"`
+	PublicURL       string        `yaml:"public_url" env:"SERVER_PUBLIC_URL"` // внешний адрес для ссылок в письмах
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"` // за сколько должны успеть закрыть сокеты и дописать сообщения
+}
 
-	Environment string
+type Database struct {
+	URL      string `yaml:"url" env:"DATABASE_URL" secret:"true"` // если задан, отдельные поля ниже не используются
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     string `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	DBName   string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE"`
+
+	MaxConns        int32         `yaml:"max_conns" env:"DB_MAX_CONNS"`
+	MinConns        int32         `yaml:"min_conns" env:"DB_MIN_CONNS"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" env:"DB_MAX_CONN_IDLE_TIME"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+	QueryTimeout    time.Duration `yaml:"query_timeout" env:"DB_QUERY_TIMEOUT"` // верхняя граница на один запрос, даже если клиент ждёт дольше
+
+	AutoMigrate     bool   `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE"` // применять миграции при старте сервера
+	MigrationsTable string `yaml:"migrations_table" env:"GOOSE_TABLE"` // таблица версий goose, та же, что у CLI
 }
 
-func Load() (*Config, error) {
-	godotenv.Load()
+type Redis struct {
+	Addr        string        `yaml:"addr" env:"REDIS_ADDR"`
+	Password    string        `yaml:"password" env:"REDIS_PASSWORD" secret:"true"`
+	DB          int           `yaml:"db" env:"REDIS_DB"`
+	PoolSize    int           `yaml:"pool_size" env:"REDIS_POOL_SIZE"` // 0 — значение go-redis по умолчанию
+	DialTimeout time.Duration `yaml:"dial_timeout" env:"REDIS_DIAL_TIMEOUT"`
+}
+
+type JWT struct {
+	PrivateKeyFile string        `yaml:"private_key_file" env:"JWT_PRIVATE_KEY_FILE"` // PEM-ключ RSA или Ed25519 для подписи
+	KeyID          string        `yaml:"key_id" env:"JWT_KEY_ID"`                     // kid; по умолчанию отпечаток ключа (RFC 7638)
+	PublicKeyFiles []string      `yaml:"public_key_files" env:"JWT_PUBLIC_KEY_FILES"` // старые ключи, которые ещё принимаем при ротации
+	TokenExpiry    time.Duration `yaml:"token_expiry" env:"JWT_TOKEN_EXPIRY"`
+	RefreshExpiry  time.Duration `yaml:"refresh_expiry" env:"JWT_REFRESH_EXPIRY"`
+}
+
+type Broker struct {
+	Type         string `yaml:"type" env:"BROKER"`     // memory, redis или streams
+	NodeID       string `yaml:"node_id" env:"NODE_ID"` // стабильный id узла для consumer group в streams
+	StreamMaxLen int64  `yaml:"stream_max_len" env:"BROKER_STREAM_MAXLEN"`
+}
+
+type WebSocket struct {
+	SendBuffer         int           `yaml:"send_buffer" env:"WS_SEND_BUFFER"`                   // размер очереди исходящих сообщений на клиента
+	SlowConsumerPolicy string        `yaml:"slow_consumer_policy" env:"WS_SLOW_CONSUMER_POLICY"` // disconnect или drop_oldest
+	WriteWait          time.Duration `yaml:"write_wait" env:"WS_WRITE_WAIT"`
+	PongWait           time.Duration `yaml:"pong_wait" env:"WS_PONG_WAIT"`
+	PingInterval       time.Duration `yaml:"ping_interval" env:"WS_PING_INTERVAL"`
+	MaxMessageSize     int64         `yaml:"max_message_size" env:"WS_MAX_MESSAGE_SIZE"`
+	IdleTimeout        time.Duration `yaml:"idle_timeout" env:"WS_IDLE_TIMEOUT"`
+	AllowedOrigins     []string      `yaml:"allowed_origins" env:"WS_ALLOWED_ORIGINS"`
+}
+
+type Persistence struct {
+	QueueSize     int           `yaml:"queue_size" env:"PERSIST_QUEUE_SIZE"` // сколько сообщений ждут записи в памяти, дальше — сразу в WAL
+	BatchSize     int           `yaml:"batch_size" env:"PERSIST_BATCH_SIZE"`
+	FlushInterval time.Duration `yaml:"flush_interval" env:"PERSIST_FLUSH_INTERVAL"`
+	MaxRetries    int           `yaml:"max_retries" env:"PERSIST_MAX_RETRIES"`
+	WALPath       string        `yaml:"wal_path" env:"PERSIST_WAL_PATH"` // куда складываем сообщения, пока Postgres недоступен
+}
+
+// Storage is where user files (avatars, attachments, exports) live on disk
+type Storage struct {
+	Dir           string `yaml:"dir" env:"STORAGE_DIR"`
+	MaxUploadSize int64  `yaml:"max_upload_size" env:"STORAGE_MAX_UPLOAD_SIZE"` // байт
+}
+
+type OIDC struct {
+	IssuerURL    string   `yaml:"issuer_url" env:"OIDC_ISSUER_URL"` // пусто — вход через SSO выключен
+	ClientID     string   `yaml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string   `yaml:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	RedirectURL  string   `yaml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	Scopes       []string `yaml:"scopes" env:"OIDC_SCOPES"`
+}
 
-	cfg := &Config{}
+// Mail is the outgoing SMTP server for verification letters
+type Mail struct {
+	SMTPAddr string `yaml:"smtp_addr" env:"MAIL_SMTP_ADDR"` // host:port; пусто — письма только пишутся в лог
+	Username string `yaml:"username" env:"MAIL_USERNAME"`
+	Password string `yaml:"password" env:"MAIL_PASSWORD" secret:"true"`
+	From     string `yaml:"from" env:"MAIL_FROM"`
+}
 
-	//Server config
-	cfg.Server.Port = getEnv("SERVER_PORT", "8080")
-	cfg.Server.Host = getEnv("SERVER_HOST", "0.0.0.0")
-	cfg.Server.ReadTimeout = time.Second * 15
-	cfg.Server.WriteTimeout = time.Second * 15
+// Account controls data export and account deletion
+type Account struct {
+	DeletionGrace time.Duration `yaml:"deletion_grace" env:"ACCOUNT_DELETION_GRACE"` // сколько ждём до удаления, пока его можно отменить
+	ExportTTL     time.Duration `yaml:"export_ttl" env:"ACCOUNT_EXPORT_TTL"`         // сколько живут архив выгрузки и ссылка на него
+}
 
-	//Database config
-	cfg.Database.Host = getEnv("DB_HOST", "localhost")
-	cfg.Database.Port = getEnv("DB_PORT", "5432")
-	cfg.Database.User = getEnv("DB_USER", "postgres")
-	cfg.Database.Password = getEnv("DB_PASSWORD", "")
-	cfg.Database.DBName = getEnv("DB_NAME", "auth_service")
-	cfg.Database.SSLMode = getEnv("DB_SSLMODE", "disable")
+// Features switch optional parts of the API on and off
+type Features struct {
+	Registration bool `yaml:"registration" env:"FEATURE_REGISTRATION"` // выключено — аккаунты создаёт только SSO или админ
+	TwoFactor    bool `yaml:"two_factor" env:"FEATURE_TWO_FACTOR"`     // выключено — нельзя подключить новую 2FA, вход с уже включённой работает
+}
 
-	//JWT config
-	cfg.JWT.Secret = getEnv("JWT_SECRET", "4395da61d3f123a8c40868cc11c837d4")
-	cfg.JWT.TokenExpiry = time.Hour * 24
-	cfg.JWT.RefreshExpiry = time.Hour * 24 * 7
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	hostname, _ := os.Hostname()
 
-	cfg.Environment = getEnv("ENV", "development")
+	return &Config{
+		Environment: "development",
+		Server: Server{
+			Port:            "8080",
+			Host:            "0.0.0.0",
+			PublicURL:       "http://localhost:8080",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Database: Database{
+			Host:            "localhost",
+			Port:            "5432",
+			User:            "postgres",
+			DBName:          "auth_service",
+			SSLMode:         "disable",
+			MaxConns:        25,
+			MinConns:        2,
+			MaxConnLifetime: 30 * time.Minute,
+			MaxConnIdleTime: 5 * time.Minute,
+			ConnectTimeout:  5 * time.Second,
+			QueryTimeout:    5 * time.Second,
+			MigrationsTable: "goose_db_version",
+		},
+		Redis: Redis{
+			Addr:        "localhost:6379",
+			DialTimeout: 5 * time.Second,
+		},
+		JWT: JWT{
+			TokenExpiry:   24 * time.Hour,
+			RefreshExpiry: 7 * 24 * time.Hour,
+		},
+		Broker: Broker{
+			Type:         "redis",
+			NodeID:       hostname,
+			StreamMaxLen: 100000,
+		},
+		WebSocket: WebSocket{
+			SendBuffer:         100,
+			SlowConsumerPolicy: "disconnect",
+			WriteWait:          10 * time.Second,
+			PongWait:           60 * time.Second,
+			PingInterval:       54 * time.Second,
+			MaxMessageSize:     32768,
+			IdleTimeout:        30 * time.Minute,
+		},
+		Persistence: Persistence{
+			QueueSize:     10000,
+			BatchSize:     500,
+			FlushInterval: 50 * time.Millisecond,
+			MaxRetries:    5,
+			WALPath:       "data/messages.wal",
+		},
+		Storage: Storage{
+			Dir:           "data/uploads",
+			MaxUploadSize: 10 << 20,
+		},
+		Mail: Mail{
+			From: "Chat App <no-reply@localhost>",
+		},
+		Account: Account{
+			DeletionGrace: 30 * 24 * time.Hour,
+			ExportTTL:     7 * 24 * time.Hour,
+		},
+		Features: Features{
+			Registration: true,
+			TwoFactor:    true,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the optional file at path
+// (or CONFIG_FILE) and environment variables, then validates it
+func Load(path string) (*Config, error) {
+	godotenv.Load()
+
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// loadFile merges a YAML or TOML file over the current values
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
 	}
-	return defaultValue
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		// go-toml не разбирает "30s" в time.Duration, поэтому TOML перекладываем
+		// в YAML и дальше разбираем одним путём с одинаковыми ключами
+		var raw map[string]any
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(raw); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // опечатка в ключе должна быть ошибкой, а не молча игнорироваться
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
 }
 
 func (c *Config) GetDSN() string {
+	if c.Database.URL != "" {
+		return c.Database.URL
+	}
 	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		c.Database.Host,
 		c.Database.Port,
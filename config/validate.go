@@ -0,0 +1,84 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Validate reports every problem at once so a broken deployment is fixed in one go
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port", "must be a number between 1 and 65535, got %q", c.Server.Port)
+	check(c.Server.ReadTimeout > 0, "server.read_timeout", "must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout", "must be positive")
+	u, err := url.Parse(c.Server.PublicURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "server.public_url", "must be an absolute http(s) URL, got %q", c.Server.PublicURL)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+
+	check(c.Database.URL != "" || c.Database.Host != "", "database", "url or host is required")
+	check(c.Database.MaxConns > 0, "database.max_conns", "must be positive")
+	check(c.Database.MinConns >= 0 && c.Database.MinConns <= c.Database.MaxConns, "database.min_conns", "must be between 0 and max_conns (%d)", c.Database.MaxConns)
+	check(c.Database.ConnectTimeout > 0, "database.connect_timeout", "must be positive")
+	check(c.Database.QueryTimeout >= 0, "database.query_timeout", "must not be negative")
+
+	check(c.Redis.Addr != "", "redis.addr", "is required")
+	check(c.Redis.DB >= 0, "redis.db", "must not be negative")
+	check(c.Redis.PoolSize >= 0, "redis.pool_size", "must not be negative")
+
+	check(c.JWT.TokenExpiry > 0, "jwt.token_expiry", "must be positive")
+	check(c.JWT.RefreshExpiry >= c.JWT.TokenExpiry, "jwt.refresh_expiry", "must not be shorter than token_expiry")
+	// В проде без ключа не стартуем: эфемерный ключ разлогинит всех при рестарте
+	check(c.Environment != "production" || c.JWT.PrivateKeyFile != "", "jwt.private_key_file", "must be set in production")
+
+	switch c.Broker.Type {
+	case "memory", "redis", "streams":
+	default:
+		check(false, "broker.type", "unknown broker %q, expected memory, redis or streams", c.Broker.Type)
+	}
+	check(c.Broker.StreamMaxLen > 0, "broker.stream_max_len", "must be positive")
+	check(c.Broker.Type != "streams" || c.Broker.NodeID != "", "broker.node_id", "is required for streams")
+
+	check(c.WebSocket.SendBuffer > 0, "websocket.send_buffer", "must be positive")
+	switch c.WebSocket.SlowConsumerPolicy {
+	case "disconnect", "drop_oldest":
+	default:
+		check(false, "websocket.slow_consumer_policy", "unknown policy %q, expected disconnect or drop_oldest", c.WebSocket.SlowConsumerPolicy)
+	}
+	check(c.WebSocket.MaxMessageSize > 0, "websocket.max_message_size", "must be positive")
+	check(c.WebSocket.WriteWait > 0, "websocket.write_wait", "must be positive")
+	check(c.WebSocket.PingInterval > 0 && c.WebSocket.PingInterval < c.WebSocket.PongWait, "websocket.ping_interval", "must be positive and shorter than pong_wait")
+
+	check(c.Persistence.QueueSize > 0, "persistence.queue_size", "must be positive")
+	check(c.Persistence.BatchSize > 0, "persistence.batch_size", "must be positive")
+	check(c.Persistence.MaxRetries > 0, "persistence.max_retries", "must be positive")
+	check(c.Persistence.FlushInterval > 0, "persistence.flush_interval", "must be positive")
+	check(c.Persistence.WALPath != "", "persistence.wal_path", "is required")
+
+	check(c.Storage.Dir != "", "storage.dir", "is required")
+	check(c.Storage.MaxUploadSize > 0, "storage.max_upload_size", "must be positive")
+
+	check(c.Mail.From != "", "mail.from", "is required")
+	check(c.Environment != "production" || c.Mail.SMTPAddr != "", "mail.smtp_addr", "must be set in production, otherwise verification letters only go to the log")
+
+	check(c.Account.DeletionGrace >= 0, "account.deletion_grace", "must not be negative")
+	check(c.Account.ExportTTL > 0, "account.export_ttl", "must be positive")
+
+	if c.OIDC.IssuerURL != "" {
+		check(c.OIDC.ClientID != "", "oidc.client_id", "is required when issuer_url is set")
+		check(c.OIDC.RedirectURL != "", "oidc.redirect_url", "is required when issuer_url is set")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"chat-app/migrations"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// MigrateCommands lists what Migrate understands, for usage messages
+const MigrateCommands = "up, down, redo, status, version"
+
+// Migrate runs a goose command against the embedded migrations. Все команды
+// выполняются под advisory lock, поэтому несколько реплик при старте не подерутся.
+func Migrate(ctx context.Context, db *sql.DB, table, command string, out io.Writer) error {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return err
+	}
+
+	opts := []goose.ProviderOption{goose.WithSessionLocker(locker)}
+	if table != "" {
+		opts = append(opts, goose.WithTableName(table))
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrations.FS, opts...)
+	if err != nil {
+		return fmt.Errorf("load migrations: %w", err)
+	}
+
+	switch command {
+	case "up":
+		results, err := provider.Up(ctx)
+		printResults(out, results)
+		return err
+
+	case "down":
+		result, err := provider.Down(ctx)
+		printResults(out, []*goose.MigrationResult{result})
+		return err
+
+	case "redo":
+		down, err := provider.Down(ctx)
+		printResults(out, []*goose.MigrationResult{down})
+		if err != nil {
+			return err
+		}
+		up, err := provider.UpByOne(ctx)
+		printResults(out, []*goose.MigrationResult{up})
+		return err
+
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "Pending"
+			if s.State == goose.StateApplied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%-19s  %s\n", applied, s.Source.Path)
+		}
+		return nil
+
+	case "version":
+		version, err := provider.GetDBVersion(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "version", strconv.FormatInt(version, 10))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected one of: %s", command, MigrateCommands)
+	}
+}
+
+func printResults(out io.Writer, results []*goose.MigrationResult) {
+	if len(results) == 0 || (len(results) == 1 && results[0] == nil) {
+		fmt.Fprintln(out, "no migrations to run")
+		return
+	}
+	for _, r := range results {
+		if r != nil {
+			fmt.Fprintln(out, r)
+		}
+	}
+}
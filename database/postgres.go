@@ -1,63 +1,105 @@
 package database
 
 import (
+	"chat-app/config"
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
-var DB *sql.DB
+// Имена подготовленных запросов для горячих путей. Готовятся на соединении при первом
+// использовании (Prepared), а не в AfterConnect: на пустой базе таблиц ещё нет до миграций.
+const (
+	StmtInsertMessage = "insert_message"
+	StmtChatHistory   = "chat_history"
+)
+
+var preparedStatements = map[string]string{
+	StmtInsertMessage: `
+INSERT INTO messages (uuid, chat_uuid, sender_uuid, content, created_at, is_read)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (uuid) DO NOTHING`,
+	StmtChatHistory: `
+SELECT uuid, sender_uuid, content, created_at, is_read
+FROM messages
+WHERE chat_uuid = $1
+ORDER BY created_at ASC`,
+}
 
+// Database is the shared Postgres pool plus the per-query time limit
 type Database struct {
-	DB *sql.DB
+	Pool         *pgxpool.Pool
+	QueryTimeout time.Duration
 }
 
-//func NewDatabase(connectionString string) (*Database, error) {
-//	//Open database connection
-//	db, err := sql.Open("postgres", connectionString)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	// Configure connection pool
-//	db.SetMaxOpenConns(25)
-//	db.SetMaxIdleConns(5)
-//	db.SetConnMaxLifetime(time.Minute * 5)
-//
-//	// Verify connection is working
-//	if err := db.Ping(); err != nil {
-//		return nil, err
-//	}
-//
-//	return &Database{DB: db}, nil
-//}
-
-func NewDatabase(connectionString string) (*Database, error) {
-	// Пытаемся подключиться к реальной БД
-	db, err := sql.Open("postgres", connectionString)
+// New connects a pgx pool configured from cfg.Database and checks it with a ping
+func New(ctx context.Context, cfg *config.Config) (*Database, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.GetDSN())
+	if err != nil {
+		return nil, fmt.Errorf("parse database config: %w", err)
+	}
+
+	poolCfg.MaxConns = cfg.Database.MaxConns
+	poolCfg.MinConns = cfg.Database.MinConns
+	poolCfg.MaxConnLifetime = cfg.Database.MaxConnLifetime
+	poolCfg.MaxConnIdleTime = cfg.Database.MaxConnIdleTime
+	poolCfg.ConnConfig.ConnectTimeout = cfg.Database.ConnectTimeout
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
-		log.Println("БД не доступна, работаем без неё:", err)
-		// Возвращаем заглушку — сервер не упадёт
-		return &Database{DB: &sql.DB{}}, nil
+		return nil, err
 	}
 
-	// Настраиваем пул соединений
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(time.Minute * 5)
+	pingCtx, cancel := context.WithTimeout(ctx, cfg.Database.ConnectTimeout)
+	defer cancel()
+	if err := pool.Ping(pingCtx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ping database: %w", err)
+	}
+
+	log.Printf("Успешно подключились к PostgreSQL (пул до %d соединений)", poolCfg.MaxConns)
+	return &Database{Pool: pool, QueryTimeout: cfg.Database.QueryTimeout}, nil
+}
 
-	// Проверяем, что БД жива
-	if err := db.Ping(); err != nil {
-		log.Println("БД не отвечает, работаем без неё:", err)
-		db.Close()
-		return &Database{DB: &sql.DB{}}, nil
+// Prepared acquires a connection with the named statement prepared on it.
+// pgx помнит подготовленные запросы соединения, поэтому повторный Prepare не ходит в БД.
+// Соединение нужно вернуть через Release.
+func (d *Database) Prepared(ctx context.Context, name string) (*pgxpool.Conn, error) {
+	query, ok := preparedStatements[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown prepared statement %q", name)
 	}
 
-	DB = db
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Conn().Prepare(ctx, name, query); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("prepare %s: %w", name, err)
+	}
+	return conn, nil
+}
+
+// WithTimeout bounds a single query by QueryTimeout while keeping the caller's
+// cancellation: отменённый HTTP-запрос прерывает и запрос в БД
+func (d *Database) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.QueryTimeout)
+}
+
+// SQLDB exposes the pool as *sql.DB for libraries that need database/sql, such as goose
+func (d *Database) SQLDB() *sql.DB {
+	return stdlib.OpenDBFromPool(d.Pool)
+}
 
-	log.Println("Успешно подключились к PostgreSQL")
-	return &Database{DB: db}, nil
+func (d *Database) Close() {
+	d.Pool.Close()
 }
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"chat-app/internal/account"
+	"chat-app/internal/auth"
+	"chat-app/internal/repository"
+	"errors"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AccountHandler serves data exports and account deletion
+type AccountHandler struct {
+	accounts *account.Service
+	users    repository.UserRepository
+	reauth   *Reauth
+}
+
+// NewAccountHandler creates an account handler
+func NewAccountHandler(accounts *account.Service, users repository.UserRepository, reauth *Reauth) *AccountHandler {
+	return &AccountHandler{accounts: accounts, users: users, reauth: reauth}
+}
+
+// StartExport begins building an archive of everything stored about the user.
+// Когда архив готов, ссылка приходит в сокет уведомлений и на почту.
+func (h *AccountHandler) StartExport(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	export, err := h.accounts.StartExport(c.Request.Context(), userUUID)
+	if errors.Is(err, account.ErrExportRunning) {
+		c.JSON(409, gin.H{"error": "an export is already being prepared"})
+		return
+	}
+	if err != nil {
+		log.Printf("Не удалось начать выгрузку для %s: %v", userUUID, err)
+		c.JSON(500, gin.H{"error": "failed to start export"})
+		return
+	}
+
+	c.JSON(202, gin.H{"export": export})
+}
+
+// GetExport returns the state of the latest export with its download link when ready
+func (h *AccountHandler) GetExport(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	export, err := h.accounts.LatestExport(userUUID)
+	if err != nil {
+		log.Printf("Не удалось прочитать выгрузку %s: %v", userUUID, err)
+		c.JSON(500, gin.H{"error": "failed to load export"})
+		return
+	}
+	if export == nil {
+		c.JSON(404, gin.H{"error": "no export"})
+		return
+	}
+
+	c.JSON(200, gin.H{"export": export})
+}
+
+// DownloadExport serves the archive by its signed link; авторизация — подпись в ссылке
+func (h *AccountHandler) DownloadExport(c *gin.Context) {
+	exportID := c.Param("export_id")
+	path, err := h.accounts.ExportFile(exportID, c.Query("token"))
+	switch {
+	case errors.Is(err, auth.ErrExpiredToken):
+		c.JSON(410, gin.H{"error": "link expired"})
+		return
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(404, gin.H{"error": "export not found"})
+		return
+	case err != nil:
+		c.JSON(403, gin.H{"error": "invalid link"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	c.FileAttachment(path, "export-"+exportID+".zip")
+}
+
+// ScheduleDeletion plans the account's erasure after the grace period.
+// Все сессии завершаются; войти снова и отменить удаление можно до срока.
+// Подтверждение — пароль или, у аккаунта без пароля, см. Reauth.
+func (h *AccountHandler) ScheduleDeletion(c *gin.Context) {
+	var input ReauthInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	user, err := h.users.GetByUUID(c.Request.Context(), userUUID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+
+	if !h.reauth.Require(c, user, input) {
+		return
+	}
+
+	at, err := h.accounts.ScheduleDeletion(c.Request.Context(), userUUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to schedule deletion"})
+		return
+	}
+
+	c.JSON(202, gin.H{"message": "account deletion scheduled", "deletion_scheduled_at": at})
+}
+
+// CancelDeletion keeps the account during the grace period
+func (h *AccountHandler) CancelDeletion(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	err = h.accounts.CancelDeletion(c.Request.Context(), userUUID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(404, gin.H{"error": "no deletion pending"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "account deletion cancelled"})
+}
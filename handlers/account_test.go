@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"chat-app/internal/account"
+	"chat-app/internal/repository"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// newAccountEnv adds AccountHandler routes to an SSO env; the user is linked to ext-1
+func newAccountEnv(t *testing.T) *ssoEnv {
+	t.Helper()
+	env := newSSOEnv(t)
+
+	chats := repository.NewMemoryChats()
+	messages := repository.NewMemoryMessages()
+	accounts := account.New(account.Deps{
+		Accounts: repository.NewMemoryAccounts(env.users, chats, messages, repository.NewMemoryContacts(), repository.NewMemoryBlocks()),
+		Users:    env.users,
+		Chats:    chats,
+		Messages: messages,
+		Tokens:   env.tokens,
+	}, account.Options{StorageDir: t.TempDir(), DeletionGrace: time.Hour})
+	t.Cleanup(func() { accounts.Close() })
+
+	h := NewAccountHandler(accounts, env.users, NewReauth(env.tokens, env.twoFactor))
+	env.authed.POST("/account/delete", h.ScheduleDeletion)
+
+	code, resp := env.sso(t, jwt.MapClaims{"sub": "ext-1", "email": env.user.Email, "email_verified": true})
+	expectStatus(t, "link", code, http.StatusOK, resp)
+	return env
+}
+
+func (e *ssoEnv) deletionScheduled(t *testing.T) bool {
+	t.Helper()
+	user, err := e.users.GetByUUID(context.Background(), e.user.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.DeletionScheduledAt != nil
+}
+
+func TestScheduleDeletionWithPassword(t *testing.T) {
+	env := newAccountEnv(t)
+
+	code, resp := env.do(t, http.MethodPost, "/account/delete", gin.H{})
+	expectStatus(t, "no password", code, http.StatusBadRequest, resp)
+	code, resp = env.do(t, http.MethodPost, "/account/delete", gin.H{"password": "wrong password"})
+	expectStatus(t, "wrong password", code, http.StatusUnauthorized, resp)
+
+	// Пока пароль есть, SSO его не заменяет
+	_, reauth := env.reauth(t, jwt.MapClaims{"sub": "ext-1", "auth_time": time.Now().Unix()})
+	code, resp = env.do(t, http.MethodPost, "/account/delete", gin.H{"reauth_token": reauth["reauth_token"]})
+	expectStatus(t, "reauth_token instead of password", code, http.StatusBadRequest, resp)
+	if env.deletionScheduled(t) {
+		t.Fatal("deletion scheduled without the password")
+	}
+
+	code, resp = env.do(t, http.MethodPost, "/account/delete", gin.H{"password": testPassword})
+	expectStatus(t, "delete", code, http.StatusAccepted, resp)
+	if !env.deletionScheduled(t) {
+		t.Error("deletion not scheduled")
+	}
+}
+
+func TestScheduleDeletionWithoutPassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		with2FA bool
+		body    func(env *ssoEnv, secret string, recovery []string) gin.H
+		want    int
+	}{
+		{"nothing", false, func(*ssoEnv, string, []string) gin.H { return gin.H{} }, http.StatusBadRequest},
+		{"empty password", false, func(*ssoEnv, string, []string) gin.H { return gin.H{"password": ""} }, http.StatusBadRequest},
+		{"fresh sso login", false, func(env *ssoEnv, _ string, _ []string) gin.H {
+			_, resp := env.reauth(t, jwt.MapClaims{"sub": "ext-1", "auth_time": time.Now().Unix()})
+			return gin.H{"reauth_token": resp["reauth_token"]}
+		}, http.StatusAccepted},
+		{"reauth token of another user", false, func(env *ssoEnv, _ string, _ []string) gin.H {
+			token, _ := env.tokens.IssueReauth(uuid.New())
+			return gin.H{"reauth_token": token}
+		}, http.StatusUnauthorized},
+		{"access token as reauth token", false, func(env *ssoEnv, _ string, _ []string) gin.H {
+			token, _ := env.tokens.IssueAccess(env.user.UUID, env.user.Email, "", nil)
+			return gin.H{"reauth_token": token}
+		}, http.StatusUnauthorized},
+		{"2FA code without 2FA", false, func(*ssoEnv, string, []string) gin.H { return gin.H{"code": "123456"} }, http.StatusBadRequest},
+		{"2FA code", true, func(_ *ssoEnv, secret string, _ []string) gin.H {
+			return gin.H{"code": totpCode(t, secret, time.Now().Add(30*time.Second))}
+		}, http.StatusAccepted},
+		{"code that confirmed 2FA", true, func(_ *ssoEnv, secret string, _ []string) gin.H {
+			return gin.H{"code": totpCode(t, secret, time.Now())}
+		}, http.StatusUnauthorized},
+		{"recovery code", true, func(_ *ssoEnv, _ string, recovery []string) gin.H {
+			return gin.H{"recovery_code": recovery[0]}
+		}, http.StatusAccepted},
+		{"wrong recovery code", true, func(*ssoEnv, string, []string) gin.H { return gin.H{"recovery_code": "nope"} }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newAccountEnv(t)
+			var secret string
+			var recovery []string
+			if tt.with2FA {
+				secret, recovery = env.enableTwoFactor(t)
+			}
+			env.dropPassword(t)
+
+			code, resp := env.do(t, http.MethodPost, "/account/delete", tt.body(env, secret, recovery))
+			expectStatus(t, "delete", code, tt.want, resp)
+			if scheduled := env.deletionScheduled(t); scheduled != (tt.want == http.StatusAccepted) {
+				t.Errorf("deletion scheduled = %v", scheduled)
+			}
+		})
+	}
+}
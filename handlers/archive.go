@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"chat-app/internal/chatarchive"
+	"chat-app/internal/models"
+	"chat-app/internal/names"
+	"chat-app/internal/repository"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxImportSize bounds an uploaded archive; выгрузки Telegram без медиа редко больше сотни мегабайт
+const maxImportSize = 512 << 20
+
+// ArchiveHandler exports chats into archives and imports them, see chatarchive
+type ArchiveHandler struct {
+	users    repository.UserRepository
+	chats    repository.ChatRepository
+	messages repository.MessageRepository
+	names    *names.Service
+	importer *chatarchive.Importer
+}
+
+// NewArchiveHandler creates an archive handler
+func NewArchiveHandler(users repository.UserRepository, chats repository.ChatRepository, messages repository.MessageRepository, displayNames *names.Service, importer *chatarchive.Importer) *ArchiveHandler {
+	return &ArchiveHandler{
+		users:    users,
+		chats:    chats,
+		messages: messages,
+		names:    displayNames,
+		importer: importer,
+	}
+}
+
+// ExportChat downloads a chat the user takes part in, без email собеседников
+func (h *ArchiveHandler) ExportChat(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+	chatUUID, err := uuid.Parse(c.Param("chat_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid chat uuid"})
+		return
+	}
+
+	chat, err := h.chats.Get(c.Request.Context(), chatUUID)
+	if err != nil || !chat.HasParticipant(userUUID) {
+		c.JSON(403, gin.H{"error": "access denied"})
+		return
+	}
+
+	h.export(c, chat, false)
+}
+
+// AdminExportChat downloads any chat with participants' emails, для переноса на другой сервер
+func (h *ArchiveHandler) AdminExportChat(c *gin.Context) {
+	chatUUID, err := uuid.Parse(c.Param("chat_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid chat uuid"})
+		return
+	}
+
+	chat, err := h.chats.Get(c.Request.Context(), chatUUID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(404, gin.H{"error": "chat not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	log.Printf("Администратор %s выгрузил чат %s", c.GetString("user_uuid"), chatUUID)
+	h.export(c, chat, true)
+}
+
+func (h *ArchiveHandler) export(c *gin.Context, chat *models.Chat, withEmails bool) {
+	ctx := c.Request.Context()
+
+	history, err := h.messages.ListByChat(ctx, chat.UUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	h.names.Enrich(ctx, history)
+
+	// Авторы, которые уже вышли из чата, тоже нужны: их имена и email
+	ids := append([]uuid.UUID(nil), chat.Participants...)
+	for _, m := range history {
+		ids = append(ids, m.SenderUUID)
+	}
+	users, err := h.users.ListByUUIDs(ctx, ids)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="chat-`+chat.UUID.String()+`.zip"`)
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(200)
+
+	// Заголовки уже ушли, поэтому ошибку можно только записать в лог
+	if err := chatarchive.Write(c.Writer, chat, history, users, withEmails); err != nil {
+		log.Printf("Не удалось выгрузить чат %s: %v", chat.UUID, err)
+	}
+}
+
+// ImportChat loads an archive or a Telegram Desktop export as a chat.
+// Form fields: file — архив; senders — JSON-объект «отправитель в источнике → email»;
+// unknown_senders — fail (по умолчанию) или anonymous.
+func (h *ArchiveHandler) ImportChat(c *gin.Context) {
+	adminUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "file is required and must not exceed 512 MB"})
+		return
+	}
+
+	opts := chatarchive.ImportOptions{
+		Unknown: c.PostForm("unknown_senders"),
+		Creator: adminUUID,
+	}
+	if senders := c.PostForm("senders"); senders != "" {
+		if err := json.Unmarshal([]byte(senders), &opts.Senders); err != nil {
+			c.JSON(400, gin.H{"error": "senders must be a JSON object of sender to email"})
+			return
+		}
+	}
+	if opts.Unknown != "" && opts.Unknown != chatarchive.UnknownFail && opts.Unknown != chatarchive.UnknownAnonymous {
+		c.JSON(400, gin.H{"error": "unknown_senders must be fail or anonymous"})
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to read upload"})
+		return
+	}
+	defer f.Close()
+
+	archive, err := chatarchive.Read(f, header.Size)
+	var versionErr *chatarchive.VersionError
+	switch {
+	case errors.As(err, &versionErr), errors.Is(err, chatarchive.ErrTooLarge):
+		c.JSON(422, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(422, gin.H{"error": "not a chat archive or Telegram export: " + err.Error()})
+		return
+	}
+
+	result, err := h.importer.Import(c.Request.Context(), archive, opts)
+	var unmatched *chatarchive.UnmatchedError
+	switch {
+	case errors.As(err, &unmatched):
+		c.JSON(422, gin.H{
+			"error":             "some senders have no account, map them in senders or pass unknown_senders=anonymous",
+			"unmatched_senders": unmatched.Senders,
+		})
+		return
+	case errors.Is(err, chatarchive.ErrNoParticipants):
+		c.JSON(422, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Импорт чата не удался: %v", err)
+		c.JSON(500, gin.H{"error": "import failed"})
+		return
+	}
+
+	log.Printf("Администратор %s импортировал %d сообщений (%s) в чат %s",
+		adminUUID, result.Imported, archive.Source, result.ChatUUID)
+	status := 200
+	if result.Created {
+		status = 201
+	}
+	c.JSON(status, gin.H{"source": archive.Source, "result": result})
+}
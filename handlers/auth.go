@@ -1,33 +1,48 @@
 package handlers
 
 import (
-	_ "archive/zip"
-	"chat-app/database"
+	"chat-app/internal/auth"
 	"chat-app/internal/models"
+	"chat-app/internal/ratelimit"
+	"chat-app/internal/repository"
+	"chat-app/middleware"
 	"chat-app/utils"
-	"database/sql"
+	"context"
 	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 type AuthHandler struct {
-	db              *sql.DB
-	jwtSecret       []byte
+	users           repository.UserRepository
+	twoFactor       repository.TwoFactorRepository
+	tokens          *auth.TokenService
+	guard           *ratelimit.LoginGuard
 	tokenExpiration time.Duration
 }
 
+// dummyPasswordHash is compared against when the email is unknown so the
+// response time doesn't reveal whether an account exists
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := utils.HashPassword("dummy-password-for-timing")
+	return hash
+})
+
 // NewAuthHandler creates a new authentication handler
-func NewAuthHandler(jwtSecret []byte) *AuthHandler {
+func NewAuthHandler(users repository.UserRepository, twoFactor repository.TwoFactorRepository, tokens *auth.TokenService, guard *ratelimit.LoginGuard) *AuthHandler {
 	return &AuthHandler{
-		db:              database.DB,
-		jwtSecret:       jwtSecret,
-		tokenExpiration: 24 * time.Hour,
+		users:           users,
+		twoFactor:       twoFactor,
+		tokens:          tokens,
+		guard:           guard,
+		tokenExpiration: tokens.AccessTTL(),
 	}
 }
 
@@ -48,9 +63,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	var exists bool
-	err := h.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`,
-		user.Email).Scan(&exists)
+	ctx := c.Request.Context()
+
+	exists, err := h.users.EmailExists(ctx, user.Email)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -60,22 +75,26 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	var userUUID uuid.UUID
-	err = h.db.QueryRow(`INSERT INTO users (name, surname, email, password_hash) VALUES ($1, $2, $3, $4) RETURNING uuid`,
-		user.Name, user.Surname, user.Email, user.Password,
-	).Scan(&userUUID)
-
-	if err != nil {
+	created := &models.User{
+		Name:         user.Name,
+		Surname:      user.Surname,
+		Email:        user.Email,
+		PasswordHash: user.Password,
+	}
+	if err := h.users.Create(ctx, created); errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "User creation error"})
 		return
 	}
 
 	c.JSON(http.StatusAccepted, gin.H{
 		"message":   "Registration accepted. Processing in background...",
-		"user_uuid": userUUID,
+		"user_uuid": created.UUID,
 	})
 
-	go h.finalizeRegistration(userUUID, user.Password)
+	go h.finalizeRegistration(created.UUID, user.Password)
 }
 
 func (h *AuthHandler) finalizeRegistration(userUUID uuid.UUID, plainPassword string) {
@@ -85,26 +104,15 @@ func (h *AuthHandler) finalizeRegistration(userUUID uuid.UUID, plainPassword str
 		return
 	}
 
-	result, err := h.db.Exec(`
-UPDATE users
-SET password_hash = $1, updated_at = NOW()
-WHERE uuid = $2`,
-		hashedPassword, userUUID)
-
-	if err != nil {
-		log.Printf("Failed to finalize user %s: %v", userUUID, err)
+	err = h.users.UpdatePasswordHash(context.Background(), userUUID, hashedPassword)
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Printf("User %s was already processed or not found", userUUID)
 		return
 	}
-
-	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		log.Printf("Failed to finalize user %s: %v", userUUID, err)
 		return
 	}
-	if rowsAffected == 0 {
-		log.Printf("User %s was already processed or not found", userUUID)
-		return
-	}
 
 	log.Printf("User %s successfully registered and activated", userUUID)
 }
@@ -117,38 +125,64 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	err := h.db.QueryRow(`
-SELECT uuid, email, password_hash
-FROM users
-WHERE email = $1`,
-		login.Email,
-	).Scan(&user.UUID, &user.Email, &user.PasswordHash)
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
 
-	if errors.Is(err, sql.ErrNoRows) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	if wait := h.guard.Check(ctx, login.Email, ip); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts, try again later"})
 		return
 	}
-	if err != nil {
+
+	user, err := h.users.GetByEmail(ctx, login.Email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
 		return
 	}
 
+	// Для несуществующего email тоже считаем bcrypt и ошибку, ответ не отличается
+	if errors.Is(err, repository.ErrNotFound) {
+		utils.CheckPasswordHash(login.Password, dummyPasswordHash())
+		h.guard.Failure(ctx, login.Email, ip)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
 	if !utils.CheckPasswordHash(login.Password, user.PasswordHash) {
+		h.guard.Failure(ctx, login.Email, ip)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	now := time.Now()
-	claims := jwt.MapClaims{
-		"user_uuid": user.UUID,
-		"email":     user.Email,
-		"iat":       now.Unix(),
-		"exp":       now.Add(h.tokenExpiration).Unix(),
+	h.guard.Success(ctx, login.Email)
+
+	h.completeLogin(c, user.UUID, user.Email, user.TOTPEnabled)
+}
+
+// completeLogin finishes a successful first factor (password or SSO)
+func (h *AuthHandler) completeLogin(c *gin.Context, userUUID uuid.UUID, email string, totpEnabled bool) {
+	// С включённой 2FA первый фактор — только первый шаг, токен выдаём после кода
+	if totpEnabled {
+		challenge, err := h.tokens.IssueChallenge(userUUID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          h.tokens.ChallengeTTL().Seconds(),
+		})
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(h.jwtSecret)
+	h.respondWithAccessToken(c, userUUID, email)
+}
+
+// respondWithAccessToken issues an access token and writes the login response
+func (h *AuthHandler) respondWithAccessToken(c *gin.Context, userUUID uuid.UUID, email string) {
+	tokenString, err := h.tokens.IssueAccess(userUUID, email, "", nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
 		return
@@ -161,23 +195,15 @@ WHERE email = $1`,
 	})
 }
 
-// Login handles user authentication and JWT generation
+// RefreshToken issues a fresh access token for the current session
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
-	userUUID, exists := c.Get("user_uuid")
+	claims, exists := middleware.Claims(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
-	now := time.Now()
-	claims := jwt.MapClaims{
-		"user_uuid": userUUID,
-		"iat":       now.Unix(),
-		"exp":       now.Add(h.tokenExpiration).Unix(),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(h.jwtSecret)
+	tokenString, err := h.tokens.Refresh(claims)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
 		return
@@ -190,110 +216,30 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	})
 }
 
-// Logout endpoint (optional - useful for client-side cleanup)
-func (h *AuthHandler) Logout(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Successfully logged out",
-		"instruction": "Please remove the token from your client storage",
-	})
-}
-
-func (h *AuthHandler) SendMessage(c *gin.Context) {
+// UnlockLogin lifts a brute-force lockout for an email and/or IP (admin only)
+func (h *AuthHandler) UnlockLogin(c *gin.Context) {
 	var input struct {
-		ChatUUID uuid.UUID `json:"chat_uuid"`
-		Text     string    `json:"text"`
+		Email string `json:"email"`
+		IP    string `json:"ip"`
 	}
-
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Email == "" && input.IP == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email or ip is required"})
 		return
 	}
 
-	if input.Text == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Message text is required"})
+	if err := h.guard.Unlock(c.Request.Context(), input.Email, input.IP); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unlock failed"})
 		return
 	}
 
-	senderUUID, _ := c.Get("user_uuid")
-	senderUUIDStr, ok := senderUUID.(string)
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user uuid"})
-		return
-	}
-
-	var senderName string
-	var name, surname sql.NullString
-	err := h.db.QueryRow(`
-		SELECT COALESCE(name, ''), COALESCE(surname, '') FROM users WHERE uuid = $1
-	`, senderUUIDStr).Scan(&name, &surname)
-
-	if err == nil {
-		if name.Valid && surname.Valid && name.String != "" && surname.String != "" {
-			senderName = name.String + " " + surname.String
-		} else if name.Valid && name.String != "" {
-			senderName = name.String
-		} else if surname.Valid && surname.String != "" {
-			senderName = surname.String
-		} else {
-			senderName = "пользователь"
-		}
-	} else {
-		senderName = "пользователь"
-	}
-
-	var msgUUID uuid.UUID
-	err = h.db.QueryRow(`
-		INSERT INTO messages (chat_uuid, sender_uuid, sender_name, content, created_at, is_read)
-		VALUES ($1, $2, $3, $4, NOW(), false)
-		RETURNING uuid
-	`, input.ChatUUID, senderUUIDStr, senderName, input.Text).Scan(&msgUUID) // <-- input.Text вместо input.Content
-
-	if err != nil {
-		log.Printf("Ошибка сохранения сообщения: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Message sent", "uuid": msgUUID})
+	log.Printf("Login lockout lifted by %s: email=%q ip=%q", c.GetString("user_uuid"), input.Email, input.IP)
+	c.JSON(http.StatusOK, gin.H{"message": "Unlocked"})
 }
 
-func GetUserProfile(c *gin.Context) {
-	userUUIDStr := c.GetString("user_uuid")
-	if userUUIDStr == "" {
-		c.JSON(401, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	userUUID, err := uuid.Parse(userUUIDStr)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "invalid user uuid"})
-		return
-	}
-
-	var user struct {
-		UUID      string    `json:"uuid"`
-		Name      string    `json:"name"`
-		Surname   string    `json:"surname"`
-		Email     string    `json:"email"`
-		CreatedAt time.Time `json:"created_at"`
-	}
-
-	err = database.DB.QueryRow(`
-		SELECT uuid, name, surname, email, created_at
-		FROM users
-		WHERE uuid = $1
-	`, userUUID).Scan(&user.UUID, &user.Name, &user.Surname, &user.Email, &user.CreatedAt)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(404, gin.H{"error": "user not found"})
-			return
-		}
-		c.JSON(500, gin.H{"error": "database error"})
-		return
-	}
-
-	c.JSON(200, gin.H{
-		"user": user,
+// Logout endpoint (optional - useful for client-side cleanup)
+func (h *AuthHandler) Logout(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Successfully logged out",
+		"instruction": "Please remove the token from your client storage",
 	})
 }
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"chat-app/internal/blocks"
+	"chat-app/internal/names"
+	"chat-app/internal/repository"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// BlockHandler manages the current user's block list
+type BlockHandler struct {
+	blocks *blocks.Service
+	users  repository.UserRepository
+	names  *names.Service
+}
+
+// NewBlockHandler creates a block list handler
+func NewBlockHandler(blockList *blocks.Service, users repository.UserRepository, displayNames *names.Service) *BlockHandler {
+	return &BlockHandler{blocks: blockList, users: users, names: displayNames}
+}
+
+// ListBlocks returns who the user has blocked, newest first
+func (h *BlockHandler) ListBlocks(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	list, err := h.blocks.List(ctx, userUUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	ids := make([]uuid.UUID, len(list))
+	for i, b := range list {
+		ids[i] = b.BlockedUUID
+	}
+	displayNames := h.names.Names(ctx, ids)
+
+	result := make([]gin.H, len(list))
+	for i, b := range list {
+		result[i] = gin.H{
+			"user_uuid":  b.BlockedUUID,
+			"name":       displayNames[b.BlockedUUID],
+			"created_at": b.CreatedAt,
+		}
+	}
+
+	c.JSON(200, gin.H{"blocks": result})
+}
+
+// BlockUser adds a user to the block list; повторная блокировка ничего не меняет
+func (h *BlockHandler) BlockUser(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	var input struct {
+		UserUUID uuid.UUID `json:"user_uuid" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": "нужен параметр user_uuid"})
+		return
+	}
+	if input.UserUUID == userUUID {
+		c.JSON(400, gin.H{"error": "нельзя заблокировать себя"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.users.GetByUUID(ctx, input.UserUUID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "пользователь не найден"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if err := h.blocks.Block(ctx, userUUID, input.UserUUID); err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "blocked"})
+}
+
+// UnblockUser removes a user from the block list
+func (h *BlockHandler) UnblockUser(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	blockedUUID, err := uuid.Parse(c.Param("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	if err := h.blocks.Unblock(c.Request.Context(), userUUID, blockedUUID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "пользователь не заблокирован"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "unblocked"})
+}
@@ -1,18 +1,47 @@
 package handlers
 
 import (
-	"chat-app/database"
-	"database/sql"
-	"encoding/json"
+	"chat-app/internal/blocks"
+	"chat-app/internal/contacts"
+	"chat-app/internal/models"
+	"chat-app/internal/names"
+	"chat-app/internal/repository"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-func CreateDirectChat(c *gin.Context) {
-	userUUIDStr := c.GetString("user_uuid")
-	userUUID, err := uuid.Parse(userUUIDStr)
+// ChatHandler serves chats and message history
+type ChatHandler struct {
+	users    repository.UserRepository
+	chats    repository.ChatRepository
+	messages repository.MessageRepository
+	names    *names.Service
+	blocks   *blocks.Service
+	contacts *contacts.Service
+}
+
+// NewChatHandler creates a chat handler on top of the given repositories
+func NewChatHandler(users repository.UserRepository, chats repository.ChatRepository, messages repository.MessageRepository, displayNames *names.Service, blockList *blocks.Service, contactList *contacts.Service) *ChatHandler {
+	return &ChatHandler{
+		users:    users,
+		chats:    chats,
+		messages: messages,
+		names:    displayNames,
+		blocks:   blockList,
+		contacts: contactList,
+	}
+}
+
+func (h *ChatHandler) CreateDirectChat(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
 	if err != nil {
 		c.JSON(400, gin.H{"error": "invalid user uuid"})
 		return
@@ -28,17 +57,16 @@ func CreateDirectChat(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
 	var otherUserUUID uuid.UUID
 
 	if input.WithEmail != "" {
-		err = database.DB.QueryRow(`
-SELECT uuid FROM users WHERE email = $1
-`, input.WithEmail).Scan(&otherUserUUID)
-
+		other, err := h.users.GetByEmail(ctx, input.WithEmail)
 		if err != nil {
 			c.JSON(400, gin.H{"err": "пользователь с таким email не найден"})
 			return
 		}
+		otherUserUUID = other.UUID
 	} else if input.WithUUID != uuid.Nil {
 		otherUserUUID = input.WithUUID
 	} else {
@@ -46,40 +74,57 @@ SELECT uuid FROM users WHERE email = $1
 		return
 	}
 
-	// Проверяем, существует ли уже такой чат
-	var existingChatUUID string
-	participants := []string{userUUIDStr, otherUserUUID.String()}
-	participantsJSON, _ := json.Marshal(participants)
+	if otherUserUUID == userUUID {
+		c.JSON(400, gin.H{"error": "нельзя писать себе"})
+		return
+	}
 
-	err = database.DB.QueryRow(`
-		SELECT uuid FROM chats
-		WHERE type = 'direct'
-		AND participants = $1
-	`, string(participantsJSON)).Scan(&existingChatUUID)
+	// Заблокировавшие друг друга не могут начать личный чат, в какую бы сторону ни была блокировка
+	if blocked, err := h.blocks.Between(ctx, userUUID, otherUserUUID); err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	} else if blocked {
+		c.JSON(403, gin.H{"error": "нельзя написать этому пользователю"})
+		return
+	}
 
-	if err == nil {
-		c.JSON(200, gin.H{"chat_uuid": existingChatUUID, "message": "чат уже существует"})
+	// Кто принимает сообщения только от контактов, тому сначала отправляют заявку
+	if allowed, err := h.contacts.CanMessage(ctx, userUUID, otherUserUUID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "пользователь не найден"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	} else if !allowed {
+		c.JSON(403, gin.H{"error": "пользователь принимает сообщения только от контактов"})
 		return
 	}
 
-	chatUUID := uuid.New()
+	// Проверяем, существует ли уже такой чат
+	participants := []uuid.UUID{userUUID, otherUserUUID}
 
-	_, err = database.DB.Exec(`
-		INSERT INTO chats (uuid, type, participants, creator_uuid, created_at, updated_at)
-		VALUES ($1, 'direct', $2, $3, $4, $4)
-	`, chatUUID, string(participantsJSON), userUUID, time.Now())
+	existingChatUUID, err := h.chats.FindDirect(ctx, participants)
+	if err == nil {
+		c.JSON(200, gin.H{"chat_uuid": existingChatUUID, "message": "чат уже существует"})
+		return
+	}
 
-	if err != nil {
+	chat := &models.Chat{
+		Type:         models.ChatDirect,
+		Participants: participants,
+		CreatorUUID:  userUUID,
+	}
+	if err := h.chats.Create(ctx, chat); err != nil {
 		c.JSON(500, gin.H{"error": "не удалось создать чат"})
 		return
 	}
 
-	c.JSON(200, gin.H{"chat_uuid": chatUUID})
+	c.JSON(200, gin.H{"chat_uuid": chat.UUID})
 }
 
-func CreateGroupChat(c *gin.Context) {
-	userUUIDStr := c.GetString("user_uuid")
-	userUUID, err := uuid.Parse(userUUIDStr)
+func (h *ChatHandler) CreateGroupChat(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
 	if err != nil {
 		c.JSON(400, gin.H{"error": "invalid user uuid"})
 		return
@@ -95,262 +140,439 @@ func CreateGroupChat(c *gin.Context) {
 		return
 	}
 
-	chatUUID := uuid.New()
-	participants := []string{userUUIDStr}
-
-	if input.Participants != nil && len(input.Participants) > 0 {
-		for _, p := range input.Participants {
-			if p != userUUIDStr && p != "" {
-				var exists bool
+	ctx := c.Request.Context()
+	participants := []uuid.UUID{userUUID}
 
-				err := database.DB.QueryRow(`
-SELECT EXISTS(SELECT 1 FROM users WHERE uuid = $1)
-`, p).Scan(&exists)
+	for _, p := range input.Participants {
+		participantUUID, err := uuid.Parse(p)
+		if err != nil || participantUUID == userUUID {
+			continue
+		}
 
-				if err == nil && exists {
-					participants = append(participants, p)
-				}
-			}
+		if exists, err := h.users.Exists(ctx, participantUUID); err == nil && exists {
+			participants = append(participants, participantUUID)
 		}
 	}
 
-	participantsJSON, _ := json.Marshal(participants)
-
-	_, err = database.DB.Exec(`
-		INSERT INTO chats (uuid, type, name, participants, creator_uuid, created_at, updated_at)
-		VALUES ($1, 'group', $2, $3, $4, $5, $5)
-	`, chatUUID, input.Name, string(participantsJSON), userUUID, time.Now())
-
-	if err != nil {
+	chat := &models.Chat{
+		Type:         models.ChatGroup,
+		Name:         input.Name,
+		Participants: participants,
+		CreatorUUID:  userUUID,
+	}
+	if err := h.chats.Create(ctx, chat); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(200, gin.H{
-		"chat_uuid": chatUUID,
+		"chat_uuid": chat.UUID,
 		"name":      input.Name,
 	})
 }
 
-func GetUserChats(c *gin.Context) {
-	userUUID := c.GetString("user_uuid")
+func (h *ChatHandler) GetUserChats(c *gin.Context) {
+	userUUIDStr := c.GetString("user_uuid")
+	userUUID, err := uuid.Parse(userUUIDStr)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
 
-	rows, err := database.DB.Query(`
-		SELECT uuid, type, name, participants, created_at
-		FROM chats
-		WHERE participants::jsonb ? $1
-		ORDER BY created_at DESC
-	`, userUUID)
+	ctx := c.Request.Context()
 
+	chats, err := h.chats.ListForUser(ctx, userUUID)
 	if err != nil {
 		c.JSON(500, gin.H{
 			"error":     "db error",
 			"details":   err.Error(),
-			"user_uuid": userUUID,
+			"user_uuid": userUUIDStr,
 		})
 		return
 	}
-	defer rows.Close()
-
-	var chats []map[string]any
-
-	for rows.Next() {
-		var chatUUID uuid.UUID
-		var chatType string
-		var name sql.NullString
-		var participantsJSON string
-		var createdAt time.Time
 
-		if err := rows.Scan(&chatUUID, &chatType, &name, &participantsJSON, &createdAt); err != nil {
-			continue
+	var peers []uuid.UUID
+	for _, chat := range chats {
+		if peerUUID, ok := chat.Peer(userUUID); ok && chat.Type == models.ChatDirect {
+			peers = append(peers, peerUUID)
 		}
+	}
+	peerNames := h.names.Names(ctx, peers)
+
+	var result []map[string]any
 
-		chat := map[string]any{
-			"chat_uuid":  chatUUID.String(),
-			"type":       chatType,
-			"created_at": createdAt,
+	for _, chat := range chats {
+		item := map[string]any{
+			"chat_uuid":  chat.UUID.String(),
+			"type":       chat.Type,
+			"created_at": chat.CreatedAt,
 		}
 
-		if name.Valid {
-			chat["name"] = name.String
+		if chat.Name != "" {
+			item["name"] = chat.Name
 		}
 
-		if chatType == "direct" {
-			var participants []string
-			if err := json.Unmarshal([]byte(participantsJSON), &participants); err == nil {
-				var otherUserUUID string
-				for _, p := range participants {
-					if p != userUUID {
-						otherUserUUID = p
-						break
-					}
-				}
-
-				if otherUserUUID != "" {
-					var otherName, otherSurname sql.NullString
-					err := database.DB.QueryRow(`
-						SELECT name, surname FROM users WHERE uuid = $1
-					`, otherUserUUID).Scan(&otherName, &otherSurname)
-
-					if err == nil {
-						var fullName string
-						if otherName.Valid && otherSurname.Valid {
-							fullName = otherName.String + " " + otherSurname.String
-						} else if otherName.Valid {
-							fullName = otherName.String
-						} else if otherSurname.Valid {
-							fullName = otherSurname.String
-						} else {
-							fullName = "Пользователь"
-						}
-						chat["participant_name"] = fullName
-					}
-				}
+		if chat.Type == models.ChatDirect {
+			if peerUUID, ok := chat.Peer(userUUID); ok {
+				item["participant_name"] = peerNames[peerUUID]
 			}
 		}
 
-		chats = append(chats, chat)
+		result = append(result, item)
 	}
 
-	c.JSON(200, gin.H{"chats": chats})
+	c.JSON(200, gin.H{"chats": result})
 }
 
-func GetChatMessages(c *gin.Context) {
-	userUUID := c.GetString("user_uuid")
-	chatUUIDStr := c.Param("chat_uuid")
+func (h *ChatHandler) GetChatMessages(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
 
+	chatUUIDStr := c.Param("chat_uuid")
 	chatUUID, err := uuid.Parse(chatUUIDStr)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "invalid chat uuid"})
 		return
 	}
 
-	// Проверяем доступ к чату
-	var count int
-	err = database.DB.QueryRow(`
-		SELECT COUNT(*) FROM chats
-		WHERE uuid = $1 AND participants::jsonb ? $2
-	`, chatUUID, userUUID).Scan(&count)
+	ctx := c.Request.Context()
 
-	if err != nil || count == 0 {
+	// Проверяем доступ к чату
+	chat, err := h.chats.Get(ctx, chatUUID)
+	if err != nil || !chat.HasParticipant(userUUID) {
 		c.JSON(403, gin.H{"error": "access denied"})
 		return
 	}
 
-	rows, err := database.DB.Query(`
-		SELECT uuid, sender_uuid, sender_name, content, created_at, is_read
-		FROM messages
-		WHERE chat_uuid = $1
-		ORDER BY created_at ASC
-	`, chatUUID)
-
+	history, err := h.messages.ListByChat(ctx, chatUUID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "db error"})
 		return
 	}
-	defer rows.Close()
 
-	var messages []map[string]interface{}
-	for rows.Next() {
-		var msgUUID uuid.UUID
-		var senderUUID uuid.UUID
-		var senderName, content string
-		var createdAt time.Time
-		var isRead bool
-
-		if err := rows.Scan(&msgUUID, &senderUUID, &senderName, &content, &createdAt, &isRead); err != nil {
-			continue
+	// В группе сообщения заблокированных не видит только тот, кто заблокировал
+	if chat.Type == models.ChatGroup {
+		blocked, err := h.blocks.Blocked(ctx, userUUID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
 		}
+		history = slices.DeleteFunc(history, func(m models.Message) bool {
+			_, hidden := blocked[m.SenderUUID]
+			return hidden
+		})
+	}
+	h.names.Enrich(ctx, history)
 
+	var messages []map[string]interface{}
+	for _, m := range history {
 		messages = append(messages, map[string]interface{}{
-			"uuid":        msgUUID.String(),
+			"uuid":        m.UUID.String(),
 			"chat_uuid":   chatUUIDStr,
-			"sender_uuid": senderUUID.String(),
-			"sender_name": senderName,
-			"content":     content,
-			"created_at":  createdAt,
-			"is_read":     isRead,
+			"sender_uuid": m.SenderUUID.String(),
+			"sender_name": m.SenderName,
+			"content":     m.Content,
+			"created_at":  m.CreatedAt,
+			"is_read":     m.IsRead,
 		})
 	}
 
 	c.JSON(200, gin.H{"messages": messages})
 }
 
-func SearchUsers(c *gin.Context) {
-	query := c.Query("q")
+// SearchMessages runs a full-text search over the caller's chats, or one chat
+// when chat_uuid is given. Фильтры: sender, from, to (RFC 3339 или YYYY-MM-DD,
+// дата в to включается целиком); страницы через limit и offset.
+func (h *ChatHandler) SearchMessages(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
 	if query == "" {
 		c.JSON(400, gin.H{"error": "query parameter required"})
 		return
 	}
+	if utf8.RuneCountInString(query) > maxSearchQueryLength {
+		c.JSON(400, gin.H{"error": "query is too long"})
+		return
+	}
+
+	search := repository.MessageSearch{Query: query}
+
+	if s := c.Query("sender"); s != "" {
+		if search.SenderUUID, err = uuid.Parse(s); err != nil {
+			c.JSON(400, gin.H{"error": "invalid sender uuid"})
+			return
+		}
+	}
+	if search.From, err = parseSearchTime(c.Query("from"), false); err != nil {
+		c.JSON(400, gin.H{"error": "invalid from, use RFC 3339 or YYYY-MM-DD"})
+		return
+	}
+	if search.To, err = parseSearchTime(c.Query("to"), true); err != nil {
+		c.JSON(400, gin.H{"error": "invalid to, use RFC 3339 or YYYY-MM-DD"})
+		return
+	}
+
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		c.JSON(400, gin.H{"error": "invalid limit or offset"})
+		return
+	}
+	// Берём на одну запись больше, чтобы понять, есть ли следующая страница
+	search.Limit, search.Offset = limit+1, offset
 
-	rows, err := database.DB.Query(`
-		SELECT uuid, name, surname, email
-		FROM users
-		WHERE email ILIKE $1 OR name ILIKE $1 OR surname ILIKE $1
-		LIMIT 20
-	`, "%"+query+"%")
+	ctx := c.Request.Context()
 
+	// Доступ как в GetChatMessages: в конкретном чате нужно быть участником,
+	// без chat_uuid ищем только по своим чатам
+	if s := c.Query("chat_uuid"); s != "" {
+		chatUUID, err := uuid.Parse(s)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid chat uuid"})
+			return
+		}
+		chat, err := h.chats.Get(ctx, chatUUID)
+		if err != nil || !chat.HasParticipant(userUUID) {
+			c.JSON(403, gin.H{"error": "access denied"})
+			return
+		}
+		search.ChatUUIDs = []uuid.UUID{chatUUID}
+		if chat.Type == models.ChatGroup {
+			search.HideInChats = search.ChatUUIDs
+		}
+	} else {
+		chats, err := h.chats.ListForUser(ctx, userUUID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		for _, chat := range chats {
+			search.ChatUUIDs = append(search.ChatUUIDs, chat.UUID)
+			if chat.Type == models.ChatGroup {
+				search.HideInChats = append(search.HideInChats, chat.UUID)
+			}
+		}
+	}
+
+	// Как в истории: в группах не показываем сообщения тех, кого читатель заблокировал
+	blocked, err := h.blocks.Blocked(ctx, userUUID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "db error"})
 		return
 	}
-	defer rows.Close()
+	for id := range blocked {
+		search.HideSenders = append(search.HideSenders, id)
+	}
 
-	var users []map[string]interface{}
-	for rows.Next() {
-		var uuid, name, surname, email string
-		if err := rows.Scan(&uuid, &name, &surname, &email); err != nil {
-			continue
-		}
+	hits, err := h.messages.Search(ctx, search)
+	if err != nil {
+		log.Printf("Ошибка поиска сообщений: %v", err)
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
 
-		fullName := name
-		if surname != "" {
-			fullName = name + " " + surname
-		}
+	var nextOffset any
+	if len(hits) > limit {
+		hits = hits[:limit]
+		nextOffset = offset + limit
+	}
+
+	senders := make([]uuid.UUID, len(hits))
+	for i, m := range hits {
+		senders[i] = m.SenderUUID
+	}
+	senderNames := h.names.Names(ctx, senders)
 
-		users = append(users, map[string]interface{}{
-			"uuid":  uuid,
-			"name":  fullName,
-			"email": email,
+	messages := make([]map[string]interface{}, 0, len(hits))
+	for _, m := range hits {
+		messages = append(messages, map[string]interface{}{
+			"uuid":        m.UUID.String(),
+			"chat_uuid":   m.ChatUUID.String(),
+			"sender_uuid": m.SenderUUID.String(),
+			"sender_name": senderNames[m.SenderUUID],
+			"content":     m.Content,
+			"snippet":     m.Snippet,
+			"rank":        m.Rank,
+			"created_at":  m.CreatedAt,
 		})
 	}
 
-	c.JSON(200, gin.H{"users": users})
+	c.JSON(200, gin.H{"messages": messages, "next_offset": nextOffset})
 }
 
-func MarkChatAsRead(c *gin.Context) {
-	userUUID := c.GetString("user_uuid")
-	chatUUIDStr := c.Param("chat_uuid")
+// SearchUsers finds people by name or exact email. Те, с кем уже есть общие
+// чаты, идут первыми; email в ответе не отдаётся никому.
+func (h *ChatHandler) SearchUsers(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
 
-	chatUUID, err := uuid.Parse(chatUUIDStr)
+	query := strings.TrimSpace(c.Query("q"))
+	if n := utf8.RuneCountInString(query); n < minUserQueryLength || n > maxSearchQueryLength {
+		c.JSON(400, gin.H{"error": "query must be 2 to 200 characters"})
+		return
+	}
+
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		c.JSON(400, gin.H{"error": "invalid limit or offset"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	chats, err := h.chats.ListForUser(ctx, userUUID)
 	if err != nil {
-		c.JSON(400, gin.H{"error": "invalid chat uuid"})
+		c.JSON(500, gin.H{"error": "db error"})
 		return
 	}
+	known := make(map[uuid.UUID]struct{})
+	for _, chat := range chats {
+		for _, p := range chat.Participants {
+			if p != userUUID {
+				known[p] = struct{}{}
+			}
+		}
+	}
 
-	var count int
-	err = database.DB.QueryRow(`
-		SELECT COUNT(*) FROM chats
-		WHERE uuid = $1 AND participants::jsonb ? $2
-	`, chatUUID, userUUID).Scan(&count)
+	// Заблокированных не показываем в обе стороны: ни тех, кого заблокировал я, ни тех, кто меня
+	related, err := h.blocks.Related(ctx, userUUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
 
-	if err != nil || count == 0 {
-		c.JSON(403, gin.H{"error": "access denied"})
+	search := repository.UserSearch{
+		Query:        query,
+		SearcherUUID: userUUID,
+		Exclude:      related,
+		Limit:        limit + 1,
+		Offset:       offset,
+	}
+	for p := range known {
+		search.Known = append(search.Known, p)
+	}
+
+	hits, err := h.users.Search(ctx, search)
+	if err != nil {
+		log.Printf("Ошибка поиска пользователей: %v", err)
+		c.JSON(500, gin.H{"error": "db error"})
 		return
 	}
 
-	_, err = database.DB.Exec(`
-		UPDATE messages
-		SET is_read = true, updated_at = NOW()
-		WHERE chat_uuid = $1
-		AND sender_uuid != $2
-		AND is_read = false
-	`, chatUUID, userUUID)
+	var nextOffset any
+	if len(hits) > limit {
+		hits = hits[:limit]
+		nextOffset = offset + limit
+	}
+	if hits == nil {
+		hits = []models.UserHit{}
+	}
+
+	c.JSON(200, gin.H{"users": hits, "next_offset": nextOffset})
+}
+
+func (h *ChatHandler) MarkChatAsRead(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
 
+	chatUUID, err := uuid.Parse(c.Param("chat_uuid"))
 	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid chat uuid"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if ok, err := h.chats.IsParticipant(ctx, chatUUID, userUUID); err != nil || !ok {
+		c.JSON(403, gin.H{"error": "access denied"})
+		return
+	}
+
+	if err := h.messages.MarkRead(ctx, chatUUID, userUUID); err != nil {
 		c.JSON(500, gin.H{"error": "failed to mark as read"})
 		return
 	}
 
 	c.JSON(200, gin.H{"message": "marked as read"})
 }
+
+// SendMessage stores a message sent over REST instead of the WebSocket
+func (h *ChatHandler) SendMessage(c *gin.Context) {
+	var input struct {
+		ChatUUID uuid.UUID `json:"chat_uuid"`
+		Text     string    `json:"text"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input format"})
+		return
+	}
+
+	if input.Text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message text is required"})
+		return
+	}
+
+	senderUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user uuid"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	chat, err := h.chats.Get(ctx, input.ChatUUID)
+	if err != nil || !chat.HasParticipant(senderUUID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if peer, ok := chat.Peer(senderUUID); ok && chat.Type == models.ChatDirect {
+		blocked, err := h.blocks.Between(ctx, senderUUID, peer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+		if blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can't message this user"})
+			return
+		}
+
+		allowed, err := h.contacts.CanMessage(ctx, senderUUID, peer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This user only accepts messages from contacts"})
+			return
+		}
+	}
+
+	msg := &models.Message{
+		ChatUUID:   input.ChatUUID,
+		SenderUUID: senderUUID,
+		Content:    input.Text,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.messages.Create(ctx, msg); err != nil {
+		log.Printf("Ошибка сохранения сообщения: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message sent", "uuid": msg.UUID})
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"bytes"
+	"chat-app/internal/blocks"
+	"chat-app/internal/contacts"
+	"chat-app/internal/models"
+	"chat-app/internal/names"
+	"chat-app/internal/repository"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// chatEnv is a ChatHandler over in-memory repositories; Ann, Bob and Eve exist,
+// Ann and Bob share a direct chat and all three a group
+type chatEnv struct {
+	router   *gin.Engine
+	chats    *repository.MemoryChats
+	messages *repository.MemoryMessages
+	blocks   *blocks.Service
+
+	ann, bob, eve models.User
+	direct, group models.Chat
+}
+
+func newChatEnv(t *testing.T) *chatEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	users := repository.NewMemoryUsers()
+	env := &chatEnv{
+		chats:    repository.NewMemoryChats(),
+		messages: repository.NewMemoryMessages(),
+		blocks:   blocks.New(repository.NewMemoryBlocks(), client),
+	}
+	h := NewChatHandler(users, env.chats, env.messages, names.New(users, client), env.blocks,
+		contacts.New(repository.NewMemoryContacts(), users, client))
+
+	for name, u := range map[string]*models.User{"Ann": &env.ann, "Bob": &env.bob, "Eve": &env.eve} {
+		*u = models.User{Name: name, Email: name + "@example.com"}
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.direct = models.Chat{Type: models.ChatDirect, Participants: []uuid.UUID{env.ann.UUID, env.bob.UUID}, CreatorUUID: env.ann.UUID}
+	env.group = models.Chat{Type: models.ChatGroup, Name: "Team", Participants: []uuid.UUID{env.ann.UUID, env.bob.UUID, env.eve.UUID}, CreatorUUID: env.ann.UUID}
+	for _, chat := range []*models.Chat{&env.direct, &env.group} {
+		if err := env.chats.Create(ctx, chat); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Пользователя запроса задаёт тест, как это делает AuthMiddleware
+	r := gin.New()
+	authed := r.Group("/", func(c *gin.Context) {
+		c.Set("user_uuid", c.GetHeader("X-Test-User"))
+	})
+	authed.POST("/chats/direct", h.CreateDirectChat)
+	authed.GET("/chats/:chat_uuid/messages", h.GetChatMessages)
+	authed.GET("/chats/:chat_uuid/read", h.MarkChatAsRead)
+	authed.POST("/message", h.SendMessage)
+	env.router = r
+	return env
+}
+
+// do sends a JSON request on behalf of user and decodes the JSON response
+func (e *chatEnv) do(t *testing.T, user models.User, method, path string, body any) (int, map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", user.UUID.String())
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+
+	var resp map[string]any
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func (e *chatEnv) send(t *testing.T, chat models.Chat, from models.User, content string) {
+	t.Helper()
+	msg := models.Message{ChatUUID: chat.UUID, SenderUUID: from.UUID, Content: content}
+	if err := e.messages.Create(context.Background(), &msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateDirectChat(t *testing.T) {
+	tests := []struct {
+		name   string
+		as     func(e *chatEnv) models.User
+		body   func(e *chatEnv) gin.H
+		want   int
+		chatOf func(e *chatEnv) uuid.UUID // какой чат ждём в ответе; nil — новый
+	}{
+		{"existing chat by uuid", func(e *chatEnv) models.User { return e.ann },
+			func(e *chatEnv) gin.H { return gin.H{"with": e.bob.UUID} }, http.StatusOK,
+			func(e *chatEnv) uuid.UUID { return e.direct.UUID }},
+		{"existing chat by email", func(e *chatEnv) models.User { return e.ann },
+			func(e *chatEnv) gin.H { return gin.H{"with_email": e.bob.Email} }, http.StatusOK,
+			func(e *chatEnv) uuid.UUID { return e.direct.UUID }},
+		{"new chat", func(e *chatEnv) models.User { return e.ann },
+			func(e *chatEnv) gin.H { return gin.H{"with": e.eve.UUID} }, http.StatusOK, nil},
+		{"with yourself", func(e *chatEnv) models.User { return e.ann },
+			func(e *chatEnv) gin.H { return gin.H{"with": e.ann.UUID} }, http.StatusBadRequest, nil},
+		{"unknown email", func(e *chatEnv) models.User { return e.ann },
+			func(*chatEnv) gin.H { return gin.H{"with_email": "nobody@example.com"} }, http.StatusBadRequest, nil},
+		{"unknown user", func(e *chatEnv) models.User { return e.ann },
+			func(*chatEnv) gin.H { return gin.H{"with": uuid.New()} }, http.StatusNotFound, nil},
+		{"nobody", func(e *chatEnv) models.User { return e.ann },
+			func(*chatEnv) gin.H { return gin.H{} }, http.StatusBadRequest, nil},
+		{"blocked by the peer", func(e *chatEnv) models.User { return e.eve },
+			func(e *chatEnv) gin.H { return gin.H{"with": e.bob.UUID} }, http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newChatEnv(t)
+			if err := env.blocks.Block(context.Background(), env.bob.UUID, env.eve.UUID); err != nil {
+				t.Fatal(err)
+			}
+			as := tt.as(env)
+			before, _ := env.chats.ListForUser(context.Background(), as.UUID)
+
+			code, resp := env.do(t, as, http.MethodPost, "/chats/direct", tt.body(env))
+			expectStatus(t, "create direct chat", code, tt.want, resp)
+
+			after, _ := env.chats.ListForUser(context.Background(), as.UUID)
+			created := len(after) - len(before)
+			switch {
+			case tt.want != http.StatusOK:
+				if created != 0 {
+					t.Errorf("%d chats created on error", created)
+				}
+			case tt.chatOf != nil:
+				if resp["chat_uuid"] != tt.chatOf(env).String() || created != 0 {
+					t.Errorf("chat_uuid = %v, %d chats created; want the existing chat", resp["chat_uuid"], created)
+				}
+			default:
+				if created != 1 {
+					t.Errorf("%d chats created, want 1", created)
+				}
+				// Повторный запрос находит созданный чат через FindDirect
+				code, again := env.do(t, as, http.MethodPost, "/chats/direct", tt.body(env))
+				if code != http.StatusOK || again["chat_uuid"] != resp["chat_uuid"] {
+					t.Errorf("second request = %d %v, want chat %v", code, again, resp["chat_uuid"])
+				}
+			}
+		})
+	}
+}
+
+func TestGetChatMessages(t *testing.T) {
+	env := newChatEnv(t)
+	env.send(t, env.direct, env.ann, "hi Bob")
+	env.send(t, env.group, env.bob, "from Bob")
+	env.send(t, env.group, env.eve, "from Eve")
+	if err := env.blocks.Block(context.Background(), env.ann.UUID, env.eve.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		as   models.User
+		chat uuid.UUID
+		want int
+		text []string
+	}{
+		{"direct chat", env.bob, env.direct.UUID, http.StatusOK, []string{"hi Bob"}},
+		{"not a participant", env.eve, env.direct.UUID, http.StatusForbidden, nil},
+		{"no such chat", env.ann, uuid.New(), http.StatusForbidden, nil},
+		{"group hides whom the reader blocked", env.ann, env.group.UUID, http.StatusOK, []string{"from Bob"}},
+		{"group for others is whole", env.bob, env.group.UUID, http.StatusOK, []string{"from Bob", "from Eve"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := env.do(t, tt.as, http.MethodGet, "/chats/"+tt.chat.String()+"/messages", nil)
+			expectStatus(t, "messages", code, tt.want, resp)
+			if tt.want != http.StatusOK {
+				return
+			}
+			messages, _ := resp["messages"].([]any)
+			var text []string
+			for _, m := range messages {
+				text = append(text, m.(map[string]any)["content"].(string))
+			}
+			if len(text) != len(tt.text) {
+				t.Fatalf("messages = %q, want %q", text, tt.text)
+			}
+			for i := range text {
+				if text[i] != tt.text[i] {
+					t.Errorf("messages = %q, want %q", text, tt.text)
+				}
+			}
+		})
+	}
+
+	code, resp := env.do(t, env.ann, http.MethodGet, "/chats/"+env.direct.UUID.String()+"/messages", nil)
+	expectStatus(t, "messages", code, http.StatusOK, resp)
+	if name := resp["messages"].([]any)[0].(map[string]any)["sender_name"]; name != env.ann.DisplayName() {
+		t.Errorf("sender_name = %v, want %q", name, env.ann.DisplayName())
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocked bool // Ann заблокировала Bob
+		as      func(e *chatEnv) models.User
+		body    func(e *chatEnv) gin.H
+		want    int
+	}{
+		{"direct chat", false, func(e *chatEnv) models.User { return e.ann },
+			func(e *chatEnv) gin.H { return gin.H{"chat_uuid": e.direct.UUID, "text": "hi"} }, http.StatusOK},
+		{"group", false, func(e *chatEnv) models.User { return e.eve },
+			func(e *chatEnv) gin.H { return gin.H{"chat_uuid": e.group.UUID, "text": "hi"} }, http.StatusOK},
+		{"empty text", false, func(e *chatEnv) models.User { return e.ann },
+			func(e *chatEnv) gin.H { return gin.H{"chat_uuid": e.direct.UUID, "text": ""} }, http.StatusBadRequest},
+		{"not a participant", false, func(e *chatEnv) models.User { return e.eve },
+			func(e *chatEnv) gin.H { return gin.H{"chat_uuid": e.direct.UUID, "text": "hi"} }, http.StatusForbidden},
+		{"no such chat", false, func(e *chatEnv) models.User { return e.ann },
+			func(*chatEnv) gin.H { return gin.H{"chat_uuid": uuid.New(), "text": "hi"} }, http.StatusForbidden},
+		{"direct chat with a blocker", true, func(e *chatEnv) models.User { return e.bob },
+			func(e *chatEnv) gin.H { return gin.H{"chat_uuid": e.direct.UUID, "text": "hi"} }, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newChatEnv(t)
+			if tt.blocked {
+				if err := env.blocks.Block(context.Background(), env.ann.UUID, env.bob.UUID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			body := tt.body(env)
+
+			code, resp := env.do(t, tt.as(env), http.MethodPost, "/message", body)
+			expectStatus(t, "send", code, tt.want, resp)
+
+			history, _ := env.messages.ListByChat(context.Background(), env.direct.UUID)
+			group, _ := env.messages.ListByChat(context.Background(), env.group.UUID)
+			history = append(history, group...)
+			if tt.want != http.StatusOK {
+				if len(history) != 0 {
+					t.Errorf("stored %d messages on error", len(history))
+				}
+				return
+			}
+			if len(history) != 1 || history[0].UUID.String() != resp["uuid"] ||
+				history[0].SenderUUID != tt.as(env).UUID || history[0].Content != body["text"] {
+				t.Errorf("stored %+v, response %v", history, resp)
+			}
+		})
+	}
+}
+
+func TestMarkChatAsRead(t *testing.T) {
+	env := newChatEnv(t)
+	env.send(t, env.direct, env.ann, "hi Bob")
+	env.send(t, env.direct, env.bob, "hi Ann")
+
+	read := func() map[string]bool {
+		t.Helper()
+		history, err := env.messages.ListByChat(context.Background(), env.direct.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := make(map[string]bool)
+		for _, m := range history {
+			state[m.Content] = m.IsRead
+		}
+		return state
+	}
+
+	code, resp := env.do(t, env.eve, http.MethodGet, "/chats/"+env.direct.UUID.String()+"/read", nil)
+	expectStatus(t, "read by a stranger", code, http.StatusForbidden, resp)
+	if state := read(); state["hi Bob"] || state["hi Ann"] {
+		t.Fatalf("a stranger marked messages as read: %v", state)
+	}
+
+	// Свои сообщения читатель не отмечает
+	code, resp = env.do(t, env.bob, http.MethodGet, "/chats/"+env.direct.UUID.String()+"/read", nil)
+	expectStatus(t, "read", code, http.StatusOK, resp)
+	if state := read(); !state["hi Bob"] || state["hi Ann"] {
+		t.Errorf("read state = %v, want only Ann's message read", state)
+	}
+
+	code, resp = env.do(t, env.ann, http.MethodGet, "/chats/not-a-uuid/read", nil)
+	expectStatus(t, "bad chat uuid", code, http.StatusBadRequest, resp)
+}
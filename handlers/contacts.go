@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"chat-app/internal/blocks"
+	"chat-app/internal/contacts"
+	"chat-app/internal/models"
+	"chat-app/internal/names"
+	"chat-app/internal/presence"
+	"chat-app/internal/repository"
+	"context"
+	"errors"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Notifier pushes events to a user's notification sockets, see ws.Hub.Notify
+type Notifier interface {
+	Notify(userUUID uuid.UUID, event string, data any) error
+}
+
+// События в сокете уведомлений
+const (
+	EventContactRequest  = "contact_request"  // пришла заявка в контакты
+	EventContactAccepted = "contact_accepted" // заявку приняли
+)
+
+// ContactDeps are the services the contact handler needs
+type ContactDeps struct {
+	Contacts *contacts.Service
+	Blocks   *blocks.Service
+	Users    repository.UserRepository
+	Names    *names.Service
+	Presence *presence.Tracker // nil — все показываются офлайн
+	Notifier Notifier          // nil — без уведомлений
+}
+
+// ContactHandler serves contacts, contact requests and message privacy
+type ContactHandler struct {
+	contacts *contacts.Service
+	blocks   *blocks.Service
+	users    repository.UserRepository
+	names    *names.Service
+	presence *presence.Tracker
+	notifier Notifier
+}
+
+// NewContactHandler creates a contact handler
+func NewContactHandler(deps ContactDeps) *ContactHandler {
+	return &ContactHandler{
+		contacts: deps.Contacts,
+		blocks:   deps.Blocks,
+		users:    deps.Users,
+		names:    deps.Names,
+		presence: deps.Presence,
+		notifier: deps.Notifier,
+	}
+}
+
+// ListContacts returns the user's contacts with their presence
+func (h *ContactHandler) ListContacts(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	list, err := h.contacts.List(ctx, userUUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	ids := make([]uuid.UUID, len(list))
+	for i, ct := range list {
+		ids[i] = ct.ContactUUID
+	}
+	displayNames := h.names.Names(ctx, ids)
+
+	statuses := map[uuid.UUID]presence.Status{}
+	if h.presence != nil {
+		// Без присутствия список всё равно полезен, поэтому ошибку только логируем
+		if statuses, err = h.presence.Statuses(ctx, ids); err != nil {
+			log.Printf("Не удалось получить присутствие контактов %s: %v", userUUID, err)
+			statuses = map[uuid.UUID]presence.Status{}
+		}
+	}
+
+	result := make([]gin.H, len(list))
+	for i, ct := range list {
+		st := statuses[ct.ContactUUID]
+		result[i] = gin.H{
+			"user_uuid":  ct.ContactUUID,
+			"name":       displayNames[ct.ContactUUID],
+			"online":     st.Online,
+			"last_seen":  st.LastSeen,
+			"created_at": ct.CreatedAt,
+		}
+	}
+
+	c.JSON(200, gin.H{"contacts": result})
+}
+
+// RemoveContact deletes a contact for both users
+func (h *ContactHandler) RemoveContact(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	contactUUID, err := uuid.Parse(c.Param("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	if err := h.contacts.Remove(c.Request.Context(), userUUID, contactUUID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "такого контакта нет"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "removed"})
+}
+
+// ListRequests returns pending requests: incoming wait for the user's answer
+func (h *ContactHandler) ListRequests(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	incoming, err := h.contacts.Incoming(ctx, userUUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	outgoing, err := h.contacts.Outgoing(ctx, userUUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(incoming)+len(outgoing))
+	for _, req := range incoming {
+		ids = append(ids, req.FromUUID)
+	}
+	for _, req := range outgoing {
+		ids = append(ids, req.ToUUID)
+	}
+	displayNames := h.names.Names(ctx, ids)
+
+	requestJSON := func(other uuid.UUID, req models.ContactRequest) gin.H {
+		return gin.H{
+			"user_uuid":  other,
+			"name":       displayNames[other],
+			"created_at": req.CreatedAt,
+		}
+	}
+
+	in := make([]gin.H, len(incoming))
+	for i, req := range incoming {
+		in[i] = requestJSON(req.FromUUID, req)
+	}
+	out := make([]gin.H, len(outgoing))
+	for i, req := range outgoing {
+		out[i] = requestJSON(req.ToUUID, req)
+	}
+
+	c.JSON(200, gin.H{"incoming": in, "outgoing": out})
+}
+
+// SendRequest asks another user to become a contact, by user_uuid or email.
+// Если он уже прислал встречную заявку, контакт создаётся сразу.
+func (h *ContactHandler) SendRequest(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	var input struct {
+		UserUUID uuid.UUID `json:"user_uuid"`
+		Email    string    `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || (input.UserUUID == uuid.Nil && input.Email == "") {
+		c.JSON(400, gin.H{"error": "нужен параметр user_uuid или email"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var other *models.User
+	if input.UserUUID != uuid.Nil {
+		other, err = h.users.GetByUUID(ctx, input.UserUUID)
+	} else {
+		other, err = h.users.GetByEmail(ctx, input.Email)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "пользователь не найден"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if other.UUID == userUUID {
+		c.JSON(400, gin.H{"error": "нельзя добавить себя"})
+		return
+	}
+
+	if blocked, err := h.blocks.Between(ctx, userUUID, other.UUID); err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	} else if blocked {
+		c.JSON(403, gin.H{"error": "нельзя добавить этого пользователя"})
+		return
+	}
+
+	accepted, err := h.contacts.Request(ctx, userUUID, other.UUID)
+	switch {
+	case errors.Is(err, contacts.ErrAlreadyContacts):
+		c.JSON(409, gin.H{"error": "уже в контактах"})
+		return
+	case errors.Is(err, repository.ErrConflict):
+		c.JSON(409, gin.H{"error": "заявка уже отправлена"})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	if accepted {
+		h.notify(ctx, other.UUID, EventContactAccepted, userUUID)
+		c.JSON(200, gin.H{"status": "accepted", "user_uuid": other.UUID})
+		return
+	}
+
+	h.notify(ctx, other.UUID, EventContactRequest, userUUID)
+	c.JSON(201, gin.H{"status": "requested", "user_uuid": other.UUID})
+}
+
+// AcceptRequest accepts the request the user got from :user_uuid
+func (h *ContactHandler) AcceptRequest(c *gin.Context) {
+	userUUID, fromUUID, ok := requestParties(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Блокировка могла появиться уже после заявки
+	if blocked, err := h.blocks.Between(ctx, userUUID, fromUUID); err != nil {
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	} else if blocked {
+		c.JSON(403, gin.H{"error": "нельзя добавить этого пользователя"})
+		return
+	}
+
+	if err := h.contacts.Accept(ctx, fromUUID, userUUID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "заявка не найдена"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+
+	h.notify(ctx, fromUUID, EventContactAccepted, userUUID)
+	c.JSON(200, gin.H{"status": "accepted"})
+}
+
+// DeclineRequest rejects the request the user got from :user_uuid.
+// Отправителя не уведомляем: для него заявка просто остаётся без ответа.
+func (h *ContactHandler) DeclineRequest(c *gin.Context) {
+	userUUID, fromUUID, ok := requestParties(c)
+	if !ok {
+		return
+	}
+	h.deleteRequest(c, fromUUID, userUUID, "declined")
+}
+
+// CancelRequest withdraws the user's own request to :user_uuid
+func (h *ContactHandler) CancelRequest(c *gin.Context) {
+	userUUID, toUUID, ok := requestParties(c)
+	if !ok {
+		return
+	}
+	h.deleteRequest(c, userUUID, toUUID, "cancelled")
+}
+
+func (h *ContactHandler) deleteRequest(c *gin.Context, fromUUID, toUUID uuid.UUID, status string) {
+	if err := h.contacts.Decline(c.Request.Context(), fromUUID, toUUID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "заявка не найдена"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(200, gin.H{"status": status})
+}
+
+// requestParties parses the current user and the :user_uuid on the other side of a request
+func requestParties(c *gin.Context) (userUUID, otherUUID uuid.UUID, ok bool) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	otherUUID, err = uuid.Parse(c.Param("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userUUID, otherUUID, true
+}
+
+// UpdateMessagePrivacy sets who can message the current user directly:
+// everyone или contacts. Уже открытые личные чаты тоже подчиняются настройке.
+func (h *ContactHandler) UpdateMessagePrivacy(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	var input struct {
+		MessagePrivacy string `json:"message_privacy" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !models.ValidMessagePrivacy(input.MessagePrivacy) {
+		c.JSON(400, gin.H{"error": "message_privacy must be everyone or contacts"})
+		return
+	}
+
+	if err := h.contacts.SetPrivacy(c.Request.Context(), userUUID, input.MessagePrivacy); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message_privacy": input.MessagePrivacy})
+}
+
+// notify tells userUUID about an event caused by fromUUID
+func (h *ContactHandler) notify(ctx context.Context, userUUID uuid.UUID, event string, fromUUID uuid.UUID) {
+	if h.notifier == nil {
+		return
+	}
+	data := gin.H{
+		"user_uuid": fromUUID,
+		"name":      h.names.Name(ctx, fromUUID),
+	}
+	if err := h.notifier.Notify(userUUID, event, data); err != nil {
+		log.Printf("Не удалось отправить уведомление %s пользователю %s: %v", event, userUUID, err)
+	}
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"chat-app/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public verification keys so other services can check our tokens
+func JWKS(keys *auth.KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(200, keys.JWKS())
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/oidc"
+	"chat-app/internal/repository"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// oidcReauthMaxAge bounds how long ago the provider may have checked credentials
+// for a re-authentication to count as fresh
+const oidcReauthMaxAge = 5 * time.Minute
+
+// OIDCHandler implements "log in with provider" via authorization code + PKCE
+type OIDCHandler struct {
+	provider   *oidc.Provider
+	auth       *AuthHandler
+	identities repository.IdentityRepository
+	redis      *redis.Client // одноразовый state между редиректами
+}
+
+// NewOIDCHandler creates a handler that issues tokens through the given AuthHandler
+func NewOIDCHandler(provider *oidc.Provider, auth *AuthHandler, identities repository.IdentityRepository, client *redis.Client) *OIDCHandler {
+	return &OIDCHandler{
+		provider:   provider,
+		auth:       auth,
+		identities: identities,
+		redis:      client,
+	}
+}
+
+type oidcState struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	Reauth   bool   `json:"reauth,omitempty"`
+}
+
+// Login redirects the browser to the provider's authorization endpoint.
+// С ?purpose=reauth провайдер заново спрашивает учётные данные, а Callback
+// вместо входа выдаёт reauth_token: так аккаунт без пароля подтверждает
+// удаление, смену email и т. п.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	state, err1 := oidc.RandomString()
+	nonce, err2 := oidc.RandomString()
+	verifier, err3 := oidc.RandomString()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+		return
+	}
+
+	saved := oidcState{Verifier: verifier, Nonce: nonce, Reauth: c.Query("purpose") == "reauth"}
+	data, _ := json.Marshal(saved)
+	if err := h.redis.Set(c.Request.Context(), "oidc:state:"+state, data, oidcStateTTL).Err(); err != nil {
+		log.Printf("Не удалось сохранить OIDC state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+		return
+	}
+
+	if saved.Reauth {
+		c.Redirect(http.StatusFound, h.provider.ReauthCodeURL(state, nonce, verifier))
+		return
+	}
+	c.Redirect(http.StatusFound, h.provider.AuthCodeURL(state, nonce, verifier))
+}
+
+// Callback exchanges the code, links the identity and issues our own tokens
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if errParam := c.Query("error"); errParam != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Provider denied login", "details": errParam})
+		return
+	}
+
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// state одноразовый: GETDEL не даёт переиспользовать его повторно
+	raw, err := h.redis.GetDel(ctx, "oidc:state:"+state).Bytes()
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+
+	var saved oidcState
+	if err := json.Unmarshal(raw, &saved); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired state"})
+		return
+	}
+
+	claims, err := h.provider.Exchange(ctx, code, saved.Verifier, saved.Nonce)
+	if err != nil {
+		log.Printf("OIDC exchange failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	if saved.Reauth {
+		h.reauthenticate(c, claims)
+		return
+	}
+
+	if claims.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider did not return an email"})
+		return
+	}
+
+	// Привязка к существующему аккаунту по email — только если провайдер его подтвердил
+	name, surname := oidcDisplayName(claims)
+	user, created, err := h.identities.Login(ctx,
+		models.Identity{Issuer: claims.Issuer, Subject: claims.Subject, Email: claims.Email},
+		&models.User{Name: name, Surname: surname, Email: claims.Email},
+		claims.EmailVerified)
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC user linking failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+		return
+	}
+	if created {
+		log.Printf("User %s created via SSO (%s)", user.UUID, claims.Issuer)
+	}
+
+	h.auth.completeLogin(c, user.UUID, user.Email, user.TOTPEnabled)
+}
+
+// reauthenticate issues a reauth token for the account already linked to the identity
+func (h *OIDCHandler) reauthenticate(c *gin.Context, claims *oidc.Claims) {
+	if claims.AuthTime.IsZero() || time.Since(claims.AuthTime) > oidcReauthMaxAge {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Provider did not confirm a fresh login"})
+		return
+	}
+
+	// Только существующая привязка: повторный вход не создаёт и не связывает аккаунты
+	user, err := h.identities.Find(c.Request.Context(), claims.Issuer, claims.Subject)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "This identity is not linked to an account"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+		return
+	}
+
+	token, err := h.auth.tokens.IssueReauth(user.UUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reauth_token": token,
+		"expires_in":   h.auth.tokens.ChallengeTTL().Seconds(),
+	})
+}
+
+func oidcDisplayName(claims *oidc.Claims) (string, string) {
+	if claims.GivenName != "" || claims.FamilyName != "" {
+		return claims.GivenName, claims.FamilyName
+	}
+	if claims.Name != "" {
+		if first, last, ok := strings.Cut(claims.Name, " "); ok {
+			return first, last
+		}
+		return claims.Name, ""
+	}
+	local, _, _ := strings.Cut(claims.Email, "@")
+	return local, ""
+}
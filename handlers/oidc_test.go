@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"chat-app/internal/oidc"
+	"chat-app/internal/repository"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oidcClientID = "chat-app"
+
+// issuerKey signs ID tokens of every fake issuer: генерация RSA медленная
+var issuerKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+// ssoEnv is an OIDCHandler against a fake issuer that signs whatever claims the test sets
+type ssoEnv struct {
+	*authEnv
+	identities repository.IdentityRepository
+
+	mu        sync.Mutex
+	nonce     string // из последнего редиректа на провайдера
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newSSOEnv(t *testing.T) *ssoEnv {
+	t.Helper()
+	env := &ssoEnv{authEnv: newAuthEnv(t)}
+	env.identities = repository.NewMemoryIdentities(env.users)
+
+	key := issuerKey()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		env.mu.Lock()
+		defer env.mu.Unlock()
+		if oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != env.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":   srv.URL,
+			"aud":   oidcClientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": env.nonce,
+		}
+		for k, v := range env.claims {
+			claims[k] = v
+		}
+		raw, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": raw})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		IssuerURL:   srv.URL,
+		ClientID:    oidcClientID,
+		RedirectURL: "https://app.example/auth/sso/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewOIDCHandler(provider, env.h, env.identities, env.redis)
+	env.router.GET("/sso/login", h.Login)
+	env.router.GET("/sso/callback", h.Callback)
+	return env
+}
+
+// sso walks the browser through Login and Callback with the given ID token claims
+func (e *ssoEnv) sso(t *testing.T, claims jwt.MapClaims) (int, map[string]any) {
+	t.Helper()
+	return e.flow(t, "/sso/login", claims)
+}
+
+// reauth logs in again for a reauth_token
+func (e *ssoEnv) reauth(t *testing.T, claims jwt.MapClaims) (int, map[string]any) {
+	t.Helper()
+	return e.flow(t, "/sso/login?purpose=reauth", claims)
+}
+
+func (e *ssoEnv) flow(t *testing.T, login string, claims jwt.MapClaims) (int, map[string]any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, login, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("sso login = %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := location.Query()
+
+	e.mu.Lock()
+	e.nonce, e.challenge, e.claims = q.Get("nonce"), q.Get("code_challenge"), claims
+	e.mu.Unlock()
+
+	callback := "/sso/callback?" + url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode()
+	return e.do(t, http.MethodGet, callback, nil)
+}
+
+func (e *ssoEnv) userOf(t *testing.T, resp map[string]any) string {
+	t.Helper()
+	token, _ := resp["access_token"].(string)
+	claims, err := e.tokens.VerifyAccess(token)
+	if err != nil {
+		t.Fatalf("no access token in %v: %v", resp, err)
+	}
+	return claims.UserUUID.String()
+}
+
+func TestSSOCreatesAndReusesUser(t *testing.T) {
+	env := newSSOEnv(t)
+	claims := jwt.MapClaims{"sub": "ext-1", "email": "Bob@Example.com", "email_verified": true, "name": "Bob Smith"}
+
+	code, resp := env.sso(t, claims)
+	expectStatus(t, "first sso login", code, http.StatusOK, resp)
+	first := env.userOf(t, resp)
+	if first == env.user.UUID.String() {
+		t.Fatal("new identity linked to an unrelated account")
+	}
+
+	user, err := env.users.GetByEmail(context.Background(), "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UUID.String() != first || user.Name != "Bob" || user.Surname != "Smith" {
+		t.Errorf("created user = %+v", user)
+	}
+
+	// Повторный вход по той же связке issuer+sub, даже со сменившимся email
+	claims["email"] = "bob@new.example"
+	code, resp = env.sso(t, claims)
+	expectStatus(t, "second sso login", code, http.StatusOK, resp)
+	if got := env.userOf(t, resp); got != first {
+		t.Errorf("second login resolved to %s, want %s", got, first)
+	}
+}
+
+func TestSSOLinksByVerifiedEmailOnly(t *testing.T) {
+	env := newSSOEnv(t)
+
+	code, resp := env.sso(t, jwt.MapClaims{"sub": "ext-1", "email": env.user.Email, "email_verified": false})
+	expectStatus(t, "unverified email of an existing account", code, http.StatusConflict, resp)
+
+	code, resp = env.sso(t, jwt.MapClaims{"sub": "ext-1", "email": env.user.Email, "email_verified": true})
+	expectStatus(t, "verified email", code, http.StatusOK, resp)
+	if got := env.userOf(t, resp); got != env.user.UUID.String() {
+		t.Errorf("linked to %s, want %s", got, env.user.UUID)
+	}
+}
+
+func TestSSORequiresSecondFactor(t *testing.T) {
+	env := newSSOEnv(t)
+
+	env.enableTwoFactor(t)
+
+	code, resp := env.sso(t, jwt.MapClaims{"sub": "ext-1", "email": env.user.Email, "email_verified": true})
+	expectStatus(t, "sso login", code, http.StatusOK, resp)
+	if resp["two_factor_required"] != true || resp["access_token"] != nil {
+		t.Errorf("sso bypassed 2FA: %v", resp)
+	}
+}
+
+func TestSSOReauth(t *testing.T) {
+	env := newSSOEnv(t)
+	identity := jwt.MapClaims{"sub": "ext-1", "email": env.user.Email, "email_verified": true}
+	code, resp := env.sso(t, identity)
+	expectStatus(t, "link", code, http.StatusOK, resp)
+
+	fresh := func(sub string, authTime time.Time) jwt.MapClaims {
+		return jwt.MapClaims{"sub": sub, "email": env.user.Email, "email_verified": true, "auth_time": authTime.Unix()}
+	}
+
+	code, resp = env.reauth(t, fresh("ext-1", time.Now()))
+	expectStatus(t, "fresh reauth", code, http.StatusOK, resp)
+	claims, err := env.tokens.VerifyReauth(resp["reauth_token"].(string))
+	if err != nil || claims.UserUUID != env.user.UUID {
+		t.Fatalf("reauth token: %v %v", claims, err)
+	}
+	if resp["access_token"] != nil {
+		t.Error("reauth issued an access token")
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"provider session reused", fresh("ext-1", time.Now().Add(-time.Hour))},
+		{"no auth_time", identity},
+		// Повторный вход ничего не создаёт и не привязывает
+		{"identity not linked", fresh("ext-2", time.Now())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := env.reauth(t, tt.claims)
+			expectStatus(t, "reauth", code, http.StatusUnauthorized, resp)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize      = 20
+	maxPageSize          = 100
+	maxSearchQueryLength = 200
+	minUserQueryLength   = 2 // по одной букве триграммы ничего осмысленного не находят
+)
+
+// pageParams reads limit and offset from the query string
+func pageParams(c *gin.Context) (limit, offset int, ok bool) {
+	limit, offset = defaultPageSize, 0
+
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		limit = min(n, maxPageSize)
+	}
+	if s := c.Query("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// parseSearchTime accepts RFC 3339 or a bare date. Голая дата в верхней границе
+// означает весь этот день, поэтому сдвигаем её на сутки вперёд.
+func parseSearchTime(s string, upper bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
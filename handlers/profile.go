@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"chat-app/config"
+	"chat-app/internal/avatar"
+	"chat-app/internal/mail"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"chat-app/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// NameCache forgets cached display names on every instance, see names.Service
+type NameCache interface {
+	Invalidate(ctx context.Context, userUUID uuid.UUID)
+}
+
+// ProfileDeps are the services the profile handler needs
+type ProfileDeps struct {
+	Users     repository.UserRepository
+	Names     NameCache     // кэш подписей отправителей, может быть nil
+	Redis     *redis.Client // ожидающие подтверждения смены email
+	Mail      mail.Sender
+	Reauth    *Reauth // подтверждение смены email и пароля
+	Storage   config.Storage
+	PublicURL string // для ссылок в письмах
+}
+
+// ProfileHandler serves the current user's profile
+type ProfileHandler struct {
+	users     repository.UserRepository
+	names     NameCache
+	redis     *redis.Client
+	mail      mail.Sender
+	reauth    *Reauth
+	storage   config.Storage
+	publicURL string
+}
+
+// NewProfileHandler creates a profile handler
+func NewProfileHandler(deps ProfileDeps) *ProfileHandler {
+	return &ProfileHandler{
+		users:     deps.Users,
+		names:     deps.Names,
+		redis:     deps.Redis,
+		mail:      deps.Mail,
+		reauth:    deps.Reauth,
+		storage:   deps.Storage,
+		publicURL: strings.TrimSuffix(deps.PublicURL, "/"),
+	}
+}
+
+// Ссылка из письма действует сутки; храним только хэш токена
+const emailChangeTTL = 24 * time.Hour
+
+func emailChangeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "email:change:" + hex.EncodeToString(sum[:])
+}
+
+// emailChangeUserKey points at the user's latest request, старые ссылки перестают работать
+func emailChangeUserKey(userUUID uuid.UUID) string {
+	return "email:change:user:" + userUUID.String()
+}
+
+type emailChange struct {
+	UserUUID uuid.UUID `json:"user_uuid"`
+	Email    string    `json:"email"`
+}
+
+func profileJSON(user *models.User) gin.H {
+	return gin.H{
+		"uuid":            user.UUID.String(),
+		"name":            user.Name,
+		"surname":         user.Surname,
+		"email":           user.Email,
+		"bio":             user.Bio,
+		"locale":          user.Locale,
+		"avatar_url":      user.AvatarURL(),
+		"discoverability": user.Discoverability,
+		"message_privacy": user.MessagePrivacy,
+		"created_at":      user.CreatedAt,
+
+		"deletion_scheduled_at": user.DeletionScheduledAt,
+	}
+}
+
+// currentUser loads the authenticated user or writes the error response
+func (h *ProfileHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userUUIDStr := c.GetString("user_uuid")
+	if userUUIDStr == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	userUUID, err := uuid.Parse(userUUIDStr)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return nil, false
+	}
+
+	user, err := h.users.GetByUUID(c.Request.Context(), userUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "user not found"})
+			return nil, false
+		}
+		c.JSON(500, gin.H{"error": "database error"})
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *ProfileHandler) GetUserProfile(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, gin.H{"user": profileJSON(user)})
+}
+
+// UpdateProfile edits name, surname, bio and locale; omitted fields stay as they are
+func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
+	var input models.ProfileUpdate
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": "invalid input format"})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	oldName := user.DisplayName()
+	input.Apply(user)
+
+	if err := h.users.UpdateProfile(c.Request.Context(), user); err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+
+	// Имя подставляется в сообщения при чтении, достаточно сбросить кэш на всех инстансах
+	if user.DisplayName() != oldName && h.names != nil {
+		h.names.Invalidate(c.Request.Context(), user.UUID)
+	}
+
+	c.JSON(200, gin.H{"user": profileJSON(user)})
+}
+
+// ChangePassword replaces the password after checking the current one.
+// У аккаунта из SSO пароля нет: первый задаётся после подтверждения через Reauth.
+func (h *ProfileHandler) ChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password" binding:"required,min=6"`
+		ReauthToken     string `json:"reauth_token"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": "new_password (at least 6 characters) is required"})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.PasswordHash == "" {
+		reauth := ReauthInput{ReauthToken: input.ReauthToken, Code: input.Code, RecoveryCode: input.RecoveryCode}
+		if !h.reauth.Require(c, user, reauth) {
+			return
+		}
+	} else {
+		if input.CurrentPassword == "" {
+			c.JSON(400, gin.H{"error": "current_password is required"})
+			return
+		}
+		if !utils.CheckPasswordHash(input.CurrentPassword, user.PasswordHash) {
+			c.JSON(401, gin.H{"error": "invalid current password"})
+			return
+		}
+	}
+
+	hash, err := utils.HashPassword(input.NewPassword)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	if err := h.users.UpdatePasswordHash(c.Request.Context(), user.UUID, hash); err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+
+	if user.PasswordHash == "" {
+		log.Printf("User %s set a password", user.UUID)
+	} else {
+		log.Printf("User %s changed password", user.UUID)
+	}
+	c.JSON(200, gin.H{"message": "password changed"})
+}
+
+// RequestEmailChange sends a confirmation link to the new address.
+// Email меняется только после перехода по ссылке, до этого вход по старому.
+// Запрос подтверждается паролем или, у аккаунта без пароля, см. Reauth.
+func (h *ProfileHandler) RequestEmailChange(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+		ReauthInput
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": "email is required"})
+		return
+	}
+
+	newEmail := strings.ToLower(strings.TrimSpace(input.Email))
+	if !models.ValidEmail(newEmail) {
+		c.JSON(400, gin.H{"error": "invalid email"})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !h.reauth.Require(c, user, input.ReauthInput) {
+		return
+	}
+	if newEmail == user.Email {
+		c.JSON(400, gin.H{"error": "this is already your email"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	exists, err := h.users.EmailExists(ctx, newEmail)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+	if exists {
+		c.JSON(409, gin.H{"error": "email already registered"})
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(500, gin.H{"error": "failed to create confirmation"})
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	data, _ := json.Marshal(emailChange{UserUUID: user.UUID, Email: newEmail})
+	_, err = h.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, emailChangeKey(token), data, emailChangeTTL)
+		pipe.Set(ctx, emailChangeUserKey(user.UUID), emailChangeKey(token), emailChangeTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Не удалось сохранить смену email: %v", err)
+		c.JSON(500, gin.H{"error": "failed to create confirmation"})
+		return
+	}
+
+	link := h.publicURL + "/api/v1/email/confirm?token=" + url.QueryEscape(token)
+	err = h.mail.Send(ctx, mail.Message{
+		To:      newEmail,
+		Subject: "Подтвердите новый email",
+		Body: fmt.Sprintf("Чтобы привязать этот адрес к аккаунту, перейдите по ссылке:\n\n%s\n\n"+
+			"Ссылка действует %d часа. Если вы не меняли email, просто проигнорируйте письмо.\n",
+			link, int(emailChangeTTL.Hours())),
+	})
+	if err != nil {
+		log.Printf("Не удалось отправить письмо подтверждения для %s: %v", user.UUID, err)
+		c.JSON(502, gin.H{"error": "failed to send confirmation email"})
+		return
+	}
+
+	// Старый адрес предупреждаем, но не ждём: письмо на новый уже ушло
+	go func(old string) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := h.mail.Send(ctx, mail.Message{
+			To:      old,
+			Subject: "Запрошена смена email",
+			Body:    "Для вашего аккаунта запрошена смена email. Если это были не вы, смените пароль.\n",
+		}); err != nil {
+			log.Printf("Не удалось уведомить старый адрес %s: %v", user.UUID, err)
+		}
+	}(user.Email)
+
+	c.JSON(202, gin.H{"message": "confirmation sent to the new email"})
+}
+
+// ConfirmEmailChange applies the change from the emailed link. Токен сам по себе
+// подтверждает владение адресом, поэтому маршрут публичный.
+func (h *ProfileHandler) ConfirmEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := emailChangeKey(token)
+
+	data, err := h.redis.GetDel(ctx, key).Bytes()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid or expired link"})
+		return
+	}
+
+	var change emailChange
+	if err := json.Unmarshal(data, &change); err != nil {
+		c.JSON(400, gin.H{"error": "invalid or expired link"})
+		return
+	}
+
+	// Действует только последний запрос пользователя
+	latest, err := h.redis.Get(ctx, emailChangeUserKey(change.UserUUID)).Result()
+	if err != nil || latest != key {
+		c.JSON(400, gin.H{"error": "invalid or expired link"})
+		return
+	}
+
+	err = h.users.UpdateEmail(ctx, change.UserUUID, change.Email)
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(409, gin.H{"error": "email already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+	h.redis.Del(ctx, emailChangeUserKey(change.UserUUID))
+
+	log.Printf("User %s confirmed a new email", change.UserUUID)
+	c.JSON(200, gin.H{"message": "email changed", "email": change.Email})
+}
+
+// UpdateDiscoverability sets who can find the current user in search:
+// name (по имени и точному email), email (только по точному email) или none
+func (h *ProfileHandler) UpdateDiscoverability(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	var input struct {
+		Discoverability string `json:"discoverability" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !models.ValidDiscoverability(input.Discoverability) {
+		c.JSON(400, gin.H{"error": "discoverability must be name, email or none"})
+		return
+	}
+
+	err = h.users.SetDiscoverability(c.Request.Context(), userUUID, input.Discoverability)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"discoverability": input.Discoverability})
+}
+
+func (h *ProfileHandler) avatarPath(userUUID uuid.UUID) string {
+	return avatar.Path(h.storage.Dir, userUUID)
+}
+
+// UploadAvatar accepts an image in the "avatar" form field and stores a
+// square 256×256 JPEG; исходник не сохраняем
+func (h *ProfileHandler) UploadAvatar(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	// Запас на заголовки multipart сверх размера самого файла
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.storage.MaxUploadSize+64<<10)
+
+	file, header, err := c.Request.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(413, gin.H{"error": fmt.Sprintf("file is larger than %d bytes", h.storage.MaxUploadSize)})
+			return
+		}
+		c.JSON(400, gin.H{"error": "avatar file is required"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.storage.MaxUploadSize {
+		c.JSON(413, gin.H{"error": fmt.Sprintf("file is larger than %d bytes", h.storage.MaxUploadSize)})
+		return
+	}
+
+	data, err := avatar.Process(file)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	path := h.avatarPath(userUUID)
+	if err := writeFileAtomic(path, data); err != nil {
+		log.Printf("Не удалось сохранить аватар %s: %v", userUUID, err)
+		c.JSON(500, gin.H{"error": "failed to store avatar"})
+		return
+	}
+
+	now := time.Now()
+	if err := h.users.SetAvatar(c.Request.Context(), userUUID, &now); err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+
+	user := models.User{UUID: userUUID, AvatarUpdatedAt: &now}
+	c.JSON(200, gin.H{"avatar_url": user.AvatarURL()})
+}
+
+// DeleteAvatar removes the current user's avatar
+func (h *ProfileHandler) DeleteAvatar(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	if err := h.users.SetAvatar(c.Request.Context(), userUUID, nil); err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return
+	}
+	if err := os.Remove(h.avatarPath(userUUID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Не удалось удалить файл аватара %s: %v", userUUID, err)
+	}
+
+	c.JSON(200, gin.H{"message": "avatar removed"})
+}
+
+// GetAvatar serves a user's avatar. URL содержит время загрузки, поэтому
+// ответ можно долго кэшировать.
+func (h *ProfileHandler) GetAvatar(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.Param("user_uuid"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user uuid"})
+		return
+	}
+
+	path := h.avatarPath(userUUID)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(404, gin.H{"error": "avatar not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(path)
+}
+
+// writeFileAtomic writes through a temp file so readers never see half an image
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
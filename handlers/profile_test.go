@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"chat-app/internal/mail"
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sentMail records letters instead of sending them
+type sentMail struct {
+	mu sync.Mutex
+	to []string
+}
+
+func (m *sentMail) Send(_ context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.to = append(m.to, msg.To)
+	return nil
+}
+
+func (m *sentMail) sentTo(addr string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Contains(m.to, addr)
+}
+
+// newProfileEnv adds ProfileHandler routes to an SSO env; the user is linked to ext-1
+func newProfileEnv(t *testing.T) (*ssoEnv, *sentMail) {
+	t.Helper()
+	env := newSSOEnv(t)
+	sent := &sentMail{}
+
+	h := NewProfileHandler(ProfileDeps{
+		Users:  env.users,
+		Redis:  env.redis,
+		Mail:   sent,
+		Reauth: NewReauth(env.tokens, env.twoFactor),
+	})
+	env.authed.PUT("/profile/password", h.ChangePassword)
+	env.authed.POST("/profile/email", h.RequestEmailChange)
+
+	code, resp := env.sso(t, jwt.MapClaims{"sub": "ext-1", "email": env.user.Email, "email_verified": true})
+	expectStatus(t, "link", code, http.StatusOK, resp)
+	return env, sent
+}
+
+// reauthCase is a way to confirm a profile change; body gets the 2FA secret and recovery codes when with2FA
+type reauthCase struct {
+	name     string
+	password bool
+	with2FA  bool
+	body     func(env *ssoEnv, secret string, recovery []string) gin.H
+	want     int
+}
+
+func freshSSO(env *ssoEnv, t *testing.T) string {
+	t.Helper()
+	_, resp := env.reauth(t, jwt.MapClaims{"sub": "ext-1", "auth_time": time.Now().Unix()})
+	return resp["reauth_token"].(string)
+}
+
+func TestChangePassword(t *testing.T) {
+	const newPassword = "new password"
+	tests := []reauthCase{
+		{"no current password", true, false, func(*ssoEnv, string, []string) gin.H { return gin.H{} }, http.StatusBadRequest},
+		{"wrong current password", true, false, func(*ssoEnv, string, []string) gin.H {
+			return gin.H{"current_password": "wrong password"}
+		}, http.StatusUnauthorized},
+		{"reauth_token instead of current password", true, false, func(env *ssoEnv, _ string, _ []string) gin.H {
+			return gin.H{"reauth_token": freshSSO(env, t)}
+		}, http.StatusBadRequest},
+		{"current password", true, false, func(*ssoEnv, string, []string) gin.H {
+			return gin.H{"current_password": testPassword}
+		}, http.StatusOK},
+
+		// Аккаунт из SSO задаёт первый пароль
+		{"first password unconfirmed", false, false, func(*ssoEnv, string, []string) gin.H { return gin.H{} }, http.StatusBadRequest},
+		{"first password with a made-up current password", false, false, func(*ssoEnv, string, []string) gin.H {
+			return gin.H{"current_password": "anything"}
+		}, http.StatusBadRequest},
+		{"first password after fresh sso login", false, false, func(env *ssoEnv, _ string, _ []string) gin.H {
+			return gin.H{"reauth_token": freshSSO(env, t)}
+		}, http.StatusOK},
+		{"first password with a bad reauth_token", false, false, func(*ssoEnv, string, []string) gin.H {
+			return gin.H{"reauth_token": "garbage"}
+		}, http.StatusUnauthorized},
+		{"first password with a 2FA code", false, true, func(_ *ssoEnv, secret string, _ []string) gin.H {
+			return gin.H{"code": totpCode(t, secret, time.Now().Add(30*time.Second))}
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, _ := newProfileEnv(t)
+			var secret string
+			var recovery []string
+			if tt.with2FA {
+				secret, recovery = env.enableTwoFactor(t)
+			}
+			if !tt.password {
+				env.dropPassword(t)
+			}
+
+			body := tt.body(env, secret, recovery)
+			body["new_password"] = newPassword
+			code, resp := env.do(t, http.MethodPut, "/profile/password", body)
+			expectStatus(t, "change password", code, tt.want, resp)
+
+			login := gin.H{"email": env.user.Email, "password": newPassword}
+			code, resp = env.do(t, http.MethodPost, "/login", login)
+			if changed := code == http.StatusOK; changed != (tt.want == http.StatusOK) {
+				t.Errorf("login with the new password = %d %v", code, resp)
+			}
+		})
+	}
+}
+
+func TestRequestEmailChange(t *testing.T) {
+	const newEmail = "ann.new@example.com"
+	tests := []reauthCase{
+		{"no password", true, false, func(*ssoEnv, string, []string) gin.H { return gin.H{} }, http.StatusBadRequest},
+		{"wrong password", true, false, func(*ssoEnv, string, []string) gin.H {
+			return gin.H{"password": "wrong password"}
+		}, http.StatusUnauthorized},
+		{"password", true, false, func(*ssoEnv, string, []string) gin.H {
+			return gin.H{"password": testPassword}
+		}, http.StatusAccepted},
+
+		{"sso account unconfirmed", false, false, func(*ssoEnv, string, []string) gin.H { return gin.H{} }, http.StatusBadRequest},
+		{"sso account after fresh sso login", false, false, func(env *ssoEnv, _ string, _ []string) gin.H {
+			return gin.H{"reauth_token": freshSSO(env, t)}
+		}, http.StatusAccepted},
+		{"sso account with a recovery code", false, true, func(_ *ssoEnv, _ string, recovery []string) gin.H {
+			return gin.H{"recovery_code": recovery[0]}
+		}, http.StatusAccepted},
+		{"sso account with a wrong code", false, true, func(*ssoEnv, string, []string) gin.H {
+			return gin.H{"recovery_code": "nope"}
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, sent := newProfileEnv(t)
+			var secret string
+			var recovery []string
+			if tt.with2FA {
+				secret, recovery = env.enableTwoFactor(t)
+			}
+			if !tt.password {
+				env.dropPassword(t)
+			}
+
+			body := tt.body(env, secret, recovery)
+			body["email"] = newEmail
+			code, resp := env.do(t, http.MethodPost, "/profile/email", body)
+			expectStatus(t, "request email change", code, tt.want, resp)
+			if sent.sentTo(newEmail) != (tt.want == http.StatusAccepted) {
+				t.Errorf("confirmation sent = %v", sent.sentTo(newEmail))
+			}
+		})
+	}
+}
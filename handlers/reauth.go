@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"chat-app/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReauthInput is how the user confirms a sensitive action: паролем, а если его
+// нет (аккаунт из SSO) — свежим входом через провайдера или кодом 2FA
+type ReauthInput struct {
+	Password     string `json:"password"`
+	ReauthToken  string `json:"reauth_token"` // из /oidc/login?purpose=reauth
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Reauth checks that whoever holds the session is the account owner
+type Reauth struct {
+	tokens    *auth.TokenService
+	twoFactor repository.TwoFactorRepository
+}
+
+// NewReauth creates the checker shared by account and profile handlers
+func NewReauth(tokens *auth.TokenService, twoFactor repository.TwoFactorRepository) *Reauth {
+	return &Reauth{tokens: tokens, twoFactor: twoFactor}
+}
+
+// Require checks the input or writes the error response.
+// У кого есть пароль, подтверждает только паролем.
+func (r *Reauth) Require(c *gin.Context, user *models.User, in ReauthInput) bool {
+	if user.PasswordHash != "" {
+		if in.Password == "" {
+			c.JSON(400, gin.H{"error": "password is required"})
+			return false
+		}
+		if !utils.CheckPasswordHash(in.Password, user.PasswordHash) {
+			c.JSON(401, gin.H{"error": "invalid password"})
+			return false
+		}
+		return true
+	}
+
+	switch {
+	case in.ReauthToken != "":
+		claims, err := r.tokens.VerifyReauth(in.ReauthToken)
+		if err != nil || claims.UserUUID != user.UUID {
+			c.JSON(401, gin.H{"error": "invalid or expired reauth_token, log in with your provider again"})
+			return false
+		}
+		return true
+	case in.Code != "" || in.RecoveryCode != "":
+		return r.requireSecondFactor(c, user, in)
+	default:
+		c.JSON(400, gin.H{"error": "account has no password: confirm with reauth_token from a fresh SSO login or a 2FA code"})
+		return false
+	}
+}
+
+// requireSecondFactor accepts a TOTP or recovery code once, как при входе
+func (r *Reauth) requireSecondFactor(c *gin.Context, user *models.User, in ReauthInput) bool {
+	ctx := c.Request.Context()
+
+	tf, err := r.twoFactor.Get(ctx, user.UUID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return false
+	}
+	if !tf.Enabled || tf.Secret == "" {
+		c.JSON(400, gin.H{"error": "two-factor authentication is not enabled, use reauth_token"})
+		return false
+	}
+
+	var ok bool
+	if in.Code != "" {
+		step, valid := utils.AcceptTOTP(tf.Secret, in.Code, time.Now(), tf.LastUsedStep)
+		if valid {
+			ok, err = r.twoFactor.UseStep(ctx, user.UUID, step)
+		}
+	} else {
+		ok, err = r.twoFactor.UseRecoveryCode(ctx, user.UUID, utils.HashRecoveryCode(in.RecoveryCode))
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "database error"})
+		return false
+	}
+	if !ok {
+		c.JSON(401, gin.H{"error": "invalid code"})
+		return false
+	}
+	return true
+}
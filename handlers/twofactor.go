@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"chat-app/internal/repository"
+	"chat-app/utils"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const totpIssuer = "Chat App"
+
+// EnrollTwoFactor generates a new TOTP secret and recovery codes.
+// 2FA не включается, пока пользователь не подтвердит первый код.
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user uuid"})
+		return
+	}
+
+	user, err := h.users.GetByUUID(ctx, userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication already enabled"})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	if err := h.twoFactor.Enroll(ctx, userUUID, secret, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":         secret,
+		"otpauth_uri":    utils.TOTPURI(totpIssuer, user.Email, secret),
+		"recovery_codes": codes,
+	})
+}
+
+// ConfirmTwoFactor enables 2FA once the user proves the authenticator works
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user uuid"})
+		return
+	}
+
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+
+	tf, err := h.twoFactor.Get(ctx, userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if tf.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication already enabled"})
+		return
+	}
+	if tf.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor enrollment not started"})
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(tf.Secret, input.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := h.twoFactor.Enable(ctx, userUUID, step); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled"})
+}
+
+// VerifyTwoFactor completes a login started with a challenge token
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var input struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Code == "" && input.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "challenge_token and code or recovery_code are required"})
+		return
+	}
+
+	challenge, err := h.tokens.VerifyChallenge(input.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+	userUUID := challenge.UserUUID
+
+	user, err := h.users.GetByUUID(ctx, userUUID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+		return
+	}
+	tf, err := h.twoFactor.Get(ctx, userUUID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && (!tf.Enabled || tf.Secret == "")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+		return
+	}
+	email := user.Email
+
+	if wait := h.guard.Check(ctx, email, c.ClientIP()); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts, try again later"})
+		return
+	}
+
+	if input.Code != "" {
+		step, ok := utils.AcceptTOTP(tf.Secret, input.Code, time.Now(), tf.LastUsedStep)
+		if !ok {
+			h.guard.Failure(ctx, email, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		// UseStep защищает от повторного использования кода параллельным запросом
+		fresh, err := h.twoFactor.UseStep(ctx, userUUID, step)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+			return
+		}
+		if !fresh {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+	} else {
+		used, err := h.twoFactor.UseRecoveryCode(ctx, userUUID, utils.HashRecoveryCode(input.RecoveryCode))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login process failed"})
+			return
+		}
+		if !used {
+			h.guard.Failure(ctx, email, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid recovery code"})
+			return
+		}
+		log.Printf("User %s logged in with a recovery code", userUUID)
+	}
+
+	h.guard.Success(ctx, email)
+	h.respondWithAccessToken(c, userUUID, email)
+}
+
+// DisableTwoFactor turns 2FA off after re-checking the password and a current code.
+// У аккаунта из SSO пароля нет, ему достаточно кода.
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user uuid"})
+		return
+	}
+
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Code == "" && input.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+
+	user, err := h.users.GetByUUID(ctx, userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	tf, err := h.twoFactor.Get(ctx, userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !tf.Enabled || tf.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	if user.PasswordHash != "" && !utils.CheckPasswordHash(input.Password, user.PasswordHash) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	if input.Code != "" {
+		if _, ok := utils.ValidateTOTP(tf.Secret, input.Code, time.Now()); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+	} else {
+		exists, err := h.twoFactor.HasRecoveryCode(ctx, userUUID, utils.HashRecoveryCode(input.RecoveryCode))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid recovery code"})
+			return
+		}
+	}
+
+	if err := h.twoFactor.Disable(ctx, userUUID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
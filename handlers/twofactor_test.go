@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bytes"
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/ratelimit"
+	"chat-app/internal/repository"
+	"chat-app/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const testPassword = "correct horse battery"
+
+// authEnv is an AuthHandler over in-memory repositories and a fake Redis
+type authEnv struct {
+	h         *AuthHandler
+	users     *repository.MemoryUsers
+	twoFactor repository.TwoFactorRepository
+	tokens    *auth.TokenService
+	redis     *redis.Client
+	router    *gin.Engine
+	authed    *gin.RouterGroup // маршруты от имени user
+	user      *models.User
+}
+
+func newAuthEnv(t *testing.T) *authEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	policy := ratelimit.DefaultLoginPolicy
+	policy.FreeAttempts = 100 // задержки гарда проверяются в другом месте
+
+	env := &authEnv{
+		users:  repository.NewMemoryUsers(),
+		tokens: auth.NewTokenService(keys, time.Hour),
+		redis:  client,
+	}
+	env.twoFactor = repository.NewMemoryTwoFactor(env.users)
+	env.h = NewAuthHandler(env.users, env.twoFactor, env.tokens, ratelimit.NewLoginGuard(client, policy))
+
+	hash, err := utils.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.user = &models.User{Name: "Ann", Email: "ann@example.com", PasswordHash: hash}
+	if err := env.users.Create(context.Background(), env.user); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/login", env.h.Login)
+	r.POST("/2fa/verify", env.h.VerifyTwoFactor)
+	authed := r.Group("/", func(c *gin.Context) {
+		c.Set("user_uuid", env.user.UUID.String())
+	})
+	authed.POST("/2fa/enroll", env.h.EnrollTwoFactor)
+	authed.POST("/2fa/confirm", env.h.ConfirmTwoFactor)
+	authed.POST("/2fa/disable", env.h.DisableTwoFactor)
+	env.router, env.authed = r, authed
+	return env
+}
+
+// do sends a JSON request and decodes the JSON response
+func (e *authEnv) do(t *testing.T, method, path string, body any) (int, map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+
+	var resp map[string]any
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func (e *authEnv) login(t *testing.T) map[string]any {
+	t.Helper()
+	code, resp := e.do(t, http.MethodPost, "/login", gin.H{"email": e.user.Email, "password": testPassword})
+	if code != http.StatusOK {
+		t.Fatalf("login = %d %v", code, resp)
+	}
+	return resp
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enableTwoFactor enrolls and confirms 2FA for the env user
+func (e *authEnv) enableTwoFactor(t *testing.T) (secret string, recovery []string) {
+	t.Helper()
+	code, enrolled := e.do(t, http.MethodPost, "/2fa/enroll", nil)
+	expectStatus(t, "enroll", code, http.StatusOK, enrolled)
+	secret = enrolled["secret"].(string)
+	for _, c := range enrolled["recovery_codes"].([]any) {
+		recovery = append(recovery, c.(string))
+	}
+	code, resp := e.do(t, http.MethodPost, "/2fa/confirm", gin.H{"code": totpCode(t, secret, time.Now())})
+	expectStatus(t, "confirm", code, http.StatusOK, resp)
+	return secret, recovery
+}
+
+// dropPassword turns the env user into an SSO-only account
+func (e *authEnv) dropPassword(t *testing.T) {
+	t.Helper()
+	if err := e.users.UpdatePasswordHash(context.Background(), e.user.UUID, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectStatus(t *testing.T, what string, got, want int, resp map[string]any) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("%s = %d %v, want %d", what, got, resp, want)
+	}
+}
+
+func TestTwoFactorFlow(t *testing.T) {
+	env := newAuthEnv(t)
+	now := time.Now()
+
+	code, enrolled := env.do(t, http.MethodPost, "/2fa/enroll", nil)
+	expectStatus(t, "enroll", code, http.StatusOK, enrolled)
+	secret := enrolled["secret"].(string)
+	var recovery []string
+	for _, c := range enrolled["recovery_codes"].([]any) {
+		recovery = append(recovery, c.(string))
+	}
+
+	// Пока код не подтверждён, 2FA не действует
+	if resp := env.login(t); resp["access_token"] == nil {
+		t.Fatalf("unconfirmed 2FA required a second factor: %v", resp)
+	}
+
+	code, resp := env.do(t, http.MethodPost, "/2fa/confirm", gin.H{"code": "000000"})
+	if totpCode(t, secret, now) == "000000" {
+		t.Skip("random secret produced 000000")
+	}
+	expectStatus(t, "confirm with a wrong code", code, http.StatusUnauthorized, resp)
+
+	confirmCode := totpCode(t, secret, now)
+	code, resp = env.do(t, http.MethodPost, "/2fa/confirm", gin.H{"code": confirmCode})
+	expectStatus(t, "confirm", code, http.StatusOK, resp)
+
+	code, resp = env.do(t, http.MethodPost, "/2fa/enroll", nil)
+	expectStatus(t, "enroll again", code, http.StatusConflict, resp)
+
+	challenge := func() string {
+		t.Helper()
+		resp := env.login(t)
+		if resp["two_factor_required"] != true {
+			t.Fatalf("login without second factor: %v", resp)
+		}
+		return resp["challenge_token"].(string)
+	}
+
+	// Код, которым подтверждали подключение, второй раз не принимается
+	code, resp = env.do(t, http.MethodPost, "/2fa/verify", gin.H{"challenge_token": challenge(), "code": confirmCode})
+	expectStatus(t, "verify with the confirmation code", code, http.StatusUnauthorized, resp)
+
+	next := totpCode(t, secret, now.Add(30*time.Second))
+	code, resp = env.do(t, http.MethodPost, "/2fa/verify", gin.H{"challenge_token": challenge(), "code": next})
+	expectStatus(t, "verify", code, http.StatusOK, resp)
+	if claims, err := env.tokens.VerifyAccess(resp["access_token"].(string)); err != nil || claims.UserUUID != env.user.UUID {
+		t.Fatalf("access token after 2FA: %v %v", claims, err)
+	}
+
+	code, resp = env.do(t, http.MethodPost, "/2fa/verify", gin.H{"challenge_token": challenge(), "code": next})
+	expectStatus(t, "replayed code", code, http.StatusUnauthorized, resp)
+
+	code, resp = env.do(t, http.MethodPost, "/2fa/verify", gin.H{"challenge_token": challenge(), "recovery_code": recovery[0]})
+	expectStatus(t, "recovery code", code, http.StatusOK, resp)
+	code, resp = env.do(t, http.MethodPost, "/2fa/verify", gin.H{"challenge_token": challenge(), "recovery_code": recovery[0]})
+	expectStatus(t, "used recovery code", code, http.StatusUnauthorized, resp)
+
+	code, resp = env.do(t, http.MethodPost, "/2fa/verify", gin.H{"challenge_token": "garbage", "code": next})
+	expectStatus(t, "bad challenge", code, http.StatusUnauthorized, resp)
+
+	code, resp = env.do(t, http.MethodPost, "/2fa/disable", gin.H{"password": "wrong password", "recovery_code": recovery[1]})
+	expectStatus(t, "disable with a wrong password", code, http.StatusUnauthorized, resp)
+	code, resp = env.do(t, http.MethodPost, "/2fa/disable", gin.H{"password": testPassword, "recovery_code": recovery[0]})
+	expectStatus(t, "disable with a used recovery code", code, http.StatusUnauthorized, resp)
+	code, resp = env.do(t, http.MethodPost, "/2fa/disable", gin.H{"password": testPassword, "recovery_code": recovery[1]})
+	expectStatus(t, "disable", code, http.StatusOK, resp)
+
+	if resp := env.login(t); resp["access_token"] == nil {
+		t.Fatalf("2FA still required after disabling: %v", resp)
+	}
+	code, resp = env.do(t, http.MethodPost, "/2fa/confirm", gin.H{"code": next})
+	expectStatus(t, "confirm after disable", code, http.StatusBadRequest, resp)
+}
+
+func TestDisableTwoFactorWithoutPassword(t *testing.T) {
+	t.Run("password account needs the password", func(t *testing.T) {
+		env := newAuthEnv(t)
+		_, recovery := env.enableTwoFactor(t)
+		code, resp := env.do(t, http.MethodPost, "/2fa/disable", gin.H{"recovery_code": recovery[0]})
+		expectStatus(t, "disable without password", code, http.StatusUnauthorized, resp)
+	})
+
+	// Аккаунт из SSO: пароля нет, отключаем по коду
+	for _, by := range []string{"code", "recovery_code"} {
+		t.Run("sso account by "+by, func(t *testing.T) {
+			env := newAuthEnv(t)
+			secret, recovery := env.enableTwoFactor(t)
+			env.dropPassword(t)
+
+			code, resp := env.do(t, http.MethodPost, "/2fa/disable", gin.H{"recovery_code": "wrong-code"})
+			expectStatus(t, "disable with a wrong code", code, http.StatusUnauthorized, resp)
+			code, resp = env.do(t, http.MethodPost, "/2fa/disable", gin.H{})
+			expectStatus(t, "disable without a code", code, http.StatusBadRequest, resp)
+
+			body := gin.H{by: recovery[0]}
+			if by == "code" {
+				body = gin.H{by: totpCode(t, secret, time.Now())}
+			}
+			code, resp = env.do(t, http.MethodPost, "/2fa/disable", body)
+			expectStatus(t, "disable", code, http.StatusOK, resp)
+
+			user, err := env.users.GetByUUID(context.Background(), env.user.UUID)
+			if err != nil || user.TOTPEnabled {
+				t.Errorf("2FA still enabled: %+v %v", user, err)
+			}
+		})
+	}
+}
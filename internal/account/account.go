@@ -0,0 +1,190 @@
+// Package account carries out the user's data rights: выгрузка всех данных
+// в ZIP по подписанной ссылке и удаление аккаунта после периода, когда его
+// ещё можно отменить. Both run in the background of the API process.
+package account
+
+import (
+	"chat-app/internal/auth"
+	"chat-app/internal/avatar"
+	"chat-app/internal/mail"
+	"chat-app/internal/names"
+	"chat-app/internal/repository"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notifier pushes events to a user's notification sockets, see ws.Hub.Notify
+type Notifier interface {
+	Notify(userUUID uuid.UUID, event string, data any) error
+}
+
+// Deps are the stores and services the account jobs use
+type Deps struct {
+	Accounts repository.AccountRepository
+	Users    repository.UserRepository
+	Chats    repository.ChatRepository
+	Messages repository.MessageRepository
+	Contacts repository.ContactRepository
+	Blocks   repository.BlockRepository
+	Names    *names.Service
+	Tokens   *auth.TokenService // подписывает ссылки на выгрузку
+	Revoked  *auth.Revocations  // nil — сессии не отзываются
+	Notifier Notifier           // nil — без уведомлений в сокет
+	Mail     mail.Sender        // nil — без писем
+}
+
+// Options tune the account jobs
+type Options struct {
+	StorageDir    string        // выгрузки лежат в exports/ внутри, аватары в avatars/
+	PublicURL     string        // для ссылок на выгрузку
+	DeletionGrace time.Duration // сколько ждём перед удалением
+	ExportTTL     time.Duration // сколько хранится архив
+	PurgeInterval time.Duration // как часто ищем аккаунты к удалению; 0 — раз в 10 минут
+}
+
+// purgeBatch bounds how many accounts one pass erases
+const purgeBatch = 100
+
+// Service schedules deletions, erases due accounts and builds exports
+type Service struct {
+	deps Deps
+	opts Options
+
+	mu     sync.Mutex // не даёт одному пользователю запустить две выгрузки на инстансе
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates the service; call Start to run the purge loop
+func New(deps Deps, opts Options) *Service {
+	if opts.PurgeInterval <= 0 {
+		opts.PurgeInterval = 10 * time.Minute
+	}
+	opts.PublicURL = strings.TrimSuffix(opts.PublicURL, "/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{deps: deps, opts: opts, ctx: ctx, cancel: cancel}
+}
+
+// Start runs the purge loop: удаление аккаунтов с истёкшим сроком и старых выгрузок
+func (s *Service) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.opts.PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			s.PurgeDue(s.ctx)
+			s.cleanupExports()
+
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the purge loop and cancels running exports, then waits for them
+func (s *Service) Close() error {
+	// Под mu, чтобы StartExport не добавил задачу в уже ожидаемую группу
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// ScheduleDeletion plans the account's erasure after the grace period and
+// signs the user out everywhere. Войти снова и отменить удаление можно до срока.
+func (s *Service) ScheduleDeletion(ctx context.Context, userUUID uuid.UUID) (time.Time, error) {
+	at := time.Now().Add(s.opts.DeletionGrace).Truncate(time.Second)
+	if err := s.deps.Accounts.ScheduleDeletion(ctx, userUUID, at); err != nil {
+		return time.Time{}, err
+	}
+	s.revoke(ctx, userUUID)
+	log.Printf("Удаление аккаунта %s запланировано на %s", userUUID, at.Format(time.RFC3339))
+	return at, nil
+}
+
+// CancelDeletion keeps the account; repository.ErrNotFound if no deletion was pending
+func (s *Service) CancelDeletion(ctx context.Context, userUUID uuid.UUID) error {
+	if err := s.deps.Accounts.CancelDeletion(ctx, userUUID); err != nil {
+		return err
+	}
+	log.Printf("Удаление аккаунта %s отменено", userUUID)
+	return nil
+}
+
+// PurgeDue erases every account whose grace period is over
+func (s *Service) PurgeDue(ctx context.Context) {
+	for {
+		due, err := s.deps.Accounts.DueForDeletion(ctx, time.Now(), purgeBatch)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Не удалось найти аккаунты к удалению: %v", err)
+			}
+			return
+		}
+
+		purged := 0
+		for _, userUUID := range due {
+			if err := s.purge(ctx, userUUID); err != nil {
+				if !errors.Is(err, repository.ErrNotFound) {
+					log.Printf("Не удалось удалить аккаунт %s: %v", userUUID, err)
+				}
+				continue
+			}
+			purged++
+		}
+
+		// Неполная пачка — больше никого нет; если ни один не удалился, не крутимся на ошибках
+		if len(due) < purgeBatch || purged == 0 {
+			return
+		}
+	}
+}
+
+func (s *Service) purge(ctx context.Context, userUUID uuid.UUID) error {
+	// ErrNotFound: удаление отменили или его уже сделал другой инстанс
+	if err := s.deps.Accounts.Purge(ctx, userUUID, time.Now()); err != nil {
+		return err
+	}
+
+	// Файлы — после базы: если упадём здесь, аккаунта уже нет, остатки уберёт cleanupExports
+	if err := os.Remove(avatar.Path(s.opts.StorageDir, userUUID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Не удалось удалить аватар %s: %v", userUUID, err)
+	}
+	if err := os.RemoveAll(s.exportDir(userUUID)); err != nil {
+		log.Printf("Не удалось удалить выгрузки %s: %v", userUUID, err)
+	}
+
+	s.revoke(ctx, userUUID)
+	if s.deps.Names != nil {
+		s.deps.Names.Invalidate(ctx, userUUID)
+	}
+
+	log.Printf("Аккаунт %s удалён", userUUID)
+	return nil
+}
+
+// revoke signs the user out of every session. Ошибку только логируем:
+// токены всё равно истекут, а удаление из-за Redis откатывать не стоит.
+func (s *Service) revoke(ctx context.Context, userUUID uuid.UUID) {
+	if s.deps.Revoked == nil {
+		return
+	}
+	if err := s.deps.Revoked.RevokeAll(ctx, userUUID); err != nil {
+		log.Printf("Не удалось отозвать сессии %s: %v", userUUID, err)
+	}
+}
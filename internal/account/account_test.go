@@ -0,0 +1,237 @@
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// env is the service over in-memory stores with Ann, Bob and Eve talking in
+// a direct chat (Ann и Bob) and a group (все трое)
+type env struct {
+	s        *Service
+	users    *repository.MemoryUsers
+	chats    *repository.MemoryChats
+	messages *repository.MemoryMessages
+	blocks   *repository.MemoryBlocks
+
+	ann, bob, eve models.User
+	direct, group models.Chat
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	ctx := context.Background()
+
+	e := &env{
+		users:    repository.NewMemoryUsers(),
+		chats:    repository.NewMemoryChats(),
+		messages: repository.NewMemoryMessages(),
+		blocks:   repository.NewMemoryBlocks(),
+	}
+	contacts := repository.NewMemoryContacts()
+	e.s = New(Deps{
+		Accounts: repository.NewMemoryAccounts(e.users, e.chats, e.messages, contacts, e.blocks),
+		Users:    e.users,
+		Chats:    e.chats,
+		Messages: e.messages,
+		Contacts: contacts,
+		Blocks:   e.blocks,
+	}, Options{StorageDir: t.TempDir()})
+	t.Cleanup(func() { e.s.Close() })
+
+	for name, u := range map[string]*models.User{"Ann": &e.ann, "Bob": &e.bob, "Eve": &e.eve} {
+		*u = models.User{Name: name, Email: name + "@example.com"}
+		if err := e.users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.direct = models.Chat{Type: models.ChatDirect, Participants: []uuid.UUID{e.ann.UUID, e.bob.UUID}, CreatorUUID: e.ann.UUID}
+	e.group = models.Chat{Type: models.ChatGroup, Name: "Team", Participants: []uuid.UUID{e.ann.UUID, e.bob.UUID, e.eve.UUID}, CreatorUUID: e.ann.UUID}
+	for _, chat := range []*models.Chat{&e.direct, &e.group} {
+		if err := e.chats.Create(ctx, chat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+func (e *env) send(t *testing.T, chat models.Chat, from models.User, content string) models.Message {
+	t.Helper()
+	msg := models.Message{ChatUUID: chat.UUID, SenderUUID: from.UUID, Content: content}
+	if err := e.messages.Create(context.Background(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestPurgeDue(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+
+	fromBob := e.send(t, e.direct, e.bob, "hi Ann")
+	e.send(t, e.direct, e.ann, "hi Bob")
+	e.send(t, e.group, e.bob, "hi all")
+	e.send(t, e.group, e.eve, "hey")
+	if err := e.blocks.Block(ctx, e.bob.UUID, e.eve.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Пока срок не вышел, аккаунт не трогаем
+	e.s.opts.DeletionGrace = time.Hour
+	if _, err := e.s.ScheduleDeletion(ctx, e.bob.UUID); err != nil {
+		t.Fatal(err)
+	}
+	e.s.PurgeDue(ctx)
+	if _, err := e.users.GetByUUID(ctx, e.bob.UUID); err != nil {
+		t.Fatalf("purged before the grace period ended: %v", err)
+	}
+
+	e.s.opts.DeletionGrace = 0
+	if _, err := e.s.ScheduleDeletion(ctx, e.bob.UUID); err != nil {
+		t.Fatal(err)
+	}
+	e.s.PurgeDue(ctx)
+
+	if _, err := e.users.GetByUUID(ctx, e.bob.UUID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("user after purge: %v", err)
+	}
+	if blocked, _ := e.blocks.ListBlocked(ctx, e.bob.UUID); len(blocked) != 0 {
+		t.Errorf("blocks after purge: %v", blocked)
+	}
+	if chats, _ := e.chats.ListForUser(ctx, e.bob.UUID); len(chats) != 0 {
+		t.Errorf("still in %d chats", len(chats))
+	}
+
+	for _, chat := range []models.Chat{e.direct, e.group} {
+		stored, err := e.chats.Get(ctx, chat.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.HasParticipant(e.bob.UUID) || !stored.HasParticipant(e.ann.UUID) {
+			t.Errorf("%s participants = %v", chat.Type, stored.Participants)
+		}
+
+		// История остаётся собеседникам, но без автора
+		history, _ := e.messages.ListByChat(ctx, chat.UUID)
+		if len(history) != 2 {
+			t.Fatalf("%s history = %d messages, want 2", chat.Type, len(history))
+		}
+		for _, m := range history {
+			if m.SenderUUID == e.bob.UUID {
+				t.Errorf("message %q still from the deleted user", m.Content)
+			}
+			if m.UUID == fromBob.UUID && m.SenderUUID != models.DeletedUserUUID {
+				t.Errorf("message sender = %s, want DeletedUserUUID", m.SenderUUID)
+			}
+		}
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+
+	e.send(t, e.direct, e.ann, "hi Bob")
+	e.send(t, e.direct, e.bob, "hi Ann")
+	e.send(t, e.group, e.ann, "hi all")
+	e.send(t, e.group, e.bob, "from Bob")
+	e.send(t, e.group, e.eve, "from Eve")
+	if err := e.blocks.Block(ctx, e.ann.UUID, e.bob.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := e.s.writeExport(ctx, e.ann.UUID, &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string, v any) {
+		t.Helper()
+		data, ok := files[name]
+		if !ok {
+			t.Fatalf("%s missing, archive has %d files", name, len(files))
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	var profile struct {
+		Email string `json:"email"`
+	}
+	read("profile.json", &profile)
+	if profile.Email != e.ann.Email {
+		t.Errorf("profile = %+v", profile)
+	}
+	if bytes.Contains(files["profile.json"], []byte("password")) {
+		t.Error("profile.json leaks the password hash")
+	}
+
+	var contacts map[string]json.RawMessage
+	read("contacts.json", &contacts)
+	for _, key := range []string{"contacts", "incoming_requests", "outgoing_requests"} {
+		if _, ok := contacts[key]; !ok {
+			t.Errorf("contacts.json has no %s", key)
+		}
+	}
+
+	var blocked []models.Block
+	read("blocks.json", &blocked)
+	if len(blocked) != 1 || blocked[0].BlockedUUID != e.bob.UUID {
+		t.Errorf("blocks.json = %+v", blocked)
+	}
+
+	var chats []models.Chat
+	read("chats.json", &chats)
+	if len(chats) != 2 {
+		t.Fatalf("chats.json has %d chats, want 2", len(chats))
+	}
+
+	contents := func(chat models.Chat) []string {
+		t.Helper()
+		var export struct {
+			Chat     models.Chat      `json:"chat"`
+			Messages []models.Message `json:"messages"`
+		}
+		read("chats/"+chat.UUID.String()+".json", &export)
+		var got []string
+		for _, m := range export.Messages {
+			got = append(got, m.Content)
+		}
+		return got
+	}
+	// В группе сообщений заблокированного нет, как и в приложении; личный чат целиком
+	if got := contents(e.direct); len(got) != 2 {
+		t.Errorf("direct chat = %q", got)
+	}
+	if got := contents(e.group); len(got) != 2 || got[0] != "hi all" || got[1] != "from Eve" {
+		t.Errorf("group chat = %q", got)
+	}
+}
@@ -0,0 +1,398 @@
+package account
+
+import (
+	"archive/zip"
+	"chat-app/internal/auth"
+	"chat-app/internal/avatar"
+	"chat-app/internal/mail"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Состояния выгрузки
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+)
+
+// EventExportReady is pushed to the notification socket when the archive is built
+const EventExportReady = "export_ready"
+
+// staleExport: незаконченная выгрузка старше этого считается упавшей вместе с инстансом
+const staleExport = time.Hour
+
+// ErrExportRunning is returned while the user's previous export is still being built
+var ErrExportRunning = errors.New("export already running")
+
+// Export describes the user's latest data export
+type Export struct {
+	ID        string     `json:"export_id"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	URL       string     `json:"url,omitempty"` // подписанная ссылка, только у готовой выгрузки
+}
+
+// Файлы выгрузок: exports/<user>/<id>.zip.part, пока пишется, и <id>.zip, когда готов.
+// Состояние берём прямо с диска, поэтому оно общее у инстансов с общим хранилищем.
+const (
+	readySuffix   = ".zip"
+	pendingSuffix = ".zip.part"
+)
+
+func (s *Service) exportDir(userUUID uuid.UUID) string {
+	return filepath.Join(s.opts.StorageDir, "exports", userUUID.String())
+}
+
+func exportResource(exportID string) string {
+	return "export:" + exportID
+}
+
+// LatestExport returns the user's newest export that is running or not yet expired, nil if none
+func (s *Service) LatestExport(userUUID uuid.UUID) (*Export, error) {
+	entries, err := os.ReadDir(s.exportDir(userUUID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var latest *Export
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		modified := info.ModTime()
+
+		var ex Export
+		switch name := e.Name(); {
+		case strings.HasSuffix(name, pendingSuffix):
+			if now.Sub(modified) > staleExport {
+				continue
+			}
+			ex = Export{ID: strings.TrimSuffix(name, pendingSuffix), Status: ExportPending, CreatedAt: modified}
+		case strings.HasSuffix(name, readySuffix):
+			expires := modified.Add(s.opts.ExportTTL)
+			if now.After(expires) {
+				continue
+			}
+			ex = Export{ID: strings.TrimSuffix(name, readySuffix), Status: ExportReady, CreatedAt: modified, ExpiresAt: &expires}
+		default:
+			continue
+		}
+
+		if latest == nil || ex.CreatedAt.After(latest.CreatedAt) {
+			latest = &ex
+		}
+	}
+
+	if latest != nil && latest.Status == ExportReady {
+		if latest.URL, err = s.link(userUUID, latest.ID, *latest.ExpiresAt); err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// StartExport begins building a new archive in the background
+func (s *Service) StartExport(ctx context.Context, userUUID uuid.UUID) (*Export, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return nil, errors.New("account service is stopped")
+	}
+
+	latest, err := s.LatestExport(userUUID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.Status == ExportPending {
+		return nil, ErrExportRunning
+	}
+
+	dir := s.exportDir(userUUID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewString()
+	f, err := os.OpenFile(filepath.Join(dir, id+pendingSuffix), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.runExport(userUUID, id, f)
+	}()
+
+	log.Printf("Пользователь %s запросил выгрузку данных %s", userUUID, id)
+	return &Export{ID: id, Status: ExportPending, CreatedAt: time.Now()}, nil
+}
+
+func (s *Service) runExport(userUUID uuid.UUID, exportID string, f *os.File) {
+	part := f.Name()
+
+	err := s.writeExport(s.ctx, userUUID, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(part, strings.TrimSuffix(part, pendingSuffix)+readySuffix)
+	}
+	if err != nil {
+		os.Remove(part)
+		log.Printf("Выгрузка %s пользователя %s не удалась: %v", exportID, userUUID, err)
+		return
+	}
+
+	// Хранится только последняя выгрузка
+	entries, _ := os.ReadDir(s.exportDir(userUUID))
+	for _, e := range entries {
+		if name := e.Name(); strings.HasSuffix(name, readySuffix) && name != exportID+readySuffix {
+			os.Remove(filepath.Join(s.exportDir(userUUID), name))
+		}
+	}
+
+	s.exportReady(userUUID, exportID)
+}
+
+// exportReady sends the signed link to the notification socket and by email
+func (s *Service) exportReady(userUUID uuid.UUID, exportID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	expires := time.Now().Add(s.opts.ExportTTL)
+	link, err := s.link(userUUID, exportID, expires)
+	if err != nil {
+		log.Printf("Не удалось подписать ссылку на выгрузку %s: %v", exportID, err)
+		return
+	}
+
+	if s.deps.Notifier != nil {
+		data := map[string]any{"export_id": exportID, "url": link, "expires_at": expires}
+		if err := s.deps.Notifier.Notify(userUUID, EventExportReady, data); err != nil {
+			log.Printf("Не удалось уведомить %s о выгрузке: %v", userUUID, err)
+		}
+	}
+
+	if s.deps.Mail == nil {
+		return
+	}
+	user, err := s.deps.Users.GetByUUID(ctx, userUUID)
+	if err != nil {
+		log.Printf("Не удалось найти адрес для письма о выгрузке %s: %v", exportID, err)
+		return
+	}
+	err = s.deps.Mail.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Выгрузка ваших данных готова",
+		Body: fmt.Sprintf("Архив с вашими данными можно скачать по ссылке:\n\n%s\n\n"+
+			"Ссылка действует до %s. Никому её не пересылайте: по ней архив отдаётся без входа в аккаунт.\n",
+			link, expires.Format("02.01.2006 15:04 MST")),
+	})
+	if err != nil {
+		log.Printf("Не удалось отправить письмо о выгрузке %s: %v", exportID, err)
+	}
+}
+
+// link signs a download URL valid until expires
+func (s *Service) link(userUUID uuid.UUID, exportID string, expires time.Time) (string, error) {
+	token, err := s.deps.Tokens.IssueDownload(userUUID, exportResource(exportID), time.Until(expires))
+	if err != nil {
+		return "", err
+	}
+	return s.opts.PublicURL + "/api/v1/account/exports/" + exportID + "?token=" + url.QueryEscape(token), nil
+}
+
+// ExportFile checks a signed link and returns the archive it points to.
+// auth.ErrInvalidToken — ссылка подделана или от другой выгрузки; repository.ErrNotFound — архив удалён или истёк.
+func (s *Service) ExportFile(exportID, token string) (string, error) {
+	claims, err := s.deps.Tokens.VerifyDownload(token)
+	if err != nil {
+		return "", err
+	}
+	if _, err := uuid.Parse(exportID); err != nil || claims.Resource != exportResource(exportID) {
+		return "", auth.ErrInvalidToken
+	}
+
+	path := filepath.Join(s.exportDir(claims.UserUUID), exportID+readySuffix)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > s.opts.ExportTTL {
+		return "", repository.ErrNotFound
+	}
+	return path, nil
+}
+
+// cleanupExports removes expired archives and parts left by crashed instances
+func (s *Service) cleanupExports() {
+	root := filepath.Join(s.opts.StorageDir, "exports")
+	users, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, u := range users {
+		dir := filepath.Join(root, u.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		left := len(entries)
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			age := now.Sub(info.ModTime())
+			name := e.Name()
+			if (strings.HasSuffix(name, pendingSuffix) && age > staleExport) ||
+				(strings.HasSuffix(name, readySuffix) && age > s.opts.ExportTTL) {
+				if os.Remove(filepath.Join(dir, name)) == nil {
+					left--
+				}
+			}
+		}
+		if left == 0 {
+			os.Remove(dir)
+		}
+	}
+}
+
+// writeExport writes the archive: профиль, контакты, блокировки, список чатов
+// и по файлу на чат со всей историей, которую пользователь видит в приложении.
+// Как и в GetChatMessages, в группах нет сообщений тех, кого он заблокировал.
+func (s *Service) writeExport(ctx context.Context, userUUID uuid.UUID, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	user, err := s.deps.Users.GetByUUID(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	profile := struct {
+		*models.User
+		TwoFactorEnabled bool `json:"two_factor_enabled"`
+	}{user, user.TOTPEnabled}
+	if err := writeJSON(zw, "profile.json", profile); err != nil {
+		return err
+	}
+
+	contacts, err := s.deps.Contacts.ListContacts(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("contacts: %w", err)
+	}
+	incoming, err := s.deps.Contacts.ListIncoming(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("contact requests: %w", err)
+	}
+	outgoing, err := s.deps.Contacts.ListOutgoing(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("contact requests: %w", err)
+	}
+	err = writeJSON(zw, "contacts.json", map[string]any{
+		"contacts":          contacts,
+		"incoming_requests": incoming,
+		"outgoing_requests": outgoing,
+	})
+	if err != nil {
+		return err
+	}
+
+	blocked, err := s.deps.Blocks.ListBlocked(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("blocks: %w", err)
+	}
+	if err := writeJSON(zw, "blocks.json", blocked); err != nil {
+		return err
+	}
+	hidden := make(map[uuid.UUID]bool, len(blocked))
+	for _, b := range blocked {
+		hidden[b.BlockedUUID] = true
+	}
+
+	chats, err := s.deps.Chats.ListForUser(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("chats: %w", err)
+	}
+	if err := writeJSON(zw, "chats.json", chats); err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		messages, err := s.deps.Messages.ListByChat(ctx, chat.UUID)
+		if err != nil {
+			return fmt.Errorf("chat %s: %w", chat.UUID, err)
+		}
+		if chat.Type == models.ChatGroup {
+			messages = slices.DeleteFunc(messages, func(m models.Message) bool { return hidden[m.SenderUUID] })
+		}
+		if s.deps.Names != nil {
+			s.deps.Names.Enrich(ctx, messages)
+		}
+		err = writeJSON(zw, "chats/"+chat.UUID.String()+".json", map[string]any{
+			"chat":     chat,
+			"messages": messages,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if user.AvatarUpdatedAt != nil {
+		if err := copyFile(zw, "avatar.jpg", avatar.Path(s.opts.StorageDir, userUUID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("avatar: %w", err)
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func copyFile(zw *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	return err
+}
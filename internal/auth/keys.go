@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type verificationKey struct {
+	kid    string
+	alg    string
+	public crypto.PublicKey
+}
+
+// KeySet holds the active signing key and every key still accepted for verification.
+// При ротации новый ключ становится подписывающим, а старый остаётся в списке
+// проверочных, пока не истекут выданные им токены.
+type KeySet struct {
+	signingKID string
+	signingAlg string
+	private    crypto.Signer
+	keys       map[string]verificationKey
+}
+
+// LoadKeySet reads a PEM private signing key and optional extra public keys.
+// publicKeyFiles entries are "kid=path" or just "path" (kid is then the RFC 7638 thumbprint).
+func LoadKeySet(privateKeyFile, keyID string, publicKeyFiles []string) (*KeySet, error) {
+	data, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+
+	private, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key %s: %w", privateKeyFile, err)
+	}
+
+	ks, err := NewKeySet(private, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range publicKeyFiles {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, path := "", entry
+		if i := strings.Index(entry, "="); i > 0 {
+			kid, path = entry[:i], entry[i+1:]
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read verification key: %w", err)
+		}
+
+		public, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse verification key %s: %w", path, err)
+		}
+
+		if err := ks.AddVerificationKey(kid, public); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
+
+// GenerateKeySet creates an ephemeral Ed25519 key, only meant for development
+func GenerateKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(private, "")
+}
+
+// NewKeySet builds a key set around an RSA or Ed25519 private key
+func NewKeySet(private crypto.Signer, keyID string) (*KeySet, error) {
+	alg, err := algorithmFor(private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	if keyID == "" {
+		keyID, err = thumbprint(private.Public())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ks := &KeySet{
+		signingKID: keyID,
+		signingAlg: alg,
+		private:    private,
+		keys:       make(map[string]verificationKey),
+	}
+	ks.keys[keyID] = verificationKey{kid: keyID, alg: alg, public: private.Public()}
+
+	return ks, nil
+}
+
+// AddVerificationKey registers a public key that is accepted but never used for signing
+func (k *KeySet) AddVerificationKey(kid string, public crypto.PublicKey) error {
+	alg, err := algorithmFor(public)
+	if err != nil {
+		return err
+	}
+
+	if kid == "" {
+		if kid, err = thumbprint(public); err != nil {
+			return err
+		}
+	}
+
+	if _, exists := k.keys[kid]; exists {
+		return fmt.Errorf("duplicate key id %q", kid)
+	}
+
+	k.keys[kid] = verificationKey{kid: kid, alg: alg, public: public}
+	return nil
+}
+
+// SigningKeyID returns the kid of the key new tokens are signed with
+func (k *KeySet) SigningKeyID() string {
+	return k.signingKID
+}
+
+// Sign signs claims with the active key and sets the kid header
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.signingAlg), claims)
+	token.Header["kid"] = k.signingKID
+	return token.SignedString(k.private)
+}
+
+// PublicKey looks up a verification key by kid and checks it matches alg
+func (k *KeySet) PublicKey(kid, alg string) (crypto.PublicKey, error) {
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if key.alg != alg {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", alg, kid)
+	}
+	return key.public, nil
+}
+
+// Keyfunc resolves the verification key for jwt.Parse by the token's kid header
+func (k *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownKey
+	}
+	return k.PublicKey(kid, t.Method.Alg())
+}
+
+// Algorithms lists the algorithms tokens may be signed with
+func (k *KeySet) Algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range k.keys {
+		if !seen[key.alg] {
+			seen[key.alg] = true
+			algs = append(algs, key.alg)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// JWK is a single public key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS returns every verification key as a JSON Web Key Set
+func (k *KeySet) JWKS() map[string][]JWK {
+	kids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		key := k.keys[kid]
+		jwk := toJWK(key.public)
+		jwk.Kid = kid
+		jwk.Use = "sig"
+		jwk.Alg = key.alg
+		keys = append(keys, jwk)
+	}
+
+	return map[string][]JWK{"keys": keys}
+}
+
+func toJWK(public crypto.PublicKey) JWK {
+	enc := base64.RawURLEncoding
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		e := exponentBytes(pub.E)
+		return JWK{Kty: "RSA", N: enc.EncodeToString(pub.N.Bytes()), E: enc.EncodeToString(e)}
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: enc.EncodeToString(pub)}
+	}
+	return JWK{}
+}
+
+// exponentBytes encodes the RSA exponent as a minimal big-endian byte slice
+func exponentBytes(e int) []byte {
+	var out []byte
+	for e > 0 {
+		out = append([]byte{byte(e & 0xff)}, out...)
+		e >>= 8
+	}
+	return out
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint used as the default kid
+func thumbprint(public crypto.PublicKey) (string, error) {
+	jwk := toJWK(public)
+
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", errors.New("unsupported key type")
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func algorithmFor(public crypto.PublicKey) (string, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return "", errors.New("RSA key must be at least 2048 bits")
+		}
+		return AlgRS256, nil
+	case ed25519.PublicKey:
+		return AlgEdDSA, nil
+	}
+	return "", errors.New("unsupported key type, use RSA or Ed25519")
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("unsupported private key format, expected PKCS#8 or PKCS#1")
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	// Допускаем и приватный ключ, берём из него публичную часть
+	if private, err := parsePrivateKey(data); err == nil {
+		return private.Public(), nil
+	}
+
+	return nil, errors.New("unsupported public key format")
+}
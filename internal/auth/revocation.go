@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRevokedToken means the token was issued before its sessions were revoked
+var ErrRevokedToken = errors.New("token revoked")
+
+// Revocations remembers when all sessions of a user were revoked.
+// Токены без состояния, поэтому храним только момент отзыва: всё, что выдано
+// раньше, недействительно. Продлевать токен можно только живым токеном, так
+// что отметка нужна лишь на время жизни access-токена.
+type Revocations struct {
+	redis *redis.Client
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewRevocations creates the store; accessTTL is the lifetime of access tokens
+func NewRevocations(client *redis.Client, accessTTL time.Duration) *Revocations {
+	return &Revocations{redis: client, ttl: accessTTL + time.Minute, now: time.Now}
+}
+
+func revokedKey(userUUID uuid.UUID) string {
+	return "auth:revoked:" + userUUID.String()
+}
+
+// RevokeAll invalidates every token of the user issued up to now
+func (r *Revocations) RevokeAll(ctx context.Context, userUUID uuid.UUID) error {
+	return r.redis.Set(ctx, revokedKey(userUUID), r.now().UnixNano(), r.ttl).Err()
+}
+
+// Check returns ErrRevokedToken if the token's sessions were revoked after it was issued
+func (r *Revocations) Check(ctx context.Context, claims *Claims) error {
+	value, err := r.redis.Get(ctx, revokedKey(claims.UserUUID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	issued, exact := claims.issuedAt()
+	if issued.IsZero() {
+		return ErrRevokedToken
+	}
+
+	// Точность до секунды: выданный в ту же секунду тоже отзываем
+	if !exact {
+		if issued.Unix() <= revokedAt/int64(time.Second) {
+			return ErrRevokedToken
+		}
+		return nil
+	}
+
+	// Токен, выданный сразу после отзыва (например, при повторном входе), действует
+	if issued.UnixNano() < revokedAt {
+		return ErrRevokedToken
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRevocations(t *testing.T) *Revocations {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRevocations(client, 15*time.Minute)
+}
+
+// issuedAt issues an access token on a service whose clock shows at
+func issuedAt(t *testing.T, s *TokenService, userUUID uuid.UUID, at time.Time) *Claims {
+	t.Helper()
+	s.now = func() time.Time { return at }
+	token, err := s.IssueAccess(userUUID, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Проверяем на честных часах: iat «из будущего» иначе отвергнет сам verify
+	s.now = time.Now
+	claims, err := s.VerifyAccess(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestRevocationSameSecond(t *testing.T) {
+	r := newTestRevocations(t)
+	s := newTestService(t)
+	userUUID := uuid.New()
+	ctx := context.Background()
+
+	// Середина секунды, чтобы токены до и после отзыва попали в ту же секунду
+	revokedAt := time.Unix(time.Now().Unix()-1, int64(500*time.Millisecond))
+	r.now = func() time.Time { return revokedAt }
+	if err := r.RevokeAll(ctx, userUUID); err != nil {
+		t.Fatal(err)
+	}
+
+	before := issuedAt(t, s, userUUID, revokedAt.Add(-100*time.Millisecond))
+	after := issuedAt(t, s, userUUID, revokedAt.Add(100*time.Millisecond))
+	if before.IssuedAt.Unix() != after.IssuedAt.Unix() {
+		t.Fatal("tokens landed in different seconds")
+	}
+
+	if err := r.Check(ctx, before); !errors.Is(err, ErrRevokedToken) {
+		t.Errorf("token issued before revocation: %v, want ErrRevokedToken", err)
+	}
+	if err := r.Check(ctx, after); err != nil {
+		t.Errorf("token issued after revocation in the same second: %v", err)
+	}
+
+	// Токены без iat_ns сравниваются по секундам и в ту же секунду отзываются
+	coarse := *after
+	coarse.IssuedNs = 0
+	if err := r.Check(ctx, &coarse); !errors.Is(err, ErrRevokedToken) {
+		t.Errorf("second-precision token from the revocation second: %v, want ErrRevokedToken", err)
+	}
+	coarse.IssuedAt = jwt.NewNumericDate(revokedAt.Add(time.Second))
+	if err := r.Check(ctx, &coarse); err != nil {
+		t.Errorf("second-precision token from the next second: %v", err)
+	}
+
+	if err := r.Check(ctx, issuedAt(t, s, uuid.New(), revokedAt.Add(-time.Minute))); err != nil {
+		t.Errorf("other user's token: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	PurposeAccess    = "access"
+	PurposeChallenge = "2fa_challenge"
+	PurposeDownload  = "download"
+	PurposeReauth    = "reauth"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Claims are the typed contents of every token we issue
+type Claims struct {
+	UserUUID  uuid.UUID `json:"user_uuid"`
+	Email     string    `json:"email,omitempty"`
+	SessionID string    `json:"sid,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	Resource  string    `json:"res,omitempty"` // что разрешает скачать токен для ссылок
+	Purpose   string    `json:"purpose"`
+	IssuedNs  int64     `json:"iat_ns,omitempty"` // iat с наносекундами, для сравнения с моментом отзыва
+	jwt.RegisteredClaims
+}
+
+// issuedAt returns the issue time with sub-second precision when the token carries it
+func (c *Claims) issuedAt() (time.Time, bool) {
+	if c.IssuedNs != 0 {
+		return time.Unix(0, c.IssuedNs), true
+	}
+	if c.IssuedAt == nil {
+		return time.Time{}, false
+	}
+	return c.IssuedAt.Time, false
+}
+
+// HasScope reports whether the token grants scope
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// TokenService issues and verifies tokens; it is the only place that knows about JWT
+type TokenService struct {
+	keys         *KeySet
+	accessTTL    time.Duration
+	challengeTTL time.Duration
+	now          func() time.Time
+}
+
+// NewTokenService creates a service signing with keys
+func NewTokenService(keys *KeySet, accessTTL time.Duration) *TokenService {
+	return &TokenService{
+		keys:         keys,
+		accessTTL:    accessTTL,
+		challengeTTL: 5 * time.Minute,
+		now:          time.Now,
+	}
+}
+
+// AccessTTL returns the lifetime of access tokens
+func (s *TokenService) AccessTTL() time.Duration {
+	return s.accessTTL
+}
+
+// ChallengeTTL returns the lifetime of 2FA challenge tokens
+func (s *TokenService) ChallengeTTL() time.Duration {
+	return s.challengeTTL
+}
+
+// IssueAccess signs an access token. Пустой sessionID означает новую сессию.
+func (s *TokenService) IssueAccess(userUUID uuid.UUID, email, sessionID string, scopes []string) (string, error) {
+	if sessionID == "" {
+		sessionID = uuid.NewString()
+	}
+	return s.issue(Claims{
+		UserUUID:  userUUID,
+		Email:     email,
+		SessionID: sessionID,
+		Scopes:    scopes,
+		Purpose:   PurposeAccess,
+	}, s.accessTTL)
+}
+
+// IssueChallenge signs a short-lived token proving the first login factor passed
+func (s *TokenService) IssueChallenge(userUUID uuid.UUID) (string, error) {
+	return s.issue(Claims{
+		UserUUID: userUUID,
+		Purpose:  PurposeChallenge,
+	}, s.challengeTTL)
+}
+
+// IssueReauth signs a short-lived proof that the user has just logged in again,
+// например через SSO: им аккаунт без пароля подтверждает опасные действия
+func (s *TokenService) IssueReauth(userUUID uuid.UUID) (string, error) {
+	return s.issue(Claims{
+		UserUUID: userUUID,
+		Purpose:  PurposeReauth,
+	}, s.challengeTTL)
+}
+
+// IssueDownload signs a link token that lets anyone holding it fetch one
+// resource of the user, e.g. a data export, without logging in
+func (s *TokenService) IssueDownload(userUUID uuid.UUID, resource string, ttl time.Duration) (string, error) {
+	return s.issue(Claims{
+		UserUUID: userUUID,
+		Resource: resource,
+		Purpose:  PurposeDownload,
+	}, ttl)
+}
+
+// Refresh issues a new access token for the same session
+func (s *TokenService) Refresh(claims *Claims) (string, error) {
+	return s.IssueAccess(claims.UserUUID, claims.Email, claims.SessionID, claims.Scopes)
+}
+
+// VerifyAccess validates a token used to call the API or open a WebSocket
+func (s *TokenService) VerifyAccess(tokenString string) (*Claims, error) {
+	return s.verify(tokenString, PurposeAccess)
+}
+
+// VerifyDownload validates a link token made by IssueDownload
+func (s *TokenService) VerifyDownload(tokenString string) (*Claims, error) {
+	return s.verify(tokenString, PurposeDownload)
+}
+
+// VerifyReauth validates a token made by IssueReauth
+func (s *TokenService) VerifyReauth(tokenString string) (*Claims, error) {
+	return s.verify(tokenString, PurposeReauth)
+}
+
+// VerifyChallenge validates a 2FA challenge token
+func (s *TokenService) VerifyChallenge(tokenString string) (*Claims, error) {
+	return s.verify(tokenString, PurposeChallenge)
+}
+
+func (s *TokenService) issue(claims Claims, ttl time.Duration) (string, error) {
+	now := s.now()
+	claims.ID = uuid.NewString()
+	claims.Subject = claims.UserUUID.String()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.IssuedNs = now.UnixNano()
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return s.keys.Sign(claims)
+}
+
+func (s *TokenService) verify(tokenString, purpose string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, s.keys.Keyfunc,
+		jwt.WithValidMethods(s.keys.Algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(s.now),
+	)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrExpiredToken
+	}
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Токен одного назначения нельзя использовать вместо другого
+	if claims.Purpose != purpose || claims.UserUUID == uuid.Nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer ..." header
+func BearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestService(t *testing.T) *TokenService {
+	t.Helper()
+	keys, err := GenerateKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTokenService(keys, 15*time.Minute)
+}
+
+// tamperPayload rewrites one claim and keeps the original signature
+func tamperPayload(t *testing.T, token, claim string, value any) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	claims[claim] = value
+	payload, _ = json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestService(t)
+	userUUID := uuid.New()
+
+	access, err := s.IssueAccess(userUUID, "ann@example.com", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, _ := s.IssueChallenge(userUUID)
+	download, _ := s.IssueDownload(userUUID, "export:1", time.Hour)
+	reauth, _ := s.IssueReauth(userUUID)
+
+	// Истёкший: выдан сервисом, у которого часы на два срока жизни назад
+	past := newTestService(t)
+	past.keys = s.keys
+	past.now = func() time.Time { return time.Now().Add(-2 * s.AccessTTL()) }
+	expired, _ := past.IssueAccess(userUUID, "ann@example.com", "", nil)
+
+	// Из будущего: iat позже текущего времени
+	future := newTestService(t)
+	future.keys = s.keys
+	future.now = func() time.Time { return time.Now().Add(time.Hour) }
+	fromFuture, _ := future.IssueAccess(userUUID, "ann@example.com", "", nil)
+
+	// Тот же kid, но HS256 с публичным ключом в роли секрета — классическая подмена алгоритма
+	claims := Claims{UserUUID: userUUID, Purpose: PurposeAccess, RegisteredClaims: jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = s.keys.SigningKeyID()
+	public := s.keys.private.Public().(ed25519.PublicKey)
+	hsToken, err := hs.SignedString([]byte(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = s.keys.SigningKeyID()
+	noneToken, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Чужой ключ с неизвестным нам kid
+	other := newTestService(t)
+	unknownKid, _ := other.IssueAccess(userUUID, "ann@example.com", "", nil)
+
+	noKid := jwt.NewWithClaims(jwt.GetSigningMethod(s.keys.signingAlg), claims)
+	noKidToken, err := noKid.SignedString(s.keys.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := access[strings.LastIndex(access, ".")+1:]
+	flipped := "A"
+	if sig[0] == 'A' {
+		flipped = "B"
+	}
+	badSignature := access[:strings.LastIndex(access, ".")+1] + flipped + sig[1:]
+
+	tests := []struct {
+		name    string
+		token   string
+		verify  func(string) (*Claims, error)
+		wantErr error
+	}{
+		{"valid access", access, s.VerifyAccess, nil},
+		{"valid challenge", challenge, s.VerifyChallenge, nil},
+		{"valid download", download, s.VerifyDownload, nil},
+		{"valid reauth", reauth, s.VerifyReauth, nil},
+		{"expired", expired, s.VerifyAccess, ErrExpiredToken},
+		{"issued in the future", fromFuture, s.VerifyAccess, ErrInvalidToken},
+		{"wrong alg HS256", hsToken, s.VerifyAccess, ErrInvalidToken},
+		{"alg none", noneToken, s.VerifyAccess, ErrInvalidToken},
+		{"tampered user", tamperPayload(t, access, "user_uuid", uuid.NewString()), s.VerifyAccess, ErrInvalidToken},
+		{"tampered purpose", tamperPayload(t, challenge, "purpose", PurposeAccess), s.VerifyAccess, ErrInvalidToken},
+		{"tampered expiry", tamperPayload(t, expired, "exp", time.Now().Add(time.Hour).Unix()), s.VerifyAccess, ErrInvalidToken},
+		{"bad signature", badSignature, s.VerifyAccess, ErrInvalidToken},
+		{"challenge used as access", challenge, s.VerifyAccess, ErrInvalidToken},
+		{"access used as challenge", access, s.VerifyChallenge, ErrInvalidToken},
+		{"download used as access", download, s.VerifyAccess, ErrInvalidToken},
+		{"access used as download", access, s.VerifyDownload, ErrInvalidToken},
+		{"access used as reauth", access, s.VerifyReauth, ErrInvalidToken},
+		{"challenge used as reauth", challenge, s.VerifyReauth, ErrInvalidToken},
+		{"reauth used as access", reauth, s.VerifyAccess, ErrInvalidToken},
+		{"unknown kid", unknownKid, s.VerifyAccess, ErrInvalidToken},
+		{"missing kid", noKidToken, s.VerifyAccess, ErrInvalidToken},
+		{"garbage", "not.a.token", s.VerifyAccess, ErrInvalidToken},
+		{"empty", "", s.VerifyAccess, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got.UserUUID != userUUID {
+				t.Errorf("user = %s, want %s", got.UserUUID, userUUID)
+			}
+		})
+	}
+}
+
+func TestRefreshKeepsSession(t *testing.T) {
+	s := newTestService(t)
+	token, _ := s.IssueAccess(uuid.New(), "ann@example.com", "", []string{"chat"})
+	claims, err := s.VerifyAccess(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := s.Refresh(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.VerifyAccess(refreshed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.SessionID != claims.SessionID || !again.HasScope("chat") || again.ID == claims.ID {
+		t.Errorf("refresh changed the session or reused jti: %+v", again)
+	}
+}
+
+func TestRotatedKeyStillVerifies(t *testing.T) {
+	old := newTestService(t)
+	token, _ := old.IssueAccess(uuid.New(), "", "", nil)
+
+	current := newTestService(t)
+	if err := current.keys.AddVerificationKey(old.keys.SigningKeyID(), old.keys.private.Public()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := current.VerifyAccess(token); err != nil {
+		t.Errorf("token signed with the previous key rejected: %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer  abc ", "abc", true},
+		{"Basic abc", "", false},
+		{"Bearer", "", false},
+		{"Bearer   ", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		token, ok := BearerToken(tt.header)
+		if token != tt.token || ok != tt.ok {
+			t.Errorf("BearerToken(%q) = %q, %v; want %q, %v", tt.header, token, ok, tt.token, tt.ok)
+		}
+	}
+}
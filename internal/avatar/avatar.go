@@ -0,0 +1,73 @@
+// Package avatar turns an uploaded picture into a square JPEG thumbnail.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"path/filepath"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Size is the side of the stored avatar in pixels
+const Size = 256
+
+// maxSourcePixels bounds the decoded image: маленький PNG может распаковаться
+// в гигабайты, поэтому размер проверяем по заголовку до декодирования
+const maxSourcePixels = 40_000_000
+
+var ErrUnsupported = errors.New("unsupported image, use JPEG, PNG, GIF or WebP")
+
+// Path is where the user's avatar is stored under the storage directory
+func Path(storageDir string, userUUID uuid.UUID) string {
+	return filepath.Join(storageDir, "avatars", userUUID.String()+".jpg")
+}
+
+// Process decodes r, crops the centre square and scales it to Size×Size.
+// Прозрачность заливаем белым: JPEG её не хранит.
+func Process(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, fmt.Errorf("image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		b.Min.X+(b.Dx()-side)/2,
+		b.Min.Y+(b.Dy()-side)/2,
+	))
+
+	dst := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,286 @@
+// Package blocks answers "did one of these users block the other" without a
+// query per message. Списки блокировок кэшируются в памяти и перечитываются на
+// всех инстансах через Redis, когда кто-то блокирует или разблокирует.
+package blocks
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// changedChannel carries "blocker:blocked" pairs whose relation changed
+const changedChannel = "users:blocks:changed"
+
+// DefaultTTL bounds staleness if a change notification was missed
+const DefaultTTL = 10 * time.Minute
+
+// refreshTimeout bounds a background reload of one user's lists
+const refreshTimeout = 5 * time.Second
+
+type entry struct {
+	blocked  map[uuid.UUID]struct{} // кого заблокировал пользователь
+	blockers map[uuid.UUID]struct{} // кто заблокировал пользователя
+	loaded   time.Time
+}
+
+// Service caches block lists per user.
+// Записи старше ttl вычищаются при очередной загрузке, см. sweepLocked.
+type Service struct {
+	repo   repository.BlockRepository
+	redis  *redis.Client // nil — один инстанс
+	ttl    time.Duration
+	mu     sync.RWMutex
+	cache  map[uuid.UUID]*entry
+	swept  time.Time // последняя чистка просроченных записей
+	pubsub *redis.PubSub
+}
+
+// New creates the service; client may be nil for single-instance setups and tools
+func New(repo repository.BlockRepository, client *redis.Client) *Service {
+	return &Service{
+		repo:  repo,
+		redis: client,
+		ttl:   DefaultTTL,
+		cache: make(map[uuid.UUID]*entry),
+	}
+}
+
+// Start subscribes to block changes made on other instances
+func (s *Service) Start(ctx context.Context) error {
+	if s.redis == nil {
+		return nil
+	}
+
+	s.pubsub = s.redis.Subscribe(ctx, changedChannel)
+	if _, err := s.pubsub.Receive(ctx); err != nil {
+		s.pubsub.Close()
+		return err
+	}
+
+	go s.listen()
+	return nil
+}
+
+func (s *Service) listen() {
+	for msg := range s.pubsub.ChannelWithSubscriptions() {
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// После обрыва не знаем, что пропустили: перечитываем всех, кто в кэше
+			if m.Kind == "subscribe" {
+				go s.refreshAll()
+			}
+		case *redis.Message:
+			blocker, blocked, ok := strings.Cut(m.Payload, ":")
+			a, errA := uuid.Parse(blocker)
+			b, errB := uuid.Parse(blocked)
+			if !ok || errA != nil || errB != nil {
+				log.Printf("Некорректное уведомление о блокировке: %q", m.Payload)
+				continue
+			}
+			s.refreshCached(a)
+			s.refreshCached(b)
+		}
+	}
+}
+
+// Close stops listening for changes
+func (s *Service) Close() error {
+	if s.pubsub == nil {
+		return nil
+	}
+	return s.pubsub.Close()
+}
+
+// load returns the user's entry, reading it from the database when missing or expired
+func (s *Service) load(ctx context.Context, userUUID uuid.UUID) (*entry, error) {
+	s.mu.RLock()
+	e, ok := s.cache[userUUID]
+	s.mu.RUnlock()
+	if ok && time.Since(e.loaded) < s.ttl {
+		return e, nil
+	}
+	return s.reload(ctx, userUUID)
+}
+
+func (s *Service) reload(ctx context.Context, userUUID uuid.UUID) (*entry, error) {
+	blocked, err := s.repo.ListBlocked(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	blockers, err := s.repo.ListBlockers(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{
+		blocked:  make(map[uuid.UUID]struct{}, len(blocked)),
+		blockers: make(map[uuid.UUID]struct{}, len(blockers)),
+		loaded:   time.Now(),
+	}
+	for _, b := range blocked {
+		e.blocked[b.BlockedUUID] = struct{}{}
+	}
+	for _, id := range blockers {
+		e.blockers[id] = struct{}{}
+	}
+
+	s.mu.Lock()
+	s.cache[userUUID] = e
+	// Просроченные записи тех, кто больше не заходит, иначе копились бы вечно
+	if e.loaded.Sub(s.swept) >= s.ttl {
+		s.sweepLocked(e.loaded)
+	}
+	s.mu.Unlock()
+	return e, nil
+}
+
+// sweepLocked drops expired entries; s.mu must be held for writing
+func (s *Service) sweepLocked(now time.Time) {
+	for id, e := range s.cache {
+		if now.Sub(e.loaded) >= s.ttl {
+			delete(s.cache, id)
+		}
+	}
+	s.swept = now
+}
+
+// refreshCached reloads the user only if someone here already cares about them.
+// Старая запись остаётся, пока не прочитана новая: доставка не должна видеть пустоту.
+func (s *Service) refreshCached(userUUID uuid.UUID) {
+	s.mu.RLock()
+	_, ok := s.cache[userUUID]
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	if _, err := s.reload(ctx, userUUID); err != nil {
+		log.Printf("Не удалось перечитать блокировки %s: %v", userUUID, err)
+	}
+}
+
+func (s *Service) refreshAll() {
+	s.mu.RLock()
+	users := make([]uuid.UUID, 0, len(s.cache))
+	for id := range s.cache {
+		users = append(users, id)
+	}
+	s.mu.RUnlock()
+
+	for _, id := range users {
+		s.refreshCached(id)
+	}
+}
+
+// Warm loads the user's lists so Blocks can answer for them without the database
+func (s *Service) Warm(ctx context.Context, userUUID uuid.UUID) error {
+	_, err := s.load(ctx, userUUID)
+	return err
+}
+
+// Blocks reports whether blocker blocked blocked, using the cache.
+// Для горячего пути доставки: получателя прогревают при подключении сокета (Warm),
+// а запись, вычищенную за давностью, перечитываем один раз.
+func (s *Service) Blocks(blocker, blocked uuid.UUID) bool {
+	s.mu.RLock()
+	e, ok := s.cache[blocker]
+	s.mu.RUnlock()
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		var err error
+		if e, err = s.reload(ctx, blocker); err != nil {
+			log.Printf("Не удалось перечитать блокировки %s: %v", blocker, err)
+			return false
+		}
+	}
+	_, ok = e.blocked[blocked]
+	return ok
+}
+
+// Between reports whether either user blocked the other
+func (s *Service) Between(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	e, err := s.load(ctx, a)
+	if err != nil {
+		return false, err
+	}
+	_, blocked := e.blocked[b]
+	_, blocker := e.blockers[b]
+	return blocked || blocker, nil
+}
+
+// Blocked returns everyone the user blocked
+func (s *Service) Blocked(ctx context.Context, userUUID uuid.UUID) (map[uuid.UUID]struct{}, error) {
+	e, err := s.load(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	return e.blocked, nil
+}
+
+// Related returns everyone the user blocked or was blocked by
+func (s *Service) Related(ctx context.Context, userUUID uuid.UUID) ([]uuid.UUID, error) {
+	e, err := s.load(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	related := make([]uuid.UUID, 0, len(e.blocked)+len(e.blockers))
+	for id := range e.blocked {
+		related = append(related, id)
+	}
+	for id := range e.blockers {
+		if _, dup := e.blocked[id]; !dup {
+			related = append(related, id)
+		}
+	}
+	return related, nil
+}
+
+// List returns the user's block list, newest first
+func (s *Service) List(ctx context.Context, blockerUUID uuid.UUID) ([]models.Block, error) {
+	return s.repo.ListBlocked(ctx, blockerUUID)
+}
+
+// Block stores the block and tells every instance to reload both users
+func (s *Service) Block(ctx context.Context, blockerUUID, blockedUUID uuid.UUID) error {
+	if err := s.repo.Block(ctx, blockerUUID, blockedUUID); err != nil {
+		return err
+	}
+	s.changed(ctx, blockerUUID, blockedUUID)
+	return nil
+}
+
+// Unblock removes the block, repository.ErrNotFound if there was none
+func (s *Service) Unblock(ctx context.Context, blockerUUID, blockedUUID uuid.UUID) error {
+	if err := s.repo.Unblock(ctx, blockerUUID, blockedUUID); err != nil {
+		return err
+	}
+	s.changed(ctx, blockerUUID, blockedUUID)
+	return nil
+}
+
+func (s *Service) changed(ctx context.Context, blockerUUID, blockedUUID uuid.UUID) {
+	// Свой инстанс обновляем сразу, не дожидаясь эха из Redis
+	if _, err := s.reload(ctx, blockerUUID); err != nil {
+		log.Printf("Не удалось перечитать блокировки %s: %v", blockerUUID, err)
+	}
+	s.refreshCached(blockedUUID)
+
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Publish(ctx, changedChannel, blockerUUID.String()+":"+blockedUUID.String()).Err(); err != nil {
+		log.Printf("Не удалось разослать изменение блокировки: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package blocks
+
+import (
+	"chat-app/internal/repository"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestService(t *testing.T, ttl time.Duration) (*Service, *repository.MemoryBlocks) {
+	t.Helper()
+	repo := repository.NewMemoryBlocks()
+	s := New(repo, nil)
+	s.ttl = ttl
+	return s, repo
+}
+
+func cached(s *Service) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.cache)
+}
+
+func TestExpiredEntriesAreSwept(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	s, _ := newTestService(t, ttl)
+	ctx := context.Background()
+
+	// Сотня пользователей, которые больше не заходят
+	for range 100 {
+		if err := s.Warm(ctx, uuid.New()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := cached(s); got != 100 {
+		t.Fatalf("cached = %d, want 100", got)
+	}
+
+	time.Sleep(2 * ttl)
+	if err := s.Warm(ctx, uuid.New()); err != nil {
+		t.Fatal(err)
+	}
+	if got := cached(s); got != 1 {
+		t.Errorf("cached = %d after TTL, want only the fresh entry", got)
+	}
+}
+
+func TestLiveEntriesSurviveSweep(t *testing.T) {
+	s, _ := newTestService(t, time.Hour)
+	ctx := context.Background()
+
+	s.Warm(ctx, uuid.New())
+	s.Warm(ctx, uuid.New())
+	s.mu.Lock()
+	s.swept = time.Time{} // следующая загрузка сразу чистит
+	s.mu.Unlock()
+
+	s.Warm(ctx, uuid.New())
+	if got := cached(s); got != 3 {
+		t.Errorf("cached = %d, sweep dropped live entries", got)
+	}
+}
+
+func TestBlocksReloadsSweptEntry(t *testing.T) {
+	s, repo := newTestService(t, time.Hour)
+	ctx := context.Background()
+	ann, bob := uuid.New(), uuid.New()
+
+	if err := repo.Block(ctx, ann, bob); err != nil {
+		t.Fatal(err)
+	}
+	s.Warm(ctx, ann)
+	s.mu.Lock()
+	s.sweepLocked(time.Now().Add(2 * s.ttl))
+	s.mu.Unlock()
+	if got := cached(s); got != 0 {
+		t.Fatalf("cached = %d after sweep", got)
+	}
+
+	// Вычищенный получатель по-прежнему не видит заблокированного
+	if !s.Blocks(ann, bob) {
+		t.Error("block lost after the entry was swept")
+	}
+	if s.Blocks(bob, ann) {
+		t.Error("reverse block reported")
+	}
+}
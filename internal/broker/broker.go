@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// Handler receives raw payloads published to a chat
+type Handler func(payload []byte)
+
+// Broker moves chat events between hub instances.
+// Hub подписывается на чат, когда к нему подключается первый локальный клиент,
+// и отписывается, когда уходит последний.
+type Broker interface {
+	Publish(ctx context.Context, chatUUID string, payload []byte) error
+	Subscribe(ctx context.Context, chatUUID string, handler Handler) (unsubscribe func(), err error)
+	Close() error
+}
+
+// registry keeps local handlers per chat; shared by all implementations
+type registry struct {
+	mu       sync.RWMutex
+	nextID   uint64
+	handlers map[string]map[uint64]Handler
+}
+
+func newRegistry() *registry {
+	return &registry{handlers: make(map[string]map[uint64]Handler)}
+}
+
+// add registers a handler and reports whether it is the first one for the chat
+func (r *registry) add(chatUUID string, h Handler) (uint64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	hs, ok := r.handlers[chatUUID]
+	if !ok {
+		hs = make(map[uint64]Handler)
+		r.handlers[chatUUID] = hs
+	}
+	hs[r.nextID] = h
+	return r.nextID, !ok
+}
+
+// remove drops a handler and reports whether the chat has no handlers left
+func (r *registry) remove(chatUUID string, id uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hs, ok := r.handlers[chatUUID]
+	if !ok {
+		return false
+	}
+	delete(hs, id)
+	if len(hs) == 0 {
+		delete(r.handlers, chatUUID)
+		return true
+	}
+	return false
+}
+
+// dispatch hands the payload to the chat's handlers and reports whether there were any
+func (r *registry) dispatch(chatUUID string, payload []byte) bool {
+	r.mu.RLock()
+	hs := make([]Handler, 0, len(r.handlers[chatUUID]))
+	for _, h := range r.handlers[chatUUID] {
+		hs = append(hs, h)
+	}
+	r.mu.RUnlock()
+
+	for _, h := range hs {
+		h(payload)
+	}
+	return len(hs) > 0
+}
+
+func (r *registry) chats() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chats := make([]string, 0, len(r.handlers))
+	for chat := range r.handlers {
+		chats = append(chats, chat)
+	}
+	return chats
+}
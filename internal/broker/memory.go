@@ -0,0 +1,28 @@
+package broker
+
+import "context"
+
+// Memory delivers messages inside one process: single-node setups and tests
+type Memory struct {
+	reg *registry
+}
+
+func NewMemory() *Memory {
+	return &Memory{reg: newRegistry()}
+}
+
+func (m *Memory) Publish(ctx context.Context, chatUUID string, payload []byte) error {
+	// копируем, чтобы подписчики не зависели от буфера отправителя
+	data := append([]byte(nil), payload...)
+	m.reg.dispatch(chatUUID, data)
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, chatUUID string, handler Handler) (func(), error) {
+	id, _ := m.reg.add(chatUUID, handler)
+	return func() { m.reg.remove(chatUUID, id) }, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
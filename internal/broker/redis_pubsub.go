@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const pubsubPrefix = "chat:"
+
+// RedisPubSub fans out through Redis pub/sub: fire-and-forget, no replay
+type RedisPubSub struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	reg    *registry
+	subMu  sync.Mutex // сериализует SUBSCRIBE/UNSUBSCRIBE, чтобы отписка не обогнала подписку
+}
+
+func NewRedisPubSub(client *redis.Client) *RedisPubSub {
+	r := &RedisPubSub{
+		client: client,
+		pubsub: client.Subscribe(context.Background()),
+		reg:    newRegistry(),
+	}
+	go r.loop()
+	return r
+}
+
+func (r *RedisPubSub) loop() {
+	for msg := range r.pubsub.Channel() {
+		chatUUID := strings.TrimPrefix(msg.Channel, pubsubPrefix)
+		r.reg.dispatch(chatUUID, []byte(msg.Payload))
+	}
+}
+
+func (r *RedisPubSub) Publish(ctx context.Context, chatUUID string, payload []byte) error {
+	return r.client.Publish(ctx, pubsubPrefix+chatUUID, payload).Err()
+}
+
+func (r *RedisPubSub) Subscribe(ctx context.Context, chatUUID string, handler Handler) (func(), error) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	id, first := r.reg.add(chatUUID, handler)
+	if first {
+		if err := r.pubsub.Subscribe(ctx, pubsubPrefix+chatUUID); err != nil {
+			r.reg.remove(chatUUID, id)
+			return nil, err
+		}
+	}
+
+	return func() {
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+
+		if r.reg.remove(chatUUID, id) {
+			if err := r.pubsub.Unsubscribe(context.Background(), pubsubPrefix+chatUUID); err != nil {
+				log.Printf("Ошибка отписки от %s: %v", chatUUID, err)
+			}
+		}
+	}, nil
+}
+
+func (r *RedisPubSub) Close() error {
+	return r.pubsub.Close()
+}
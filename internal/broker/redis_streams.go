@@ -0,0 +1,227 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	streamKey       = "chat:events"
+	streamBatchSize = 100
+)
+
+var (
+	// streamBlock is how long XREADGROUP waits for new events
+	streamBlock = 5 * time.Second
+	// streamPendingTTL bounds how long events from before the start wait for a
+	// local subscriber; старые события уже есть в истории. Переменные ради тестов.
+	streamPendingTTL = time.Minute
+)
+
+// RedisStreams keeps chat events in a Redis Stream. Каждый узел читает через свою
+// consumer group (по NodeID), поэтому получает все события, а после рестарта
+// продолжает с последнего подтверждённого и перечитывает неподтверждённые.
+//
+// Pending перечитывается один раз, при старте. События, добавленные до старта
+// узла, ждут в памяти первого подписчика своего чата, пока им не исполнится
+// streamPendingTTL: клиенты как раз переподключаются. Всё остальное
+// подтверждается сразу после раздачи локальным подписчикам, поэтому поздний
+// подписчик не получает уже разосланное — его он загрузит из истории.
+type RedisStreams struct {
+	client   *redis.Client
+	group    string
+	consumer string
+	maxLen   int64
+	reg      *registry
+	started  time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex                  // раздача из loop и выдача backlog в Subscribe
+	backlog map[string][]redis.XMessage // nil, когда окно после старта закрыто
+}
+
+func NewRedisStreams(client *redis.Client, nodeID string, maxLen int64) (*RedisStreams, error) {
+	if nodeID == "" {
+		return nil, errors.New("streams broker requires a stable node id")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &RedisStreams{
+		client:   client,
+		group:    "node:" + nodeID,
+		consumer: nodeID,
+		maxLen:   maxLen,
+		reg:      newRegistry(),
+		started:  time.Now().Truncate(time.Millisecond), // точность id записей стрима
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		backlog:  make(map[string][]redis.XMessage),
+	}
+
+	// "$" — новая группа начинает с текущего конца стрима, существующая продолжает с места
+	err := client.XGroupCreateMkStream(ctx, streamKey, s.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		cancel()
+		return nil, err
+	}
+
+	go s.loop(ctx)
+	return s, nil
+}
+
+func (s *RedisStreams) Publish(ctx context.Context, chatUUID string, payload []byte) error {
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]any{"chat": chatUUID, "payload": payload},
+	}).Err()
+}
+
+// Subscribe registers the handler; первый подписчик чата получает события,
+// которые ждали его с момента старта узла
+func (s *RedisStreams) Subscribe(ctx context.Context, chatUUID string, handler Handler) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, first := s.reg.add(chatUUID, handler)
+	if replay := s.backlog[chatUUID]; first && len(replay) > 0 {
+		delete(s.backlog, chatUUID)
+		ids := make([]string, 0, len(replay))
+		for _, msg := range replay {
+			payload, _ := msg.Values["payload"].(string)
+			handler([]byte(payload))
+			ids = append(ids, msg.ID)
+		}
+		s.ack(ctx, ids)
+	}
+	return func() { s.reg.remove(chatUUID, id) }, nil
+}
+
+func (s *RedisStreams) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *RedisStreams) loop(ctx context.Context) {
+	defer close(s.done)
+
+	// Сначала дочитываем то, что было выдано, но не подтверждено до рестарта
+	lastID := "0"
+
+	for ctx.Err() == nil {
+		s.closeBacklog(ctx)
+
+		args := &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{streamKey, lastID},
+			Count:    streamBatchSize,
+			Block:    -1,
+		}
+		if lastID == ">" {
+			args.Block = streamBlock
+		}
+
+		streams, err := s.client.XReadGroup(ctx, args).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Ошибка чтения из стрима: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var messages []redis.XMessage
+		if len(streams) > 0 {
+			messages = streams[0].Messages
+		}
+
+		if lastID != ">" {
+			if len(messages) == 0 {
+				lastID = ">"
+				continue
+			}
+			lastID = messages[len(messages)-1].ID
+		}
+
+		s.ack(ctx, s.dispatch(messages))
+	}
+}
+
+// dispatch hands entries to local subscribers and returns the ids to acknowledge.
+// Событие из времени до старта без подписчика откладывается в backlog.
+func (s *RedisStreams) dispatch(messages []redis.XMessage) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		chatUUID, _ := msg.Values["chat"].(string)
+		payload, _ := msg.Values["payload"].(string)
+		if !s.reg.dispatch(chatUUID, []byte(payload)) && s.backlog != nil &&
+			added(msg.ID).Before(s.started) && !expired(msg.ID) {
+			s.backlog[chatUUID] = append(s.backlog[chatUUID], msg)
+			continue
+		}
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+// closeBacklog acknowledges what nobody claimed within streamPendingTTL after the start
+func (s *RedisStreams) closeBacklog(ctx context.Context) {
+	s.mu.Lock()
+	if s.backlog == nil || time.Since(s.started) <= streamPendingTTL {
+		s.mu.Unlock()
+		return
+	}
+	var ids []string
+	for _, messages := range s.backlog {
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+		}
+	}
+	s.backlog = nil
+	s.mu.Unlock()
+
+	s.ack(ctx, ids)
+}
+
+func (s *RedisStreams) ack(ctx context.Context, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := s.client.XAck(ctx, streamKey, s.group, ids...).Err(); err != nil {
+		log.Printf("Ошибка подтверждения сообщений стрима: %v", err)
+	}
+}
+
+// added returns when the entry was added: id записи стрима начинается
+// с времени добавления в миллисекундах
+func added(id string) time.Time {
+	ms, _, _ := strings.Cut(id, "-")
+	t, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(t)
+}
+
+// expired reports whether the entry is older than streamPendingTTL
+func expired(id string) bool {
+	return time.Since(added(id)) > streamPendingTTL
+}
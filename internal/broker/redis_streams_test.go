@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStreams(t *testing.T) (*redis.Client, func(node string) *RedisStreams) {
+	t.Helper()
+
+	block := streamBlock
+	streamBlock = 50 * time.Millisecond
+	t.Cleanup(func() { streamBlock = block })
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return client, func(node string) *RedisStreams {
+		s, err := NewRedisStreams(client, node, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+}
+
+func subscribe(t *testing.T, s *RedisStreams, chatUUID string) <-chan string {
+	t.Helper()
+	got := make(chan string, 10)
+	if _, err := s.Subscribe(context.Background(), chatUUID, func(payload []byte) {
+		got <- string(payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func expect(t *testing.T, got <-chan string, want string) {
+	t.Helper()
+	select {
+	case payload := <-got:
+		if payload != want {
+			t.Fatalf("payload = %q, want %q", payload, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%q not delivered", want)
+	}
+}
+
+// waitPending waits until the node's group has exactly n unacknowledged entries
+func waitPending(t *testing.T, client *redis.Client, node string, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		pending, err := client.XPending(context.Background(), streamKey, "node:"+node).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending.Count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending = %d, want %d", pending.Count, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// nothing checks that no more payloads arrive for a while
+func nothing(t *testing.T, got <-chan string) {
+	t.Helper()
+	select {
+	case payload := <-got:
+		t.Fatalf("unexpected %q", payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestStreamsLateSubscriberGetsNoDeliveredEvents(t *testing.T) {
+	client, newStreams := newTestStreams(t)
+	s := newStreams("n1")
+	ctx := context.Background()
+
+	first := subscribe(t, s, "c1")
+	s.Publish(ctx, "c1", []byte("to c1"))
+	s.Publish(ctx, "c2", []byte("to c2"))
+	expect(t, first, "to c1")
+
+	// Подписчика c2 на узле нет, но событие свежее: подтверждается сразу
+	waitPending(t, client, "n1", 0)
+
+	// Клиент, только что загрузивший историю, не получает её ещё раз
+	second := subscribe(t, s, "c2")
+	again := subscribe(t, s, "c1")
+	nothing(t, second)
+	nothing(t, again)
+
+	s.Publish(ctx, "c2", []byte("new"))
+	expect(t, second, "new")
+	nothing(t, first)
+}
+
+func TestStreamsReplayWaitsForSubscriberAfterRestart(t *testing.T) {
+	client, newStreams := newTestStreams(t)
+	ctx := context.Background()
+
+	newStreams("n1").Close()
+	newStreams("n2").Publish(ctx, "c0", []byte("before restart")) // пока n1 остановлен
+	time.Sleep(10 * time.Millisecond)
+
+	// После рестарта никто ещё не подписан: событие ждёт, а не теряется
+	after := newStreams("n1")
+	time.Sleep(200 * time.Millisecond)
+	waitPending(t, client, "n1", 1)
+
+	got := subscribe(t, after, "c0")
+	expect(t, got, "before restart")
+	waitPending(t, client, "n1", 0)
+
+	// Второй раз не повторяется
+	again := subscribe(t, after, "c0")
+	nothing(t, again)
+}
+
+func TestStreamsBacklogExpires(t *testing.T) {
+	client, newStreams := newTestStreams(t)
+	ctx := context.Background()
+
+	ttl := streamPendingTTL
+	streamPendingTTL = 300 * time.Millisecond
+	t.Cleanup(func() { streamPendingTTL = ttl })
+
+	newStreams("n1").Close()
+	// Запись с id из 1970 года: старше streamPendingTTL, её никто не ждёт
+	if err := client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		ID:     "2-0",
+		Values: map[string]any{"chat": "c1", "payload": "stale"},
+	}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	newStreams("n2").Publish(ctx, "c2", []byte("before restart"))
+	time.Sleep(10 * time.Millisecond)
+
+	s := newStreams("n1")
+	time.Sleep(100 * time.Millisecond)
+	waitPending(t, client, "n1", 1)
+	nothing(t, subscribe(t, s, "c1"))
+
+	// Окно после старта закрылось: несобранное подтверждается без доставки
+	waitPending(t, client, "n1", 0)
+	nothing(t, subscribe(t, s, "c2"))
+}
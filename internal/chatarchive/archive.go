@@ -0,0 +1,116 @@
+// Package chatarchive exports one chat into a portable archive and imports
+// history from such archives and from Telegram Desktop.
+//
+// Формат архива, версия 1 — ZIP со следующими файлами:
+//
+//	manifest.json    {"format": "chat-app/chat-archive", "version": 1,
+//	                  "exported_at": "...", "chat": {...}, "participants": [...]}
+//	messages.jsonl   одно сообщение на строку, по возрастанию created_at
+//	attachments/     файлы вложений, если они есть (необязательно)
+//
+// Строка messages.jsonl:
+//
+//	{"id": "...", "sender_uuid": "...", "sender_email": "...", "sender_name": "...",
+//	 "content": "...", "created_at": "2024-05-01T10:00:00Z",
+//	 "attachments": [{"path": "attachments/1.jpg", "name": "1.jpg", "mime_type": "image/jpeg", "size": 1024}]}
+//
+// Время — RFC 3339 с зоной. Вложения только упоминаются: path указывает на файл
+// внутри архива или, если файла нет, просто хранит исходное имя. sender_email
+// есть только в административной выгрузке, по нему импорт находит пользователей.
+// Читатель должен пропускать незнакомые поля и отказываться от версий новее своей.
+package chatarchive
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Format identifies the archive in manifest.json
+const Format = "chat-app/chat-archive"
+
+// Version is the newest archive version this package reads and the one it writes
+const Version = 1
+
+// Имена файлов внутри архива
+const (
+	ManifestFile = "manifest.json"
+	MessagesFile = "messages.jsonl"
+)
+
+// ErrUnsupported is returned for input that is neither our archive nor a Telegram export
+var ErrUnsupported = errors.New("unsupported archive")
+
+// Manifest is manifest.json
+type Manifest struct {
+	Format       string        `json:"format"`
+	Version      int           `json:"version"`
+	ExportedAt   time.Time     `json:"exported_at"`
+	Chat         Chat          `json:"chat"`
+	Participants []Participant `json:"participants"`
+}
+
+// Chat describes the exported chat
+type Chat struct {
+	UUID      uuid.UUID `json:"uuid"`
+	Type      string    `json:"type"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Participant is a chat member at export time
+type Participant struct {
+	UUID    uuid.UUID `json:"uuid"`
+	Email   string    `json:"email,omitempty"`
+	Name    string    `json:"name"`
+	Surname string    `json:"surname,omitempty"`
+}
+
+// Message is one line of messages.jsonl
+type Message struct {
+	ID          string       `json:"id"`
+	SenderUUID  uuid.UUID    `json:"sender_uuid"`
+	SenderEmail string       `json:"sender_email,omitempty"`
+	SenderName  string       `json:"sender_name"`
+	Content     string       `json:"content"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// SenderKey identifies the sender in the source system, для сопоставления
+	// с email при импорте: from_id у Telegram. Не сохраняется в архив.
+	SenderKey string `json:"-"`
+}
+
+// Attachment references a file sent with the message
+type Attachment struct {
+	Path     string `json:"path"`
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+// Archive is a chat read from any supported source
+type Archive struct {
+	Source       string // SourceNative или SourceTelegram
+	SourceID     string // id чата в исходной системе, если это не наш архив
+	Chat         Chat
+	Participants []Participant
+	Messages     []Message
+}
+
+// Откуда прочитан архив
+const (
+	SourceNative   = "chat-app"
+	SourceTelegram = "telegram"
+)
+
+// VersionError is returned for archives written by a newer version of the service
+type VersionError struct {
+	Version int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("archive version %d is newer than supported version %d", e.Version, Version)
+}
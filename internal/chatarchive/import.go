@@ -0,0 +1,295 @@
+package chatarchive
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Что делать с сообщениями, автора которых не нашли среди пользователей
+const (
+	UnknownFail      = "fail"      // импорт не выполняется, возвращается UnmatchedError
+	UnknownAnonymous = "anonymous" // сообщения остаются без автора, как у удалённых аккаунтов
+)
+
+// importNamespace derives stable UUIDs, чтобы повторный импорт не дублировал чаты и сообщения
+var importNamespace = uuid.MustParse("6f1c2b0e-8a4d-4f57-9c3e-2d7b5a1e0f94")
+
+// ImportOptions control how senders are matched
+type ImportOptions struct {
+	// Senders maps a sender in the source to the email of a user here: from_id
+	// или имя отправителя из Telegram, старый email из нашего архива
+	Senders map[string]string
+	Unknown string    // UnknownFail или UnknownAnonymous; пусто — UnknownFail
+	Creator uuid.UUID // кто импортирует; автор нового чата, если сам в нём участвует
+}
+
+// ImportResult reports what the import did
+type ImportResult struct {
+	ChatUUID     uuid.UUID `json:"chat_uuid"`
+	Created      bool      `json:"created"`
+	Imported     int       `json:"imported"`
+	Duplicates   int       `json:"duplicates"` // уже были после прошлого импорта
+	Anonymous    int       `json:"anonymous"`
+	Participants int       `json:"participants"`
+}
+
+// UnmatchedError lists senders that have no account here
+type UnmatchedError struct {
+	Senders []string
+}
+
+func (e *UnmatchedError) Error() string {
+	return "no user found for senders: " + strings.Join(e.Senders, ", ")
+}
+
+// ErrNoParticipants means none of the senders matched a user
+var ErrNoParticipants = errors.New("no sender of the chat matched a user")
+
+// Importer writes archives into the service
+type Importer struct {
+	users   repository.UserRepository
+	chats   repository.ChatRepository
+	imports repository.ImportRepository
+}
+
+// NewImporter creates an importer
+func NewImporter(users repository.UserRepository, chats repository.ChatRepository, imports repository.ImportRepository) *Importer {
+	return &Importer{users: users, chats: chats, imports: imports}
+}
+
+// Import stores the archive as a chat, keeping the original send times.
+// Личный чат с теми же двумя людьми дополняется, а не создаётся заново;
+// повторный импорт того же архива пропускает уже перенесённые сообщения.
+func (im *Importer) Import(ctx context.Context, a *Archive, opts ImportOptions) (*ImportResult, error) {
+	if opts.Unknown == "" {
+		opts.Unknown = UnknownFail
+	}
+	if opts.Unknown != UnknownFail && opts.Unknown != UnknownAnonymous {
+		return nil, fmt.Errorf("unknown senders policy must be %q or %q", UnknownFail, UnknownAnonymous)
+	}
+
+	resolved := make(map[string]uuid.UUID) // email → пользователь, uuid.Nil — не найден
+	lookup := func(email string) (uuid.UUID, error) {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			return uuid.Nil, nil
+		}
+		if id, ok := resolved[email]; ok {
+			return id, nil
+		}
+		user, err := im.users.GetByEmail(ctx, email)
+		if errors.Is(err, repository.ErrNotFound) {
+			resolved[email] = uuid.Nil
+			return uuid.Nil, nil
+		}
+		if err != nil {
+			return uuid.Nil, err
+		}
+		resolved[email] = user.UUID
+		return user.UUID, nil
+	}
+	emailFor := func(keys ...string) string {
+		for _, k := range keys {
+			if k == "" {
+				continue
+			}
+			if email, ok := opts.Senders[k]; ok {
+				return email
+			}
+		}
+		return ""
+	}
+
+	var participants []uuid.UUID
+	addParticipant := func(id uuid.UUID) {
+		if id != uuid.Nil && !slices.Contains(participants, id) {
+			participants = append(participants, id)
+		}
+	}
+
+	// Участники из манифеста, даже если ничего не писали
+	for _, p := range a.Participants {
+		email := emailFor(p.Email, p.UUID.String())
+		if email == "" {
+			email = p.Email
+		}
+		id, err := lookup(email)
+		if err != nil {
+			return nil, err
+		}
+		addParticipant(id)
+	}
+
+	senders := make([]uuid.UUID, len(a.Messages))
+	var unmatched []string
+	for i, m := range a.Messages {
+		keys := []string{m.SenderKey, m.SenderEmail, m.SenderName}
+		if m.SenderUUID != uuid.Nil {
+			keys = append(keys, m.SenderUUID.String())
+		}
+		email := emailFor(keys...)
+		if email == "" {
+			email = m.SenderEmail
+		}
+		id, err := lookup(email)
+		if err != nil {
+			return nil, err
+		}
+		// Сообщения удалённых аккаунтов в нашем архиве и так без автора
+		if id == uuid.Nil && (a.Source != SourceNative || m.SenderUUID != models.DeletedUserUUID) {
+			label := senderLabel(m)
+			if !slices.Contains(unmatched, label) {
+				unmatched = append(unmatched, label)
+			}
+		}
+		senders[i] = id
+		addParticipant(id)
+	}
+	if len(unmatched) > 0 && opts.Unknown == UnknownFail {
+		return nil, &UnmatchedError{Senders: unmatched}
+	}
+	if len(participants) == 0 {
+		return nil, ErrNoParticipants
+	}
+
+	chat, created, err := im.target(ctx, a, participants, opts.Creator)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{ChatUUID: chat.UUID, Created: created, Participants: len(chat.Participants)}
+	messages := make([]models.Message, 0, len(a.Messages))
+	for i, m := range a.Messages {
+		content := withAttachments(m.Content, m.Attachments)
+		if content == "" {
+			continue
+		}
+		if senders[i] == uuid.Nil {
+			result.Anonymous++
+		}
+		messages = append(messages, models.Message{
+			UUID:       messageUUID(a, chat.UUID, m.ID),
+			ChatUUID:   chat.UUID,
+			SenderUUID: senders[i],
+			Content:    content,
+			CreatedAt:  m.CreatedAt,
+			IsRead:     true, // это история, а не новые сообщения
+		})
+	}
+	slices.SortStableFunc(messages, func(x, y models.Message) int {
+		return x.CreatedAt.Compare(y.CreatedAt)
+	})
+
+	result.Imported, err = im.imports.Import(ctx, chat, messages)
+	if err != nil {
+		return nil, err
+	}
+	result.Duplicates = len(messages) - result.Imported
+	return result, nil
+}
+
+// target picks the chat to write into: тот же чат после прошлого импорта,
+// существующий личный чат этих двоих или новый
+func (im *Importer) target(ctx context.Context, a *Archive, participants []uuid.UUID, creator uuid.UUID) (*models.Chat, bool, error) {
+	chatUUID := a.Chat.UUID
+	switch {
+	case a.Source == SourceNative && chatUUID != uuid.Nil:
+	case a.SourceID != "":
+		chatUUID = uuid.NewSHA1(importNamespace, []byte(a.Source+"/"+a.SourceID))
+	default:
+		chatUUID = uuid.New()
+	}
+
+	existing, err := im.chats.Get(ctx, chatUUID)
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, false, err
+	}
+
+	direct := a.Chat.Type == models.ChatDirect && len(participants) == 2
+	if direct {
+		for _, order := range [][]uuid.UUID{participants, {participants[1], participants[0]}} {
+			id, err := im.chats.FindDirect(ctx, order)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			existing, err := im.chats.Get(ctx, id)
+			return existing, false, err
+		}
+	}
+
+	chat := &models.Chat{
+		UUID:         chatUUID,
+		Type:         models.ChatGroup,
+		Name:         a.Chat.Name,
+		Participants: participants,
+		CreatorUUID:  participants[0],
+		CreatedAt:    a.Chat.CreatedAt,
+	}
+	if slices.Contains(participants, creator) {
+		chat.CreatorUUID = creator
+	}
+	if direct {
+		chat.Type = models.ChatDirect
+		chat.Name = ""
+	} else if chat.Name == "" {
+		chat.Name = "Импортированный чат"
+	}
+	if chat.CreatedAt.IsZero() {
+		chat.CreatedAt = time.Now()
+	}
+	return chat, true, nil
+}
+
+// messageUUID keeps our own message ids and derives stable ones for other sources
+func messageUUID(a *Archive, chatUUID uuid.UUID, id string) uuid.UUID {
+	if a.Source == SourceNative {
+		if parsed, err := uuid.Parse(id); err == nil {
+			return parsed
+		}
+	}
+	return uuid.NewSHA1(importNamespace, []byte(chatUUID.String()+"/"+id))
+}
+
+// withAttachments appends references to files, которые сервис пока не хранит
+func withAttachments(content string, attachments []Attachment) string {
+	var b strings.Builder
+	b.WriteString(content)
+	for _, at := range attachments {
+		name := at.Name
+		if name == "" {
+			name = at.Path
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("[вложение: " + name + "]")
+	}
+	return b.String()
+}
+
+func senderLabel(m Message) string {
+	switch {
+	case m.SenderKey != "" && m.SenderName != "":
+		return m.SenderName + " (" + m.SenderKey + ")"
+	case m.SenderEmail != "":
+		return m.SenderEmail
+	case m.SenderName != "":
+		return m.SenderName
+	default:
+		return m.SenderUUID.String()
+	}
+}
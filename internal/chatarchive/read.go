@@ -0,0 +1,135 @@
+package chatarchive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+)
+
+// telegramFile is what Telegram Desktop names its JSON export
+const telegramFile = "result.json"
+
+// Лимиты чтения: сжатый ZIP из небольшой загрузки может распаковаться в гигабайты
+var (
+	maxEntrySize int64 = 512 << 20 // распакованный размер одного файла архива
+	maxMessages        = 1_000_000 // сообщений в одном архиве
+)
+
+// ErrTooLarge means the archive exceeds the entry size or message count limits
+var ErrTooLarge = errors.New("archive is too large to import")
+
+// Read detects the format and reads the whole chat: наш ZIP-архив, result.json
+// из Telegram Desktop или папку выгрузки Telegram, упакованную в ZIP
+func Read(r io.ReaderAt, size int64) (*Archive, error) {
+	head := make([]byte, 4)
+	if _, err := r.ReadAt(head, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return readTelegram(&limitReader{r: io.NewSectionReader(r, 0, size), n: maxEntrySize})
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	var telegram *zip.File
+	for _, f := range zr.File {
+		switch {
+		case f.Name == ManifestFile:
+			return readNative(zr)
+		case path.Base(f.Name) == telegramFile && (telegram == nil || len(f.Name) < len(telegram.Name)):
+			telegram = f
+		}
+	}
+	if telegram == nil {
+		return nil, ErrUnsupported
+	}
+
+	rc, err := telegram.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readTelegram(&limitReader{r: rc, n: maxEntrySize})
+}
+
+func readNative(zr *zip.Reader) (*Archive, error) {
+	var manifest Manifest
+	if err := decodeFile(zr, ManifestFile, func(d *json.Decoder) error { return d.Decode(&manifest) }); err != nil {
+		return nil, err
+	}
+	if manifest.Format != Format || manifest.Version < 1 {
+		return nil, ErrUnsupported
+	}
+	if manifest.Version > Version {
+		return nil, &VersionError{Version: manifest.Version}
+	}
+
+	a := &Archive{
+		Source:       SourceNative,
+		Chat:         manifest.Chat,
+		Participants: manifest.Participants,
+	}
+	err := decodeFile(zr, MessagesFile, func(d *json.Decoder) error {
+		for line := 1; ; line++ {
+			var m Message
+			err := d.Decode(&m)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("message %d: %w", line, err)
+			}
+			if m.ID == "" || m.CreatedAt.IsZero() {
+				return fmt.Errorf("message %d: id and created_at are required", line)
+			}
+			if len(a.Messages) == maxMessages {
+				return fmt.Errorf("%w: more than %d messages", ErrTooLarge, maxMessages)
+			}
+			a.Messages = append(a.Messages, m)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func decodeFile(zr *zip.Reader, name string, decode func(*json.Decoder) error) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defer f.Close()
+
+	if err := decode(json.NewDecoder(&limitReader{r: f, n: maxEntrySize})); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// limitReader is io.LimitReader that fails with ErrTooLarge instead of a
+// silent EOF, чтобы обрезанный JSON не выдавал себя за битый архив
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, fmt.Errorf("%w: a file unpacks to more than %d MB", ErrTooLarge, maxEntrySize>>20)
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,111 @@
+package chatarchive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withLimits lowers the read limits for one test
+func withLimits(t *testing.T, entrySize int64, messages int) {
+	t.Helper()
+	oldSize, oldMessages := maxEntrySize, maxMessages
+	maxEntrySize, maxMessages = entrySize, messages
+	t.Cleanup(func() { maxEntrySize, maxMessages = oldSize, oldMessages })
+}
+
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// nativeArchive builds our archive with n messages, each padded to pad bytes of content
+func nativeArchive(t *testing.T, n, pad int) []byte {
+	t.Helper()
+	manifest, _ := json.Marshal(Manifest{Format: Format, Version: Version, Chat: Chat{Type: "group", Name: "test"}})
+	var lines strings.Builder
+	for i := range n {
+		line, _ := json.Marshal(Message{
+			ID:         fmt.Sprint(i),
+			SenderName: "Ann",
+			Content:    strings.Repeat("a", pad),
+			CreatedAt:  time.Unix(int64(i), 0).UTC(),
+		})
+		lines.Write(line)
+		lines.WriteByte('\n')
+	}
+	return zipFiles(t, map[string]string{ManifestFile: string(manifest), MessagesFile: lines.String()})
+}
+
+// telegramExportJSON builds a result.json with n messages, each padded to pad bytes of text
+func telegramExportJSON(n, pad int) string {
+	var b strings.Builder
+	b.WriteString(`{"name": "test", "type": "private_group", "id": 1, "messages": [`)
+	for i := range n {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id": %d, "type": "message", "date_unixtime": "%d", "from": "Ann", "from_id": "user1", "text": %q}`,
+			i+1, 1700000000+i, strings.Repeat("a", pad))
+	}
+	b.WriteString("]}")
+	return b.String()
+}
+
+func read(data []byte) (*Archive, error) {
+	return Read(bytes.NewReader(data), int64(len(data)))
+}
+
+func TestReadLimits(t *testing.T) {
+	withLimits(t, 64<<10, 100)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		tooBig  bool
+		howMany int
+	}{
+		{"native within limits", nativeArchive(t, 100, 10), false, 100},
+		{"native with too many messages", nativeArchive(t, 101, 10), true, 0},
+		// Сжимается в килобайты, распаковывается за лимит
+		{"native entry too large", nativeArchive(t, 10, 10<<10), true, 0},
+		{"telegram within limits", zipFiles(t, map[string]string{"export/result.json": telegramExportJSON(100, 10)}), false, 100},
+		{"telegram with too many messages", zipFiles(t, map[string]string{"result.json": telegramExportJSON(101, 10)}), true, 0},
+		{"telegram entry too large", zipFiles(t, map[string]string{"result.json": telegramExportJSON(10, 10<<10)}), true, 0},
+		{"plain telegram json too large", []byte(telegramExportJSON(10, 10<<10)), true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := read(tt.data)
+			if tt.tooBig {
+				if !errors.Is(err, ErrTooLarge) {
+					t.Fatalf("err = %v, want ErrTooLarge", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a.Messages) != tt.howMany {
+				t.Errorf("messages = %d, want %d", len(a.Messages), tt.howMany)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package chatarchive
+
+import (
+	"chat-app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Выгрузка одного чата из Telegram Desktop в машиночитаемом формате (result.json).
+// Описаны только поля, которые мы переносим.
+type telegramExport struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	ID       json.Number       `json:"id"`
+	Messages []telegramMessage `json:"messages"`
+	Chats    json.RawMessage   `json:"chats"` // есть только в выгрузке всего аккаунта
+}
+
+type telegramMessage struct {
+	ID            json.Number     `json:"id"`
+	Type          string          `json:"type"` // message или service (вход в группу, закреп и т. п.)
+	Date          string          `json:"date"` // местное время без зоны
+	DateUnix      string          `json:"date_unixtime"`
+	From          string          `json:"from"`
+	FromID        string          `json:"from_id"`
+	Text          json.RawMessage `json:"text"`
+	Photo         string          `json:"photo"`
+	PhotoSize     int64           `json:"photo_file_size"`
+	File          string          `json:"file"`
+	FileName      string          `json:"file_name"`
+	FileSize      int64           `json:"file_size"`
+	MimeType      string          `json:"mime_type"`
+	ForwardedFrom string          `json:"forwarded_from"`
+}
+
+// telegramDirect is the chat type of a one-to-one conversation
+const telegramDirect = "personal_chat"
+
+func readTelegram(r io.Reader) (*Archive, error) {
+	var export telegramExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if len(export.Chats) > 0 && string(export.Chats) != "null" {
+		return nil, errors.New("this is a whole-account Telegram export, export a single chat instead")
+	}
+	if export.Messages == nil {
+		return nil, ErrUnsupported
+	}
+	if len(export.Messages) > maxMessages {
+		return nil, fmt.Errorf("%w: more than %d messages", ErrTooLarge, maxMessages)
+	}
+
+	a := &Archive{
+		Source:   SourceTelegram,
+		SourceID: export.ID.String(),
+		Chat:     Chat{Type: models.ChatGroup, Name: export.Name},
+	}
+	if export.Type == telegramDirect {
+		a.Chat.Type = models.ChatDirect
+	}
+
+	// Списка участников в выгрузке нет, участниками станут найденные отправители
+	for _, tm := range export.Messages {
+		if tm.Type != "message" {
+			continue
+		}
+
+		createdAt, err := telegramTime(tm)
+		if err != nil {
+			return nil, fmt.Errorf("message %s: %w", tm.ID, err)
+		}
+		text, err := telegramText(tm.Text)
+		if err != nil {
+			return nil, fmt.Errorf("message %s: %w", tm.ID, err)
+		}
+		if tm.ForwardedFrom != "" {
+			text = "Переслано от " + tm.ForwardedFrom + ":\n" + text
+		}
+
+		m := Message{
+			ID:         "telegram:" + export.ID.String() + ":" + tm.ID.String(),
+			SenderName: tm.From,
+			SenderKey:  tm.FromID,
+			Content:    text,
+			CreatedAt:  createdAt,
+		}
+		if tm.Photo != "" {
+			m.Attachments = append(m.Attachments, Attachment{Path: tm.Photo, MimeType: "image/jpeg", Size: tm.PhotoSize})
+		}
+		if tm.File != "" {
+			m.Attachments = append(m.Attachments, Attachment{Path: tm.File, Name: tm.FileName, MimeType: tm.MimeType, Size: tm.FileSize})
+		}
+		a.Messages = append(a.Messages, m)
+	}
+
+	if len(a.Messages) > 0 {
+		a.Chat.CreatedAt = a.Messages[0].CreatedAt
+	}
+	return a, nil
+}
+
+// telegramTime prefers the unix time: поле date записано в часовом поясе того,
+// кто выгружал, и без зоны, поэтому берём его как UTC только в старых выгрузках
+func telegramTime(tm telegramMessage) (time.Time, error) {
+	if tm.DateUnix != "" {
+		sec, err := strconv.ParseInt(tm.DateUnix, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("date_unixtime: %w", err)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02T15:04:05", tm.Date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date: %w", err)
+	}
+	return t, nil
+}
+
+// telegramText flattens the text field: строка или массив из строк и
+// фрагментов с разметкой вида {"type": "bold", "text": "..."}
+func telegramText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	var plain string
+	if err := json.Unmarshal(raw, &plain); err == nil {
+		return plain, nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("text: %w", err)
+	}
+
+	var b strings.Builder
+	for _, part := range parts {
+		var s string
+		if err := json.Unmarshal(part, &s); err == nil {
+			b.WriteString(s)
+			continue
+		}
+		var entity struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+			Href string `json:"href"`
+		}
+		if err := json.Unmarshal(part, &entity); err != nil {
+			return "", fmt.Errorf("text: %w", err)
+		}
+		b.WriteString(entity.Text)
+		if entity.Type == "text_link" && entity.Href != "" && entity.Href != entity.Text {
+			b.WriteString(" (" + entity.Href + ")")
+		}
+	}
+	return b.String(), nil
+}
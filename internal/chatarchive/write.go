@@ -0,0 +1,86 @@
+package chatarchive
+
+import (
+	"archive/zip"
+	"chat-app/internal/models"
+	"encoding/json"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Write streams the chat as a version 1 archive. messages must already carry
+// SenderName (names.Service.Enrich); users are the participants and senders the
+// service knows. Email пишется только при withEmails — обычный участник не должен
+// получать адреса собеседников, а администратору они нужны для импорта.
+func Write(w io.Writer, chat *models.Chat, messages []models.Message, users []models.User, withEmails bool) error {
+	byUUID := make(map[uuid.UUID]*models.User, len(users))
+	for i := range users {
+		byUUID[users[i].UUID] = &users[i]
+	}
+
+	manifest := Manifest{
+		Format:     Format,
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Chat: Chat{
+			UUID:      chat.UUID,
+			Type:      chat.Type,
+			Name:      chat.Name,
+			CreatedAt: chat.CreatedAt,
+		},
+		Participants: make([]Participant, 0, len(chat.Participants)),
+	}
+	for _, id := range chat.Participants {
+		p := Participant{UUID: id}
+		if u, ok := byUUID[id]; ok {
+			p.Name, p.Surname = u.Name, u.Surname
+			if withEmails {
+				p.Email = u.Email
+			}
+		}
+		manifest.Participants = append(manifest.Participants, p)
+	}
+
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create(ManifestFile)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+
+	f, err = zw.Create(MessagesFile)
+	if err != nil {
+		return err
+	}
+	enc = json.NewEncoder(f)
+
+	sorted := slices.Clone(messages)
+	slices.SortStableFunc(sorted, func(a, b models.Message) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	for _, m := range sorted {
+		line := Message{
+			ID:         m.UUID.String(),
+			SenderUUID: m.SenderUUID,
+			SenderName: m.SenderName,
+			Content:    m.Content,
+			CreatedAt:  m.CreatedAt.UTC(),
+		}
+		if u, ok := byUUID[m.SenderUUID]; ok && withEmails {
+			line.SenderEmail = u.Email
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
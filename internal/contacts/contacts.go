@@ -0,0 +1,286 @@
+// Package contacts manages mutual contacts and decides who may message whom.
+// Настройка приватности и список контактов кэшируются в памяти и перечитываются
+// на всех инстансах через Redis, как списки блокировок в пакете blocks.
+package contacts
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// changedChannel carries the UUID of a user whose contacts or privacy changed
+const changedChannel = "users:contacts:changed"
+
+// DefaultTTL bounds staleness if a change notification was missed
+const DefaultTTL = 10 * time.Minute
+
+// refreshTimeout bounds a background reload of one user
+const refreshTimeout = 5 * time.Second
+
+// ErrAlreadyContacts is returned when a request is sent to an existing contact
+var ErrAlreadyContacts = errors.New("already contacts")
+
+type entry struct {
+	contactsOnly bool
+	contacts     map[uuid.UUID]struct{}
+	loaded       time.Time
+}
+
+// Service caches each user's contacts and message privacy.
+// Записи старше ttl вычищаются при очередной загрузке, см. sweepLocked.
+type Service struct {
+	repo   repository.ContactRepository
+	users  repository.UserRepository
+	redis  *redis.Client // nil — один инстанс
+	ttl    time.Duration
+	mu     sync.RWMutex
+	cache  map[uuid.UUID]*entry
+	swept  time.Time // последняя чистка просроченных записей
+	pubsub *redis.PubSub
+}
+
+// New creates the service; client may be nil for single-instance setups and tools
+func New(repo repository.ContactRepository, users repository.UserRepository, client *redis.Client) *Service {
+	return &Service{
+		repo:  repo,
+		users: users,
+		redis: client,
+		ttl:   DefaultTTL,
+		cache: make(map[uuid.UUID]*entry),
+	}
+}
+
+// Start subscribes to contact changes made on other instances
+func (s *Service) Start(ctx context.Context) error {
+	if s.redis == nil {
+		return nil
+	}
+
+	s.pubsub = s.redis.Subscribe(ctx, changedChannel)
+	if _, err := s.pubsub.Receive(ctx); err != nil {
+		s.pubsub.Close()
+		return err
+	}
+
+	go s.listen()
+	return nil
+}
+
+func (s *Service) listen() {
+	for msg := range s.pubsub.ChannelWithSubscriptions() {
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// После обрыва не знаем, что пропустили: перечитываем всех, кто в кэше
+			if m.Kind == "subscribe" {
+				go s.refreshAll()
+			}
+		case *redis.Message:
+			userUUID, err := uuid.Parse(m.Payload)
+			if err != nil {
+				log.Printf("Некорректное уведомление о контактах: %q", m.Payload)
+				continue
+			}
+			s.refreshCached(userUUID)
+		}
+	}
+}
+
+// Close stops listening for changes
+func (s *Service) Close() error {
+	if s.pubsub == nil {
+		return nil
+	}
+	return s.pubsub.Close()
+}
+
+func (s *Service) load(ctx context.Context, userUUID uuid.UUID) (*entry, error) {
+	s.mu.RLock()
+	e, ok := s.cache[userUUID]
+	s.mu.RUnlock()
+	if ok && time.Since(e.loaded) < s.ttl {
+		return e, nil
+	}
+	return s.reload(ctx, userUUID)
+}
+
+func (s *Service) reload(ctx context.Context, userUUID uuid.UUID) (*entry, error) {
+	user, err := s.users.GetByUUID(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := s.repo.ListContacts(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{
+		contactsOnly: user.MessagePrivacy == models.MessagesFromContacts,
+		contacts:     make(map[uuid.UUID]struct{}, len(contacts)),
+		loaded:       time.Now(),
+	}
+	for _, ct := range contacts {
+		e.contacts[ct.ContactUUID] = struct{}{}
+	}
+
+	s.mu.Lock()
+	s.cache[userUUID] = e
+	// Просроченные записи тех, кому больше не пишут, иначе копились бы вечно
+	if e.loaded.Sub(s.swept) >= s.ttl {
+		s.sweepLocked(e.loaded)
+	}
+	s.mu.Unlock()
+	return e, nil
+}
+
+// sweepLocked drops expired entries; s.mu must be held for writing
+func (s *Service) sweepLocked(now time.Time) {
+	for id, e := range s.cache {
+		if now.Sub(e.loaded) >= s.ttl {
+			delete(s.cache, id)
+		}
+	}
+	s.swept = now
+}
+
+// refreshCached reloads the user only if they are already cached here
+func (s *Service) refreshCached(userUUID uuid.UUID) {
+	s.mu.RLock()
+	_, ok := s.cache[userUUID]
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	if _, err := s.reload(ctx, userUUID); err != nil {
+		log.Printf("Не удалось перечитать контакты %s: %v", userUUID, err)
+	}
+}
+
+func (s *Service) refreshAll() {
+	s.mu.RLock()
+	users := make([]uuid.UUID, 0, len(s.cache))
+	for id := range s.cache {
+		users = append(users, id)
+	}
+	s.mu.RUnlock()
+
+	for _, id := range users {
+		s.refreshCached(id)
+	}
+}
+
+// AreContacts reports whether a and b are mutual contacts
+func (s *Service) AreContacts(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	e, err := s.load(ctx, a)
+	if err != nil {
+		return false, err
+	}
+	_, ok := e.contacts[b]
+	return ok, nil
+}
+
+// CanMessage reports whether recipient's privacy setting lets sender write to them directly
+func (s *Service) CanMessage(ctx context.Context, sender, recipient uuid.UUID) (bool, error) {
+	e, err := s.load(ctx, recipient)
+	if err != nil {
+		return false, err
+	}
+	if !e.contactsOnly {
+		return true, nil
+	}
+	_, ok := e.contacts[sender]
+	return ok, nil
+}
+
+// List returns the user's contacts, newest first
+func (s *Service) List(ctx context.Context, userUUID uuid.UUID) ([]models.Contact, error) {
+	return s.repo.ListContacts(ctx, userUUID)
+}
+
+// Incoming returns requests waiting for the user's answer
+func (s *Service) Incoming(ctx context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error) {
+	return s.repo.ListIncoming(ctx, userUUID)
+}
+
+// Outgoing returns the user's requests nobody answered yet
+func (s *Service) Outgoing(ctx context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error) {
+	return s.repo.ListOutgoing(ctx, userUUID)
+}
+
+// Request sends a contact request. Если встречная заявка уже есть, она
+// принимается сразу и accepted будет true. ErrAlreadyContacts — они уже контакты,
+// repository.ErrConflict — такая заявка уже ждёт ответа.
+func (s *Service) Request(ctx context.Context, fromUUID, toUUID uuid.UUID) (accepted bool, err error) {
+	already, err := s.AreContacts(ctx, fromUUID, toUUID)
+	if err != nil {
+		return false, err
+	}
+	if already {
+		return false, ErrAlreadyContacts
+	}
+
+	err = s.Accept(ctx, toUUID, fromUUID)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return false, err
+	}
+	return false, s.repo.Request(ctx, fromUUID, toUUID)
+}
+
+// Accept makes the pending request from fromUUID to toUUID a mutual contact
+func (s *Service) Accept(ctx context.Context, fromUUID, toUUID uuid.UUID) error {
+	if err := s.repo.Accept(ctx, fromUUID, toUUID); err != nil {
+		return err
+	}
+	s.changed(ctx, fromUUID, toUUID)
+	return nil
+}
+
+// Decline drops a pending request; so does cancelling it from the sender's side
+func (s *Service) Decline(ctx context.Context, fromUUID, toUUID uuid.UUID) error {
+	return s.repo.DeleteRequest(ctx, fromUUID, toUUID)
+}
+
+// Remove deletes the contact for both users
+func (s *Service) Remove(ctx context.Context, a, b uuid.UUID) error {
+	if err := s.repo.Remove(ctx, a, b); err != nil {
+		return err
+	}
+	s.changed(ctx, a, b)
+	return nil
+}
+
+// SetPrivacy stores who may message the user directly
+func (s *Service) SetPrivacy(ctx context.Context, userUUID uuid.UUID, value string) error {
+	if err := s.users.SetMessagePrivacy(ctx, userUUID, value); err != nil {
+		return err
+	}
+	s.changed(ctx, userUUID)
+	return nil
+}
+
+func (s *Service) changed(ctx context.Context, users ...uuid.UUID) {
+	for _, id := range users {
+		// Свой инстанс обновляем сразу, не дожидаясь эха из Redis
+		s.refreshCached(id)
+
+		if s.redis == nil {
+			continue
+		}
+		if err := s.redis.Publish(ctx, changedChannel, id.String()).Err(); err != nil {
+			log.Printf("Не удалось разослать изменение контактов: %v", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package mail sends transactional letters (email verification and the like).
+package mail
+
+import (
+	"bytes"
+	"chat-app/config"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text letter
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers letters
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns an SMTP sender, or one that only logs when no server is configured
+func New(cfg config.Mail) Sender {
+	if cfg.SMTPAddr == "" {
+		return LogSender{}
+	}
+	return &SMTPSender{cfg: cfg}
+}
+
+// LogSender writes letters to the log instead of sending them. Для разработки:
+// ссылки подтверждения видно прямо в выводе сервера.
+type LogSender struct{}
+
+func (LogSender) Send(_ context.Context, msg Message) error {
+	log.Printf("Письмо для %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// SMTPSender sends through an SMTP relay; STARTTLS включается, если сервер его умеет
+type SMTPSender struct {
+	cfg config.Mail
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(s.cfg.From)
+	if err != nil {
+		return fmt.Errorf("mail from: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("mail to: %w", err)
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(s.cfg.SMTPAddr)
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	// net/smtp не принимает контекст, поэтому ограничиваем отправку отдельно
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.cfg.SMTPAddr, auth, from.Address, []string{to.Address}, compose(from, to, msg))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func compose(from, to *mail.Address, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Block means BlockerUUID doesn't want to hear from BlockedUUID
+type Block struct {
+	BlockerUUID uuid.UUID `json:"-"`
+	BlockedUUID uuid.UUID `json:"user_uuid"`
+	CreatedAt   time.Time `json:"created_at"`
+}
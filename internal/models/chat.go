@@ -1 +1,38 @@
 package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ChatDirect = "direct"
+	ChatGroup  = "group"
+)
+
+type Chat struct {
+	UUID         uuid.UUID   `json:"chat_uuid"`
+	Type         string      `json:"type"`
+	Name         string      `json:"name,omitempty"`
+	Participants []uuid.UUID `json:"participants"`
+	CreatorUUID  uuid.UUID   `json:"creator_uuid"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+}
+
+// HasParticipant reports whether userUUID is a member of the chat
+func (c *Chat) HasParticipant(userUUID uuid.UUID) bool {
+	return slices.Contains(c.Participants, userUUID)
+}
+
+// Peer returns the other side of a direct chat
+func (c *Chat) Peer(userUUID uuid.UUID) (uuid.UUID, bool) {
+	for _, p := range c.Participants {
+		if p != userUUID {
+			return p, true
+		}
+	}
+	return uuid.Nil, false
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Contact is a mutual contact of the list's owner
+type Contact struct {
+	UserUUID    uuid.UUID `json:"-"`
+	ContactUUID uuid.UUID `json:"user_uuid"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ContactRequest is a pending request from FromUUID to ToUUID
+type ContactRequest struct {
+	FromUUID  uuid.UUID `json:"from_uuid"`
+	ToUUID    uuid.UUID `json:"to_uuid"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -10,8 +10,16 @@ type Message struct {
 	UUID       uuid.UUID `json:"uuid"`
 	ChatUUID   uuid.UUID `json:"chat_uuid"`
 	SenderUUID uuid.UUID `json:"sender_uuid"`
+	SenderName string    `json:"sender_name"` // не хранится, заполняется при чтении из names.Service
 	Content    string    `json:"content"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 	IsRead     bool      `json:"is_read"`
 }
+
+// MessageHit is a message found by full-text search
+type MessageHit struct {
+	Message
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"` // HTML-экранированный фрагмент, совпадения обёрнуты в <mark>
+}
@@ -2,8 +2,11 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -14,8 +17,132 @@ type User struct {
 	Surname      string    `json:"surname"`
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	TOTPEnabled  bool      `json:"-"`
+	IsAdmin      bool      `json:"-"`
+	// Discoverability controls how other people can find the user in search
+	Discoverability string `json:"discoverability"`
+	// MessagePrivacy controls who can message the user in direct chats
+	MessagePrivacy  string     `json:"message_privacy"`
+	Bio             string     `json:"bio"`
+	Locale          string     `json:"locale"`
+	AvatarUpdatedAt *time.Time `json:"avatar_updated_at,omitempty"`
+	// DeletionScheduledAt is when the account will be erased, nil if no deletion is pending
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// TwoFactor is the user's TOTP state
+type TwoFactor struct {
+	Secret       string // пусто, пока подключение 2FA не начато
+	Enabled      bool
+	LastUsedStep int64 // последний принятый шаг TOTP, чтобы код нельзя было повторить
+}
+
+// Identity is an account's login at an external OpenID provider
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string // email у провайдера на момент входа
+}
+
+// DeletedUserUUID replaces the sender of messages whose author deleted the account
+var DeletedUserUUID = uuid.Nil
+
+// Значения users.discoverability
+const (
+	DiscoverableByName  = "name"  // по имени и точному email
+	DiscoverableByEmail = "email" // только по точному email
+	DiscoverableNone    = "none"  // не находится поиском
+)
+
+// ValidDiscoverability reports whether s is a known discoverability setting
+func ValidDiscoverability(s string) bool {
+	return s == DiscoverableByName || s == DiscoverableByEmail || s == DiscoverableNone
+}
+
+// Значения users.message_privacy
+const (
+	MessagesFromEveryone = "everyone"
+	MessagesFromContacts = "contacts" // личные сообщения только от контактов
+)
+
+// ValidMessagePrivacy reports whether s is a known message privacy setting
+func ValidMessagePrivacy(s string) bool {
+	return s == MessagesFromEveryone || s == MessagesFromContacts
+}
+
+// UserHit is a user found by search. Email в выдаче не отдаётся.
+type UserHit struct {
+	UUID       uuid.UUID `json:"uuid"`
+	Name       string    `json:"name"`
+	Rank       float64   `json:"rank"`
+	SharesChat bool      `json:"shares_chat"`
+}
+
+// DisplayName is how the user is shown to other people in chats
+func (u *User) DisplayName() string {
+	switch {
+	case u.Name != "" && u.Surname != "":
+		return u.Name + " " + u.Surname
+	case u.Name != "":
+		return u.Name
+	case u.Surname != "":
+		return u.Surname
+	default:
+		return "пользователь"
+	}
+}
+
+// AvatarURL is where clients fetch the avatar, empty when there is none
+func (u *User) AvatarURL() string {
+	if u.AvatarUpdatedAt == nil {
+		return ""
+	}
+	return fmt.Sprintf("/api/v1/users/%s/avatar?v=%d", u.UUID, u.AvatarUpdatedAt.Unix())
+}
+
+// ProfileUpdate is a partial profile edit; nil fields stay as they are
+type ProfileUpdate struct {
+	Name    *string `json:"name"`
+	Surname *string `json:"surname"`
+	Bio     *string `json:"bio"`
+	Locale  *string `json:"locale"`
+}
+
+var localeRegex = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// Validate checks lengths and the locale format (ru, en, en-US)
+func (p *ProfileUpdate) Validate() error {
+	if p.Name != nil && (strings.TrimSpace(*p.Name) == "" || utf8.RuneCountInString(*p.Name) > 100) {
+		return errors.New("name must be 1 to 100 characters")
+	}
+	if p.Surname != nil && utf8.RuneCountInString(*p.Surname) > 100 {
+		return errors.New("surname must be at most 100 characters")
+	}
+	if p.Bio != nil && utf8.RuneCountInString(*p.Bio) > 500 {
+		return errors.New("bio must be at most 500 characters")
+	}
+	if p.Locale != nil && !localeRegex.MatchString(*p.Locale) {
+		return errors.New("locale must look like ru, en or en-US")
+	}
+	return nil
+}
+
+// Apply copies the set fields onto u
+func (p *ProfileUpdate) Apply(u *User) {
+	if p.Name != nil {
+		u.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Surname != nil {
+		u.Surname = strings.TrimSpace(*p.Surname)
+	}
+	if p.Bio != nil {
+		u.Bio = *p.Bio
+	}
+	if p.Locale != nil {
+		u.Locale = *p.Locale
+	}
 }
 
 type UserLogin struct {
@@ -30,10 +157,16 @@ type UserRegister struct {
 	Password string `json:"password" binding:"required,min=6"`
 }
 
+var emailRegex = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
+
+// ValidEmail checks the email format accepted at registration
+func ValidEmail(email string) bool {
+	return emailRegex.MatchString(email)
+}
+
 // Validate checks if email format is valid
 func (u *UserRegister) Validate() error {
-	emailRegex := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
-	if !emailRegex.MatchString(u.Email) {
+	if !ValidEmail(u.Email) {
 		return errors.New("Invalid email")
 	}
 	return nil
@@ -0,0 +1,215 @@
+// Package names resolves user display names for chats. Это единственное место,
+// где из пользователя получается подпись к сообщению: имя не хранится в
+// сообщениях, а подставляется при чтении и рассылке.
+package names
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidateChannel carries UUIDs of users whose name changed on any instance
+const invalidateChannel = "users:names:invalidate"
+
+// DefaultTTL bounds staleness if an invalidation was missed (e.g. Redis blinked)
+const DefaultTTL = 10 * time.Minute
+
+// Unknown is shown for senders that no longer exist
+var Unknown = (&models.User{}).DisplayName()
+
+// Deleted is shown for messages anonymized when their author deleted the account
+const Deleted = "удалённый аккаунт"
+
+type entry struct {
+	name    string
+	expires time.Time
+}
+
+// Service caches display names and drops them on every instance when a profile changes
+type Service struct {
+	users  repository.UserRepository
+	redis  *redis.Client // nil — один инстанс, инвалидация только локальная
+	ttl    time.Duration
+	mu     sync.RWMutex
+	cache  map[uuid.UUID]entry
+	swept  time.Time // последняя чистка просроченных записей
+	gen    uint64    // растёт при каждой инвалидации, см. Names
+	pubsub *redis.PubSub
+}
+
+// New creates the service; client may be nil for single-instance setups and tools
+func New(users repository.UserRepository, client *redis.Client) *Service {
+	return &Service{
+		users: users,
+		redis: client,
+		ttl:   DefaultTTL,
+		cache: make(map[uuid.UUID]entry),
+	}
+}
+
+// Start subscribes to invalidations from other instances
+func (s *Service) Start(ctx context.Context) error {
+	if s.redis == nil {
+		return nil
+	}
+
+	s.pubsub = s.redis.Subscribe(ctx, invalidateChannel)
+	// Ждём подтверждения, чтобы не пропустить инвалидации сразу после старта
+	if _, err := s.pubsub.Receive(ctx); err != nil {
+		s.pubsub.Close()
+		return err
+	}
+
+	go s.listen()
+	return nil
+}
+
+func (s *Service) listen() {
+	for msg := range s.pubsub.ChannelWithSubscriptions() {
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// Переподписались после обрыва: что пропустили, не знаем, сбрасываем всё
+			if m.Kind == "subscribe" {
+				s.mu.Lock()
+				clear(s.cache)
+				s.gen++
+				s.mu.Unlock()
+			}
+		case *redis.Message:
+			userUUID, err := uuid.Parse(m.Payload)
+			if err != nil {
+				log.Printf("Некорректная инвалидация имени: %q", m.Payload)
+				continue
+			}
+			s.forget(userUUID)
+		}
+	}
+}
+
+// Close stops listening for invalidations
+func (s *Service) Close() error {
+	if s.pubsub == nil {
+		return nil
+	}
+	return s.pubsub.Close()
+}
+
+// Name returns the user's current display name
+func (s *Service) Name(ctx context.Context, userUUID uuid.UUID) string {
+	return s.Names(ctx, []uuid.UUID{userUUID})[userUUID]
+}
+
+// Names resolves many users with at most one query for the ones not cached.
+// При ошибке базы отдаём заглушку, но не кэшируем её.
+func (s *Service) Names(ctx context.Context, userUUIDs []uuid.UUID) map[uuid.UUID]string {
+	result := make(map[uuid.UUID]string, len(userUUIDs))
+	var missing []uuid.UUID
+	now := time.Now()
+
+	s.mu.RLock()
+	gen := s.gen
+	for _, id := range userUUIDs {
+		if _, done := result[id]; done {
+			continue
+		}
+		if id == models.DeletedUserUUID {
+			result[id] = Deleted
+			continue
+		}
+		if e, ok := s.cache[id]; ok && now.Before(e.expires) {
+			result[id] = e.name
+			continue
+		}
+		result[id] = Unknown
+		missing = append(missing, id)
+	}
+	s.mu.RUnlock()
+
+	if len(missing) == 0 {
+		return result
+	}
+
+	users, err := s.users.ListByUUIDs(ctx, missing)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Не удалось получить имена пользователей: %v", err)
+		}
+		return result
+	}
+
+	found := make(map[uuid.UUID]string, len(users))
+	for _, u := range users {
+		found[u.UUID] = u.DisplayName()
+	}
+
+	s.mu.Lock()
+	// Если пока шёл запрос, кого-то переименовали, прочитанное могло устареть: не кэшируем
+	cacheable := s.gen == gen
+	for _, id := range missing {
+		name, ok := found[id]
+		if !ok {
+			name = Unknown // удалённый пользователь, тоже кэшируем
+		}
+		result[id] = name
+		if cacheable {
+			s.cache[id] = entry{name: name, expires: now.Add(s.ttl)}
+		}
+	}
+	// Просроченные записи тех, кого больше не спрашивают, иначе копились бы вечно
+	if now.Sub(s.swept) >= s.ttl {
+		s.sweepLocked(now)
+	}
+	s.mu.Unlock()
+
+	return result
+}
+
+// Enrich fills SenderName on messages in place
+func (s *Service) Enrich(ctx context.Context, messages []models.Message) {
+	ids := make([]uuid.UUID, len(messages))
+	for i, m := range messages {
+		ids[i] = m.SenderUUID
+	}
+	names := s.Names(ctx, ids)
+	for i := range messages {
+		messages[i].SenderName = names[messages[i].SenderUUID]
+	}
+}
+
+// Invalidate drops the cached name here and on every other instance.
+// Вызывать после любого изменения имени или фамилии.
+func (s *Service) Invalidate(ctx context.Context, userUUID uuid.UUID) {
+	s.forget(userUUID)
+
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Publish(ctx, invalidateChannel, userUUID.String()).Err(); err != nil {
+		// Остальные инстансы увидят новое имя не позже чем через TTL
+		log.Printf("Не удалось разослать инвалидацию имени %s: %v", userUUID, err)
+	}
+}
+
+// sweepLocked drops expired entries; s.mu must be held for writing
+func (s *Service) sweepLocked(now time.Time) {
+	for id, e := range s.cache {
+		if !now.Before(e.expires) {
+			delete(s.cache, id)
+		}
+	}
+	s.swept = now
+}
+
+func (s *Service) forget(userUUID uuid.UUID) {
+	s.mu.Lock()
+	delete(s.cache, userUUID)
+	s.gen++
+	s.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package names
+
+import (
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestService(t *testing.T, ttl time.Duration, n int) (*Service, []uuid.UUID) {
+	t.Helper()
+	users := repository.NewMemoryUsers()
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		u := &models.User{Name: "User", Email: uuid.NewString() + "@example.com"}
+		if err := users.Create(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = u.UUID
+	}
+	s := New(users, nil)
+	s.ttl = ttl
+	return s, ids
+}
+
+func cached(s *Service) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.cache)
+}
+
+func TestExpiredEntriesAreSwept(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	s, ids := newTestService(t, ttl, 101)
+	ctx := context.Background()
+
+	// Сотня отправителей, которых больше никто не спросит
+	s.Names(ctx, ids[:100])
+	if got := cached(s); got != 100 {
+		t.Fatalf("cached = %d, want 100", got)
+	}
+
+	time.Sleep(2 * ttl)
+	if got := s.Name(ctx, ids[100]); got != "User" {
+		t.Errorf("name = %q", got)
+	}
+	if got := cached(s); got != 1 {
+		t.Errorf("cached = %d after TTL, want only the fresh entry", got)
+	}
+}
+
+func TestLiveEntriesSurviveSweep(t *testing.T) {
+	s, ids := newTestService(t, time.Hour, 3)
+	ctx := context.Background()
+
+	s.Names(ctx, ids[:2])
+	s.mu.Lock()
+	s.swept = time.Time{} // следующий промах сразу чистит
+	s.mu.Unlock()
+
+	s.Name(ctx, ids[2])
+	if got := cached(s); got != 3 {
+		t.Errorf("cached = %d, sweep dropped live entries", got)
+	}
+}
@@ -0,0 +1,279 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "chat-app"
+	testCode     = "auth-code"
+	testKeyID    = "k1"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS и token endpoint с PKCE
+type mockIssuer struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	issuer string // что отдаёт discovery, по умолчанию адрес сервера
+
+	mu        sync.Mutex
+	challenge string // code_challenge из запроса авторизации
+	idToken   func(issuer string) string
+
+	jwksHits atomic.Int32
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := m.srv.URL
+		if m.issuer != "" {
+			issuer = m.issuer
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.jwksHits.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		challenge, idToken := m.challenge, m.idToken
+		m.mu.Unlock()
+
+		if r.PostForm.Get("code") != testCode || r.PostForm.Get("client_id") != testClientID ||
+			CodeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken(m.srv.URL)})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockIssuer) provider(t *testing.T) *Provider {
+	t.Helper()
+	p, err := NewProvider(context.Background(), Config{
+		IssuerURL:   m.srv.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://app.example/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// sign issues an ID token; mutate правит claims перед подписью
+func (m *mockIssuer) sign(issuer, nonce, kid string, mutate func(jwt.MapClaims)) string {
+	claims := jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "Ann@Example.com",
+		"email_verified": "true",
+	}
+	if mutate != nil {
+		mutate(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return raw
+}
+
+// authorize follows AuthCodeURL the way a browser would and records the PKCE challenge
+func (m *mockIssuer) authorize(t *testing.T, p *Provider, nonce, verifier string, idToken func(issuer string) string) {
+	t.Helper()
+	u, err := url.Parse(p.AuthCodeURL("state", nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without S256 PKCE: %s", u)
+	}
+	if q.Get("nonce") != nonce || q.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization request: %s", u)
+	}
+
+	m.mu.Lock()
+	m.challenge = q.Get("code_challenge")
+	m.idToken = idToken
+	m.mu.Unlock()
+}
+
+func TestDiscovery(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	if p.Issuer() != m.srv.URL {
+		t.Errorf("issuer = %q, want %q", p.Issuer(), m.srv.URL)
+	}
+
+	m.issuer = "https://evil.example"
+	if _, err := NewProvider(context.Background(), Config{IssuerURL: m.srv.URL, ClientID: testClientID}); err == nil {
+		t.Error("issuer mismatch in metadata accepted")
+	}
+
+	if _, err := NewProvider(context.Background(), Config{IssuerURL: m.srv.URL + "/missing", ClientID: testClientID}); err == nil {
+		t.Error("failed discovery accepted")
+	}
+}
+
+func TestExchangeWithPKCE(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+
+	const nonce, verifier = "nonce-1", "verifier-1"
+	m.authorize(t, p, nonce, verifier, func(issuer string) string {
+		return m.sign(issuer, nonce, testKeyID, nil)
+	})
+
+	claims, err := p.Exchange(context.Background(), testCode, verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "ann@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if _, err := p.Exchange(context.Background(), testCode, "wrong-verifier", nonce); err == nil {
+		t.Error("exchange with a wrong PKCE verifier succeeded")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	const nonce = "nonce-1"
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"nonce mismatch", m.sign(m.srv.URL, "other-nonce", testKeyID, nil), nonce},
+		{"empty expected nonce", m.sign(m.srv.URL, "", testKeyID, nil), ""},
+		{"wrong audience", m.sign(m.srv.URL, nonce, testKeyID, func(c jwt.MapClaims) { c["aud"] = "other-client" }), nonce},
+		{"wrong issuer", m.sign("https://evil.example", nonce, testKeyID, nil), nonce},
+		{"expired", m.sign(m.srv.URL, nonce, testKeyID, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), nonce},
+		{"missing sub", m.sign(m.srv.URL, nonce, testKeyID, func(c jwt.MapClaims) { delete(c, "sub") }), nonce},
+		{"unknown kid", m.sign(m.srv.URL, nonce, "forged", nil), nonce},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.VerifyIDToken(context.Background(), tt.token, tt.nonce); err == nil {
+				t.Error("token accepted")
+			}
+		})
+	}
+
+	if _, err := p.VerifyIDToken(context.Background(), m.sign(m.srv.URL, nonce, testKeyID, nil), nonce); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+}
+
+func TestUnknownKidRefetchIsThrottled(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	const nonce = "nonce-1"
+
+	if _, err := p.VerifyIDToken(context.Background(), m.sign(m.srv.URL, nonce, testKeyID, nil), nonce); err != nil {
+		t.Fatal(err)
+	}
+	before := m.jwksHits.Load()
+
+	for i := 0; i < 20; i++ {
+		forged := m.sign(m.srv.URL, nonce, "forged-"+strings.Repeat("x", i), nil)
+		if _, err := p.VerifyIDToken(context.Background(), forged, nonce); err == nil {
+			t.Fatal("token with unknown kid accepted")
+		}
+	}
+	if hits := m.jwksHits.Load() - before; hits != 0 {
+		t.Errorf("JWKS fetched %d times for forged kids within a minute", hits)
+	}
+
+	// Через минуту промах снова может сходить за ключами — ротация работает
+	p.refreshMu.Lock()
+	p.triedAt = time.Now().Add(-jwksMinRefresh)
+	p.refreshMu.Unlock()
+	p.VerifyIDToken(context.Background(), m.sign(m.srv.URL, nonce, "rotated", nil), nonce)
+	if hits := m.jwksHits.Load() - before; hits != 1 {
+		t.Errorf("JWKS fetched %d times after the throttle window, want 1", hits)
+	}
+
+	// Известный ключ по-прежнему принимается
+	if _, err := p.VerifyIDToken(context.Background(), m.sign(m.srv.URL, nonce, testKeyID, nil), nonce); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+}
+
+func TestReauthCodeURL(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+
+	u, err := url.Parse(p.ReauthCodeURL("state", "nonce-1", "verifier-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("prompt") != "login" || q.Get("max_age") != "0" || q.Get("code_challenge") == "" {
+		t.Errorf("reauth request does not force a fresh login: %s", u)
+	}
+
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	raw := m.sign(m.srv.URL, "nonce-1", testKeyID, func(c jwt.MapClaims) { c["auth_time"] = authTime.Unix() })
+	claims, err := p.VerifyIDToken(context.Background(), raw, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claims.AuthTime.Equal(authTime) {
+		t.Errorf("auth_time = %v, want %v", claims.AuthTime, authTime)
+	}
+}
@@ -0,0 +1,182 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// wal is an append-only JSON-lines file holding messages Postgres could not take.
+// Вставка идемпотентна (ON CONFLICT DO NOTHING), поэтому повторное проигрывание
+// уже записанных строк безопасно.
+type wal struct {
+	mu      sync.Mutex // защищает файл и count; на время записи в БД не держится
+	drainMu sync.Mutex // один drain за раз
+	path    string
+	count   int
+}
+
+// rejectedPath is where records Postgres refuses for good are kept for manual review
+func (w *wal) rejectedPath() string {
+	return w.path + ".rejected"
+}
+
+func openWAL(path string) (*wal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	w := &wal{path: path}
+	records, err := w.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	w.count = len(records)
+	return w, nil
+}
+
+// append writes records and fsyncs so they survive a crash
+func (w *wal) append(records []Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := writeRecords(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, records); err != nil {
+		return err
+	}
+	w.count += len(records)
+	return nil
+}
+
+// drain hands the spilled records to flush without holding the lock, so Enqueue
+// can keep spilling and Stats stays responsive while Postgres is slow. flush
+// returns how many records from the start it has stored; only that prefix is
+// removed, records appended in the meantime stay in the file.
+func (w *wal) drain(flush func([]Record) (int, error)) error {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+
+	w.mu.Lock()
+	if w.count == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	records, err := w.readLocked()
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	n, flushErr := flush(records)
+	if n > 0 {
+		if err := w.dropPrefix(n); err != nil {
+			return err
+		}
+	}
+	return flushErr
+}
+
+// dropPrefix removes the first n records. Хвост переписывается во временный файл
+// и подменяет журнал через rename, так что при падении остаётся старый или новый файл целиком.
+func (w *wal) dropPrefix(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	records, err := w.readLocked()
+	if err != nil {
+		return err
+	}
+	rest := records[min(n, len(records)):]
+
+	if len(rest) == 0 {
+		if err := os.Truncate(w.path, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		w.count = 0
+		return nil
+	}
+
+	tmp := w.path + ".tmp"
+	if err := writeRecords(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, rest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return err
+	}
+	w.count = len(rest)
+	return nil
+}
+
+// quarantine keeps records Postgres rejects for good aside, чтобы одна битая
+// запись не держала весь журнал
+func (w *wal) quarantine(records []Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return writeRecords(w.rejectedPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, records)
+}
+
+// writeRecords encodes records into the file opened with flag and fsyncs it
+func writeRecords(path string, flag int, records []Record) error {
+	f, err := os.OpenFile(path, flag, 0o600)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (w *wal) size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (w *wal) readLocked() ([]Record, error) {
+	f, err := os.Open(w.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	dec := json.NewDecoder(f)
+	for {
+		var r Record
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Оборванная последняя строка после падения — всё до неё валидно
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
@@ -0,0 +1,193 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func records(n int) []Record {
+	rs := make([]Record, n)
+	for i := range rs {
+		rs[i] = Record{UUID: uuid.New(), ChatUUID: uuid.New(), SenderUUID: uuid.New(), Content: "hi", CreatedAt: time.Now()}
+	}
+	return rs
+}
+
+func newTestWriter(t *testing.T, batchSize int) *Writer {
+	t.Helper()
+	w, err := NewWriter(nil, Options{BatchSize: batchSize, WALPath: filepath.Join(t.TempDir(), "messages.wal")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func uuids(rs []Record) []uuid.UUID {
+	ids := make([]uuid.UUID, len(rs))
+	for i, r := range rs {
+		ids[i] = r.UUID
+	}
+	return ids
+}
+
+func walContents(t *testing.T, w *wal) []uuid.UUID {
+	t.Helper()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rs, err := w.readLocked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uuids(rs)
+}
+
+func equalIDs(a, b []uuid.UUID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDrainDoesNotHoldLockDuringFlush(t *testing.T) {
+	w := newTestWriter(t, 10).wal
+	old, fresh := records(3), records(2)
+	if err := w.append(old); err != nil {
+		t.Fatal(err)
+	}
+
+	err := w.drain(func(rs []Record) (int, error) {
+		// Пока идёт запись в БД, Enqueue продолжает сбрасывать в WAL, а Stats отвечает
+		done := make(chan error, 1)
+		go func() {
+			w.size()
+			done <- w.append(fresh)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				return 0, err
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("append blocked by drain")
+		}
+		return len(rs), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := walContents(t, w); !equalIDs(got, uuids(fresh)) {
+		t.Errorf("WAL after drain = %v, want only records appended during flush %v", got, uuids(fresh))
+	}
+	if w.size() != len(fresh) {
+		t.Errorf("size = %d, want %d", w.size(), len(fresh))
+	}
+}
+
+func TestDrainKeepsUnflushedTail(t *testing.T) {
+	w := newTestWriter(t, 10).wal
+	rs := records(5)
+	if err := w.append(rs); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("connection refused")
+	if err := w.drain(func([]Record) (int, error) { return 2, failure }); !errors.Is(err, failure) {
+		t.Fatalf("drain error = %v, want %v", err, failure)
+	}
+	if got := walContents(t, w); !equalIDs(got, uuids(rs[2:])) {
+		t.Errorf("WAL = %v, want the unflushed tail %v", got, uuids(rs[2:]))
+	}
+	if w.size() != 3 {
+		t.Errorf("size = %d, want 3", w.size())
+	}
+}
+
+func TestReplayQuarantinesPoisonRecords(t *testing.T) {
+	w := newTestWriter(t, 2)
+	rs := records(5)
+	poison := rs[3].UUID
+	if err := w.wal.append(rs); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := make(map[uuid.UUID]bool)
+	insert := func(_ context.Context, batch []Record) error {
+		for _, r := range batch {
+			if r.UUID == poison {
+				return &pgconn.PgError{Code: "23503", Message: "chat was deleted"}
+			}
+		}
+		for _, r := range batch {
+			stored[r.UUID] = true
+		}
+		return nil
+	}
+
+	err := w.wal.drain(func(records []Record) (int, error) {
+		return w.replayRecords(records, insert)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range rs {
+		if r.UUID != poison && !stored[r.UUID] {
+			t.Errorf("record %s not stored", r.UUID)
+		}
+	}
+	if w.wal.size() != 0 || len(walContents(t, w.wal)) != 0 {
+		t.Errorf("WAL not truncated: %d records left", w.wal.size())
+	}
+
+	rejected := &wal{path: w.wal.rejectedPath()}
+	if got := walContents(t, rejected); !equalIDs(got, []uuid.UUID{poison}) {
+		t.Errorf("quarantine = %v, want %v", got, poison)
+	}
+	if st := w.Stats(); st.Rejected != 1 || st.Written != 4 || st.Spilled != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestReplayStopsOnTransientError(t *testing.T) {
+	w := newTestWriter(t, 2)
+	rs := records(5)
+	if err := w.wal.append(rs); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	insert := func(context.Context, []Record) error {
+		calls++
+		if calls > 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	err := w.wal.drain(func(records []Record) (int, error) {
+		return w.replayRecords(records, insert)
+	})
+	if err == nil {
+		t.Fatal("transient error swallowed")
+	}
+
+	// Первая пачка записана и убрана, остальное ждёт следующего проигрывания
+	if got := walContents(t, w.wal); !equalIDs(got, uuids(rs[2:])) {
+		t.Errorf("WAL = %v, want %v", got, uuids(rs[2:]))
+	}
+	if _, err := os.Stat(w.wal.rejectedPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Error("transient failure quarantined records")
+	}
+}
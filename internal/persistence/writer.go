@@ -0,0 +1,359 @@
+package persistence
+
+import (
+	"chat-app/database"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Record is a chat message waiting to be stored in the messages table.
+// Имя отправителя не пишем: его подставляет names.Service при чтении.
+type Record struct {
+	UUID       uuid.UUID `json:"uuid"`
+	ChatUUID   uuid.UUID `json:"chat_uuid"`
+	SenderUUID uuid.UUID `json:"sender_uuid"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+	IsRead     bool      `json:"is_read"`
+}
+
+// Options tune batching, retries and the spill file
+type Options struct {
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+	RetryBackoff  time.Duration
+	WALPath       string
+	ReplayEvery   time.Duration
+}
+
+var DefaultOptions = Options{
+	QueueSize:     10000,
+	BatchSize:     500,
+	FlushInterval: 50 * time.Millisecond,
+	MaxRetries:    5,
+	RetryBackoff:  100 * time.Millisecond,
+	WALPath:       "data/messages.wal",
+	ReplayEvery:   10 * time.Second,
+}
+
+var ErrClosed = errors.New("message writer is closed")
+
+// Stats describe the pipeline for monitoring
+type Stats struct {
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Spilled       int   `json:"spilled"` // записей в WAL, ждущих Postgres
+	Written       int64 `json:"written"`
+	FailedBatches int64 `json:"failed_batches"`
+	Rejected      int64 `json:"rejected"` // отвергнуты БД навсегда, лежат в <WALPath>.rejected
+}
+
+// Writer persists messages asynchronously: a bounded queue feeds a single worker
+// that batches INSERTs, retries with backoff and spills to a local WAL when
+// Postgres is unavailable, so a broadcast message is never silently lost
+type Writer struct {
+	db    *database.Database
+	opts  Options
+	queue chan Record
+	wal   *wal
+
+	mu     sync.RWMutex
+	closed bool
+
+	written  atomic.Int64
+	failed   atomic.Int64
+	rejected atomic.Int64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewWriter opens the WAL; records left from a previous run are replayed by Start
+func NewWriter(db *database.Database, opts Options) (*Writer, error) {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultOptions.QueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOptions.BatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultOptions.FlushInterval
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = DefaultOptions.MaxRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultOptions.RetryBackoff
+	}
+	if opts.WALPath == "" {
+		opts.WALPath = DefaultOptions.WALPath
+	}
+	if opts.ReplayEvery <= 0 {
+		opts.ReplayEvery = DefaultOptions.ReplayEvery
+	}
+
+	w, err := openWAL(opts.WALPath)
+	if err != nil {
+		return nil, fmt.Errorf("open message WAL: %w", err)
+	}
+	if n := w.size(); n > 0 {
+		log.Printf("В WAL %d несохранённых сообщений, допишем их в БД", n)
+	}
+
+	return &Writer{
+		db:    db,
+		opts:  opts,
+		queue: make(chan Record, opts.QueueSize),
+		wal:   w,
+		stop:  make(chan struct{}),
+	}, nil
+}
+
+// Start launches the batching worker and the WAL replayer
+func (w *Writer) Start() {
+	w.done.Add(2)
+	go w.run()
+	go w.replayLoop()
+}
+
+// Enqueue accepts a message for storage. Если очередь полна, запись сразу уходит
+// в WAL, а не блокирует отправителя; ошибка значит, что сообщение сохранить не удалось.
+func (w *Writer) Enqueue(r Record) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	select {
+	case w.queue <- r:
+		return nil
+	default:
+		return w.wal.append([]Record{r})
+	}
+}
+
+// Stats returns the current queue depth and counters
+func (w *Writer) Stats() Stats {
+	return Stats{
+		QueueDepth:    len(w.queue),
+		QueueCapacity: cap(w.queue),
+		Spilled:       w.wal.size(),
+		Written:       w.written.Load(),
+		FailedBatches: w.failed.Load(),
+		Rejected:      w.rejected.Load(),
+	}
+}
+
+// Close stops accepting messages and flushes the queue to Postgres or the WAL
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	close(w.stop)
+
+	done := make(chan struct{})
+	go func() {
+		w.done.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Writer) run() {
+	defer w.done.Done()
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, w.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		w.persist(batch)
+		batch = make([]Record, 0, w.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case r, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= w.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// persist writes a batch with retries, spilling it to the WAL as a last resort
+func (w *Writer) persist(batch []Record) {
+	backoff := w.opts.RetryBackoff
+	var err error
+	for attempt := 0; attempt < w.opts.MaxRetries; attempt++ {
+		if err = w.insert(context.Background(), batch); err == nil {
+			w.written.Add(int64(len(batch)))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-w.stop:
+			// при остановке не ждём, сразу сбрасываем в WAL
+			attempt = w.opts.MaxRetries
+		}
+		backoff *= 2
+	}
+
+	w.failed.Add(1)
+	log.Printf("Не удалось сохранить %d сообщений в БД (%v), пишем в WAL", len(batch), err)
+
+	if err := w.wal.append(batch); err != nil {
+		log.Printf("КРИТИЧНО: не удалось записать %d сообщений в WAL: %v", len(batch), err)
+	}
+}
+
+func (w *Writer) replayLoop() {
+	defer w.done.Done()
+
+	ticker := time.NewTicker(w.opts.ReplayEvery)
+	defer ticker.Stop()
+
+	w.replay()
+	for {
+		select {
+		case <-ticker.C:
+			w.replay()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *Writer) replay() {
+	err := w.wal.drain(func(records []Record) (int, error) {
+		return w.replayRecords(records, w.insert)
+	})
+	if err != nil {
+		log.Printf("WAL пока не удалось дописать: %v", err)
+	}
+}
+
+// replayRecords stores records in batches and returns how many from the start
+// are done. Если БД отвергает пачку из-за самих данных, пишем по одной, чтобы
+// найти и отложить виновные записи, а остальные сохранить.
+func (w *Writer) replayRecords(records []Record, insert func(context.Context, []Record) error) (int, error) {
+	done := 0
+	for done < len(records) {
+		end := min(done+w.opts.BatchSize, len(records))
+		batch := records[done:end]
+
+		err := insert(context.Background(), batch)
+		if err == nil {
+			w.written.Add(int64(len(batch)))
+			done = end
+			continue
+		}
+		if !permanent(err) {
+			return done, err
+		}
+
+		n, err := w.insertEach(batch, insert)
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+
+	if done > 0 {
+		log.Printf("Из WAL дописано %d сообщений", done)
+	}
+	return done, nil
+}
+
+// insertEach stores records one by one, quarantining those the database rejects for good
+func (w *Writer) insertEach(batch []Record, insert func(context.Context, []Record) error) (int, error) {
+	for i, r := range batch {
+		err := insert(context.Background(), []Record{r})
+		switch {
+		case err == nil:
+			w.written.Add(1)
+		case permanent(err):
+			if qerr := w.wal.quarantine([]Record{r}); qerr != nil {
+				return i, qerr
+			}
+			w.rejected.Add(1)
+			log.Printf("Сообщение %s отвергнуто БД и отложено в %s: %v", r.UUID, w.wal.rejectedPath(), err)
+		default:
+			return i, err
+		}
+	}
+	return len(batch), nil
+}
+
+// permanent reports whether Postgres rejected the data itself, so a retry cannot help:
+// классы 22 (некорректные данные) и 23 (нарушение ограничений, например удалённый чат)
+func permanent(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || len(pgErr.Code) < 2 {
+		return false
+	}
+	switch pgErr.Code[:2] {
+	case "22", "23":
+		return true
+	}
+	return false
+}
+
+// insert writes a batch in one transaction: подготовленный insert_message для каждой
+// записи уходит в БД одним пайплайном. COPY был бы быстрее, но не умеет ON CONFLICT,
+// а без него повторы и проигрывание WAL давали бы дубли.
+func (w *Writer) insert(ctx context.Context, batch []Record) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, err := w.db.Prepared(ctx, database.StmtInsertMessage)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		b := &pgx.Batch{}
+		for _, r := range batch {
+			b.Queue(database.StmtInsertMessage,
+				r.UUID, r.ChatUUID.String(), r.SenderUUID, r.Content, r.CreatedAt, r.IsRead)
+		}
+		return tx.SendBatch(ctx, b).Close()
+	})
+}
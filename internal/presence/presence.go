@@ -0,0 +1,270 @@
+// Package presence tracks which users have an open socket on any instance.
+// Каждый инстанс держит в Redis отметку «пользователь у меня онлайн» и
+// продлевает её сердцебиением; отметка упавшего инстанса истекает сама.
+package presence
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Heartbeat is how often an instance confirms the users connected to it
+const Heartbeat = 30 * time.Second
+
+// expiry: инстанс, пропустивший три сердцебиения, считается упавшим
+const expiry = 3 * Heartbeat
+
+// lastSeenTTL bounds how long "last seen" is kept for inactive users
+const lastSeenTTL = 30 * 24 * time.Hour
+
+// redisTimeout bounds one write from the background worker
+const redisTimeout = 5 * time.Second
+
+// Status is what other users see about someone's presence
+type Status struct {
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+type update struct {
+	userUUID uuid.UUID
+	online   bool
+	at       time.Time
+}
+
+// Tracker counts local sockets per user and mirrors online/offline into Redis.
+// Connected и Disconnected не ходят в сеть: их зовут из цикла хаба.
+type Tracker struct {
+	redis    *redis.Client // nil — один инстанс, всё в памяти
+	instance string
+	mu       sync.Mutex
+	local    map[uuid.UUID]int
+	seen     map[uuid.UUID]time.Time // без Redis: когда пользователь ушёл
+	updates  chan update
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates a tracker; client may be nil for single-instance setups
+func New(client *redis.Client) *Tracker {
+	return &Tracker{
+		redis:    client,
+		instance: uuid.NewString(),
+		local:    make(map[uuid.UUID]int),
+		seen:     make(map[uuid.UUID]time.Time),
+		updates:  make(chan update, 1024),
+		done:     make(chan struct{}),
+	}
+}
+
+func onlineKey(userUUID uuid.UUID) string {
+	return "presence:" + userUUID.String()
+}
+
+func lastSeenKey(userUUID uuid.UUID) string {
+	return "presence:seen:" + userUUID.String()
+}
+
+// Start runs the Redis writer and the heartbeat
+func (t *Tracker) Start() {
+	if t.redis == nil {
+		return
+	}
+	t.wg.Add(2)
+	go t.write()
+	go t.heartbeat()
+}
+
+// Close stops the tracker and marks everyone connected here as offline
+func (t *Tracker) Close() error {
+	if t.redis == nil {
+		return nil
+	}
+	close(t.done)
+	t.wg.Wait()
+
+	t.mu.Lock()
+	users := make([]uuid.UUID, 0, len(t.local))
+	for id := range t.local {
+		users = append(users, id)
+	}
+	t.local = make(map[uuid.UUID]int)
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	now := time.Now()
+	pipe := t.redis.Pipeline()
+	for _, id := range users {
+		t.offline(ctx, pipe, id, now)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Connected records a new socket of the user on this instance
+func (t *Tracker) Connected(userUUID uuid.UUID) {
+	t.mu.Lock()
+	t.local[userUUID]++
+	first := t.local[userUUID] == 1
+	t.mu.Unlock()
+
+	if first {
+		t.queue(update{userUUID: userUUID, online: true, at: time.Now()})
+	}
+}
+
+// Disconnected records that one of the user's sockets closed
+func (t *Tracker) Disconnected(userUUID uuid.UUID) {
+	now := time.Now()
+
+	t.mu.Lock()
+	t.local[userUUID]--
+	last := t.local[userUUID] <= 0
+	if last {
+		delete(t.local, userUUID)
+		t.seen[userUUID] = now
+	}
+	t.mu.Unlock()
+
+	if last {
+		t.queue(update{userUUID: userUUID, online: false, at: now})
+	}
+}
+
+// queue hands an update to the writer without blocking the hub.
+// Если очередь переполнена, «онлайн» восстановит сердцебиение,
+// а «офлайн» наступит сам, когда истечёт отметка.
+func (t *Tracker) queue(u update) {
+	if t.redis == nil {
+		return
+	}
+	select {
+	case t.updates <- u:
+	default:
+		log.Printf("Очередь присутствия переполнена, обновление %s пропущено", u.userUUID)
+	}
+}
+
+// write applies updates in order, so a quick reconnect never ends up offline
+func (t *Tracker) write() {
+	defer t.wg.Done()
+	for {
+		select {
+		case u := <-t.updates:
+			ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+			pipe := t.redis.Pipeline()
+			if u.online {
+				t.online(ctx, pipe, u.userUUID, u.at)
+			} else {
+				t.offline(ctx, pipe, u.userUUID, u.at)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				log.Printf("Не удалось обновить присутствие %s: %v", u.userUUID, err)
+			}
+			cancel()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *Tracker) heartbeat() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			users := make([]uuid.UUID, 0, len(t.local))
+			for id := range t.local {
+				users = append(users, id)
+			}
+			t.mu.Unlock()
+			if len(users) == 0 {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+			now := time.Now()
+			pipe := t.redis.Pipeline()
+			for _, id := range users {
+				t.online(ctx, pipe, id, now)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				log.Printf("Не удалось продлить присутствие: %v", err)
+			}
+			cancel()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// online stores this instance in the user's sorted set, scored by when the mark expires
+func (t *Tracker) online(ctx context.Context, pipe redis.Pipeliner, userUUID uuid.UUID, now time.Time) {
+	key := onlineKey(userUUID)
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(expiry).Unix()), Member: t.instance})
+	pipe.Expire(ctx, key, expiry)
+}
+
+func (t *Tracker) offline(ctx context.Context, pipe redis.Pipeliner, userUUID uuid.UUID, now time.Time) {
+	pipe.ZRem(ctx, onlineKey(userUUID), t.instance)
+	pipe.Set(ctx, lastSeenKey(userUUID), now.Unix(), lastSeenTTL)
+}
+
+// Statuses returns presence for each of userUUIDs
+func (t *Tracker) Statuses(ctx context.Context, userUUIDs []uuid.UUID) (map[uuid.UUID]Status, error) {
+	statuses := make(map[uuid.UUID]Status, len(userUUIDs))
+
+	if t.redis == nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, id := range userUUIDs {
+			st := Status{Online: t.local[id] > 0}
+			if seen, ok := t.seen[id]; ok && !st.Online {
+				st.LastSeen = &seen
+			}
+			statuses[id] = st
+		}
+		return statuses, nil
+	}
+
+	if len(userUUIDs) == 0 {
+		return statuses, nil
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	pipe := t.redis.Pipeline()
+	counts := make([]*redis.IntCmd, len(userUUIDs))
+	seen := make([]*redis.StringCmd, len(userUUIDs))
+	for i, id := range userUUIDs {
+		// Отметки упавших инстансов с истёкшим счётом не учитываем
+		counts[i] = pipe.ZCount(ctx, onlineKey(id), now, "+inf")
+		seen[i] = pipe.Get(ctx, lastSeenKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, id := range userUUIDs {
+		st := Status{Online: counts[i].Val() > 0}
+		if !st.Online {
+			if unix, err := seen[i].Int64(); err == nil {
+				at := time.Unix(unix, 0)
+				st.LastSeen = &at
+			}
+		}
+		statuses[id] = st
+	}
+	return statuses, nil
+}
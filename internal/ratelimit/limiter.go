@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Allow implements a fixed-window counter: at most limit hits per window for key.
+// Возвращает, сколько ждать до сброса окна, если лимит исчерпан.
+func Allow(ctx context.Context, client *redis.Client, key string, limit int64, window time.Duration) (bool, time.Duration, error) {
+	pipe := client.TxPipeline()
+	n := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true, 0, err
+	}
+
+	if n.Val() > limit {
+		return false, ttl.Val(), nil
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EventsChannel receives security events such as account lockouts
+const EventsChannel = "security:events"
+
+// LoginPolicy controls progressive delays and lockouts
+type LoginPolicy struct {
+	Window         time.Duration // окно, в котором копятся неудачные попытки
+	FreeAttempts   int64         // сколько ошибок прощаем без задержки
+	BaseDelay      time.Duration // задержка после первой «платной» ошибки, дальше удваивается
+	MaxDelay       time.Duration
+	AccountLockout int64 // после стольких ошибок аккаунт блокируется
+	IPLockout      int64 // то же для IP
+	LockDuration   time.Duration
+}
+
+// DefaultLoginPolicy is used when no policy is configured
+var DefaultLoginPolicy = LoginPolicy{
+	Window:         15 * time.Minute,
+	FreeAttempts:   3,
+	BaseDelay:      time.Second,
+	MaxDelay:       time.Minute,
+	AccountLockout: 10,
+	IPLockout:      50,
+	LockDuration:   15 * time.Minute,
+}
+
+// LoginGuard tracks failed logins per account and per IP in Redis.
+// Счётчики ведутся по хешу email независимо от того, есть ли такой пользователь,
+// поэтому по ответам нельзя узнать, зарегистрирован ли адрес.
+type LoginGuard struct {
+	client *redis.Client
+	policy LoginPolicy
+}
+
+// NewLoginGuard creates a guard with the given policy
+func NewLoginGuard(client *redis.Client, policy LoginPolicy) *LoginGuard {
+	return &LoginGuard{
+		client: client,
+		policy: policy,
+	}
+}
+
+// LockoutEvent is published to EventsChannel when an account or IP gets locked
+type LockoutEvent struct {
+	Type   string    `json:"type"`
+	Email  string    `json:"email,omitempty"`
+	IP     string    `json:"ip"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+func accountKey(kind, email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "login:" + kind + ":acct:" + hex.EncodeToString(sum[:])
+}
+
+func ipKey(kind, ip string) string {
+	return "login:" + kind + ":ip:" + ip
+}
+
+// Check returns how long the caller must wait before another attempt.
+// При недоступности Redis пропускаем попытку, чтобы не ломать вход целиком.
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) time.Duration {
+	pipe := g.client.Pipeline()
+	ttls := []*redis.DurationCmd{
+		pipe.PTTL(ctx, accountKey("lock", email)),
+		pipe.PTTL(ctx, ipKey("lock", ip)),
+		pipe.PTTL(ctx, accountKey("next", email)),
+		pipe.PTTL(ctx, ipKey("next", ip)),
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Printf("Login guard check failed: %v", err)
+		return 0
+	}
+
+	var wait time.Duration
+	for _, ttl := range ttls {
+		if d := ttl.Val(); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Failure records a failed attempt and applies delays or a lockout
+func (g *LoginGuard) Failure(ctx context.Context, email, ip string) {
+	acctFails, err := g.incr(ctx, accountKey("fail", email))
+	if err != nil {
+		log.Printf("Login guard failure tracking failed: %v", err)
+		return
+	}
+	ipFails, err := g.incr(ctx, ipKey("fail", ip))
+	if err != nil {
+		log.Printf("Login guard failure tracking failed: %v", err)
+		return
+	}
+
+	if acctFails >= g.policy.AccountLockout {
+		g.lock(ctx, accountKey("lock", email), LockoutEvent{Type: "account_locked", Email: email, IP: ip, Reason: "too many failed logins"})
+		g.client.Del(ctx, accountKey("fail", email))
+	} else {
+		g.delay(ctx, accountKey("next", email), acctFails)
+	}
+
+	if ipFails >= g.policy.IPLockout {
+		g.lock(ctx, ipKey("lock", ip), LockoutEvent{Type: "ip_locked", IP: ip, Reason: "too many failed logins"})
+		g.client.Del(ctx, ipKey("fail", ip))
+	} else {
+		g.delay(ctx, ipKey("next", ip), ipFails/5) // с одного IP ошибаются разные люди, наказываем мягче
+	}
+}
+
+// Success clears the account counters after a successful login
+func (g *LoginGuard) Success(ctx context.Context, email string) {
+	g.client.Del(ctx, accountKey("fail", email), accountKey("next", email))
+}
+
+// Unlock removes lockouts and counters for an account and/or IP (admin action)
+func (g *LoginGuard) Unlock(ctx context.Context, email, ip string) error {
+	var keys []string
+	if email != "" {
+		keys = append(keys, accountKey("fail", email), accountKey("next", email), accountKey("lock", email))
+	}
+	if ip != "" {
+		keys = append(keys, ipKey("fail", ip), ipKey("next", ip), ipKey("lock", ip))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return g.client.Del(ctx, keys...).Err()
+}
+
+func (g *LoginGuard) incr(ctx context.Context, key string) (int64, error) {
+	pipe := g.client.TxPipeline()
+	n := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, g.policy.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return n.Val(), nil
+}
+
+func (g *LoginGuard) delay(ctx context.Context, key string, failures int64) {
+	over := failures - g.policy.FreeAttempts
+	if over <= 0 {
+		return
+	}
+
+	d := g.policy.BaseDelay
+	for i := int64(1); i < over && d < g.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > g.policy.MaxDelay {
+		d = g.policy.MaxDelay
+	}
+
+	g.client.Set(ctx, key, 1, d)
+}
+
+func (g *LoginGuard) lock(ctx context.Context, key string, event LockoutEvent) {
+	event.Until = time.Now().Add(g.policy.LockDuration)
+
+	if err := g.client.Set(ctx, key, 1, g.policy.LockDuration).Err(); err != nil {
+		log.Printf("Login guard lock failed: %v", err)
+		return
+	}
+
+	log.Printf("Security: %s ip=%s until=%s", event.Type, event.IP, event.Until.Format(time.RFC3339))
+
+	data, _ := json.Marshal(event)
+	if err := g.client.Publish(ctx, EventsChannel, data).Err(); err != nil {
+		log.Printf("Не удалось опубликовать событие блокировки: %v", err)
+	}
+}
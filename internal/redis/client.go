@@ -1,27 +1,29 @@
 package redis
 
 import (
+	"chat-app/config"
 	"context"
 	"log"
-	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 var Client *redis.Client
 
-func Init() {
+func Init(cfg config.Redis) {
 	Client = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379", // вынести в конфиг
-		Password: "",
-		DB:       0,
+		Addr:        cfg.Addr,
+		Password:    cfg.Password,
+		DB:          cfg.DB,
+		PoolSize:    cfg.PoolSize,
+		DialTimeout: cfg.DialTimeout,
 	})
 
-	ctx, channel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, channel := context.WithTimeout(context.Background(), cfg.DialTimeout)
 	defer channel()
 
 	if err := Client.Ping(ctx).Err(); err != nil {
-		log.Fatal("Не удалось подключиться к Redis: %v", err)
+		log.Fatalf("Не удалось подключиться к Redis %s: %v", cfg.Addr, err)
 	}
 
 	log.Println("Redis подключён успешно!")
@@ -0,0 +1,983 @@
+package repository
+
+import (
+	"chat-app/internal/models"
+	"context"
+	"errors"
+	"html"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryUsers is an in-process UserRepository for tests and local tools
+type MemoryUsers struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]models.User
+}
+
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{users: make(map[uuid.UUID]models.User)}
+}
+
+func (r *MemoryUsers) Create(_ context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return ErrConflict
+		}
+	}
+	if user.UUID == uuid.Nil {
+		user.UUID = uuid.New()
+	}
+	if user.Discoverability == "" {
+		user.Discoverability = models.DiscoverableByName
+	}
+	if user.MessagePrivacy == "" {
+		user.MessagePrivacy = models.MessagesFromEveryone
+	}
+	if user.Locale == "" {
+		user.Locale = "ru"
+	}
+	now := time.Now()
+	user.CreatedAt, user.UpdatedAt = now, now
+	r.users[user.UUID] = *user
+	return nil
+}
+
+func (r *MemoryUsers) GetByUUID(_ context.Context, userUUID uuid.UUID) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[userUUID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (r *MemoryUsers) GetByEmail(_ context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryUsers) ListByUUIDs(_ context.Context, userUUIDs []uuid.UUID) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []models.User
+	for _, id := range userUUIDs {
+		if u, ok := r.users[id]; ok {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (r *MemoryUsers) Exists(ctx context.Context, userUUID uuid.UUID) (bool, error) {
+	_, err := r.GetByUUID(ctx, userUUID)
+	return err == nil, nil
+}
+
+func (r *MemoryUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	_, err := r.GetByEmail(ctx, email)
+	return err == nil, nil
+}
+
+func (r *MemoryUsers) UpdatePasswordHash(_ context.Context, userUUID uuid.UUID, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userUUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.PasswordHash = hash
+	u.UpdatedAt = time.Now()
+	r.users[userUUID] = u
+	return nil
+}
+
+func (r *MemoryUsers) SetMessagePrivacy(_ context.Context, userUUID uuid.UUID, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userUUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.MessagePrivacy = value
+	u.UpdatedAt = time.Now()
+	r.users[userUUID] = u
+	return nil
+}
+
+func (r *MemoryUsers) SetDiscoverability(_ context.Context, userUUID uuid.UUID, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userUUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Discoverability = value
+	u.UpdatedAt = time.Now()
+	r.users[userUUID] = u
+	return nil
+}
+
+func (r *MemoryUsers) UpdateProfile(_ context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[user.UUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Name, u.Surname, u.Bio, u.Locale = user.Name, user.Surname, user.Bio, user.Locale
+	u.UpdatedAt = time.Now()
+	user.UpdatedAt = u.UpdatedAt
+	r.users[user.UUID] = u
+	return nil
+}
+
+func (r *MemoryUsers) UpdateEmail(_ context.Context, userUUID uuid.UUID, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == email && u.UUID != userUUID {
+			return ErrConflict
+		}
+	}
+	u, ok := r.users[userUUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Email = email
+	u.UpdatedAt = time.Now()
+	r.users[userUUID] = u
+	return nil
+}
+
+func (r *MemoryUsers) SetAvatar(_ context.Context, userUUID uuid.UUID, updatedAt *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userUUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.AvatarUpdatedAt = updatedAt
+	u.UpdatedAt = time.Now()
+	r.users[userUUID] = u
+	return nil
+}
+
+// Search follows the Postgres rules with a substring match instead of trigrams
+func (r *MemoryUsers) Search(_ context.Context, q UserSearch) ([]models.UserHit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	query := strings.ToLower(q.Query)
+	var hits []models.UserHit
+	for _, u := range r.users {
+		if u.UUID == q.SearcherUUID || u.Discoverability == models.DiscoverableNone || slices.Contains(q.Exclude, u.UUID) {
+			continue
+		}
+
+		var score float64
+		fullName := strings.ToLower(u.Name + " " + u.Surname)
+		switch {
+		case strings.ToLower(u.Email) == query:
+			score = 1
+		case u.Discoverability != models.DiscoverableByEmail && query != "" && strings.Contains(fullName, query):
+			score = float64(len(query)) / float64(len(fullName))
+		default:
+			continue
+		}
+
+		hits = append(hits, models.UserHit{
+			UUID:       u.UUID,
+			Name:       u.DisplayName(),
+			Rank:       score,
+			SharesChat: slices.Contains(q.Known, u.UUID),
+		})
+	}
+
+	boosted := func(h models.UserHit) float64 {
+		if h.SharesChat {
+			return h.Rank + knownBoost
+		}
+		return h.Rank
+	}
+	slices.SortFunc(hits, func(a, b models.UserHit) int {
+		if d := boosted(b) - boosted(a); d != 0 {
+			if d > 0 {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.UUID.String(), b.UUID.String())
+	})
+
+	if q.Offset >= len(hits) {
+		return nil, nil
+	}
+	hits = hits[q.Offset:]
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// MemoryChats is an in-process ChatRepository
+type MemoryChats struct {
+	mu    sync.RWMutex
+	chats map[uuid.UUID]models.Chat
+}
+
+func NewMemoryChats() *MemoryChats {
+	return &MemoryChats{chats: make(map[uuid.UUID]models.Chat)}
+}
+
+func (r *MemoryChats) Create(_ context.Context, chat *models.Chat) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if chat.UUID == uuid.Nil {
+		chat.UUID = uuid.New()
+	}
+	if chat.CreatedAt.IsZero() {
+		chat.CreatedAt = time.Now()
+	}
+	chat.UpdatedAt = chat.CreatedAt
+
+	stored := *chat
+	stored.Participants = slices.Clone(chat.Participants)
+	r.chats[chat.UUID] = stored
+	return nil
+}
+
+func (r *MemoryChats) Get(_ context.Context, chatUUID uuid.UUID) (*models.Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chat, ok := r.chats[chatUUID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	chat.Participants = slices.Clone(chat.Participants)
+	return &chat, nil
+}
+
+func (r *MemoryChats) FindDirect(_ context.Context, participants []uuid.UUID) (uuid.UUID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, chat := range r.chats {
+		if chat.Type == models.ChatDirect && slices.Equal(chat.Participants, participants) {
+			return chat.UUID, nil
+		}
+	}
+	return uuid.Nil, ErrNotFound
+}
+
+func (r *MemoryChats) ListForUser(_ context.Context, userUUID uuid.UUID) ([]models.Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var chats []models.Chat
+	for _, chat := range r.chats {
+		if chat.HasParticipant(userUUID) {
+			chat.Participants = slices.Clone(chat.Participants)
+			chats = append(chats, chat)
+		}
+	}
+	slices.SortFunc(chats, func(a, b models.Chat) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return chats, nil
+}
+
+func (r *MemoryChats) IsParticipant(_ context.Context, chatUUID, userUUID uuid.UUID) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chat, ok := r.chats[chatUUID]
+	return ok && chat.HasParticipant(userUUID), nil
+}
+
+// MemoryMessages is an in-process MessageRepository
+type MemoryMessages struct {
+	mu       sync.RWMutex
+	messages []models.Message
+}
+
+func NewMemoryMessages() *MemoryMessages {
+	return &MemoryMessages{}
+}
+
+func (r *MemoryMessages) Create(_ context.Context, msg *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if msg.UUID == uuid.Nil {
+		msg.UUID = uuid.New()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+
+	// Как ON CONFLICT DO NOTHING в Postgres
+	for _, m := range r.messages {
+		if m.UUID == msg.UUID {
+			return nil
+		}
+	}
+	stored := *msg
+	stored.SenderName = "" // как в Postgres: имя не хранится
+	r.messages = append(r.messages, stored)
+	return nil
+}
+
+func (r *MemoryMessages) ListByChat(_ context.Context, chatUUID uuid.UUID) ([]models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var messages []models.Message
+	for _, m := range r.messages {
+		if m.ChatUUID == chatUUID {
+			messages = append(messages, m)
+		}
+	}
+	slices.SortStableFunc(messages, func(a, b models.Message) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return messages, nil
+}
+
+func (r *MemoryMessages) MarkRead(_ context.Context, chatUUID, readerUUID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, m := range r.messages {
+		if m.ChatUUID == chatUUID && m.SenderUUID != readerUUID {
+			r.messages[i].IsRead = true
+		}
+	}
+	return nil
+}
+
+// Search matches messages containing every word of the query, case-insensitively.
+// Без стемминга и морфологии: это замена Postgres для тестов, а не поисковик.
+func (r *MemoryMessages) Search(_ context.Context, q MessageSearch) ([]models.MessageHit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	terms := strings.Fields(strings.ToLower(q.Query))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var hits []models.MessageHit
+	for _, m := range r.messages {
+		if !slices.Contains(q.ChatUUIDs, m.ChatUUID) ||
+			(q.SenderUUID != uuid.Nil && m.SenderUUID != q.SenderUUID) ||
+			(slices.Contains(q.HideSenders, m.SenderUUID) && slices.Contains(q.HideInChats, m.ChatUUID)) ||
+			(!q.From.IsZero() && m.CreatedAt.Before(q.From)) ||
+			(!q.To.IsZero() && !m.CreatedAt.Before(q.To)) {
+			continue
+		}
+
+		content := strings.ToLower(m.Content)
+		matches := 0
+		for _, t := range terms {
+			n := strings.Count(content, t)
+			if n == 0 {
+				matches = 0
+				break
+			}
+			matches += n
+		}
+		if matches == 0 {
+			continue
+		}
+
+		hits = append(hits, models.MessageHit{
+			Message: m,
+			Rank:    float64(matches) / float64(matches+1),
+			Snippet: markTerms(m.Content, terms),
+		})
+	}
+
+	slices.SortStableFunc(hits, func(a, b models.MessageHit) int {
+		if a.Rank != b.Rank {
+			if a.Rank > b.Rank {
+				return -1
+			}
+			return 1
+		}
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	if q.Offset >= len(hits) {
+		return nil, nil
+	}
+	hits = hits[q.Offset:]
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// markTerms escapes text as HTML and wraps every occurrence of terms in <mark>,
+// как ts_headline в Postgres-реализации
+func markTerms(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		matched := 0
+		for _, t := range terms {
+			// ToLower может поменять длину в байтах, тогда подсвечивать не рискуем
+			if len(lower) == len(text) && strings.HasPrefix(lower[i:], t) && len(t) > matched {
+				matched = len(t)
+			}
+		}
+		if matched > 0 {
+			b.WriteString("<mark>" + html.EscapeString(text[i:i+matched]) + "</mark>")
+			i += matched
+			continue
+		}
+		b.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// MemoryBlocks is an in-process BlockRepository
+type MemoryBlocks struct {
+	mu     sync.RWMutex
+	blocks []models.Block
+}
+
+func NewMemoryBlocks() *MemoryBlocks {
+	return &MemoryBlocks{}
+}
+
+func (r *MemoryBlocks) Block(_ context.Context, blockerUUID, blockedUUID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, b := range r.blocks {
+		if b.BlockerUUID == blockerUUID && b.BlockedUUID == blockedUUID {
+			return nil
+		}
+	}
+	r.blocks = append(r.blocks, models.Block{BlockerUUID: blockerUUID, BlockedUUID: blockedUUID, CreatedAt: time.Now()})
+	return nil
+}
+
+func (r *MemoryBlocks) Unblock(_ context.Context, blockerUUID, blockedUUID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, b := range r.blocks {
+		if b.BlockerUUID == blockerUUID && b.BlockedUUID == blockedUUID {
+			r.blocks = slices.Delete(r.blocks, i, i+1)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryBlocks) ListBlocked(_ context.Context, blockerUUID uuid.UUID) ([]models.Block, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var blocks []models.Block
+	for _, b := range r.blocks {
+		if b.BlockerUUID == blockerUUID {
+			blocks = append(blocks, b)
+		}
+	}
+	slices.Reverse(blocks)
+	return blocks, nil
+}
+
+func (r *MemoryBlocks) ListBlockers(_ context.Context, userUUID uuid.UUID) ([]uuid.UUID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var blockers []uuid.UUID
+	for _, b := range r.blocks {
+		if b.BlockedUUID == userUUID {
+			blockers = append(blockers, b.BlockerUUID)
+		}
+	}
+	return blockers, nil
+}
+
+// MemoryContacts is an in-process ContactRepository
+type MemoryContacts struct {
+	mu       sync.RWMutex
+	requests []models.ContactRequest
+	contacts []models.Contact
+}
+
+func NewMemoryContacts() *MemoryContacts {
+	return &MemoryContacts{}
+}
+
+func (r *MemoryContacts) Request(_ context.Context, fromUUID, toUUID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, req := range r.requests {
+		if req.FromUUID == fromUUID && req.ToUUID == toUUID {
+			return ErrConflict
+		}
+	}
+	r.requests = append(r.requests, models.ContactRequest{FromUUID: fromUUID, ToUUID: toUUID, CreatedAt: time.Now()})
+	return nil
+}
+
+func (r *MemoryContacts) Accept(_ context.Context, fromUUID, toUUID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !slices.ContainsFunc(r.requests, func(req models.ContactRequest) bool {
+		return req.FromUUID == fromUUID && req.ToUUID == toUUID
+	}) {
+		return ErrNotFound
+	}
+	r.requests = slices.DeleteFunc(r.requests, func(req models.ContactRequest) bool {
+		return (req.FromUUID == fromUUID && req.ToUUID == toUUID) || (req.FromUUID == toUUID && req.ToUUID == fromUUID)
+	})
+
+	if !slices.ContainsFunc(r.contacts, func(ct models.Contact) bool {
+		return ct.UserUUID == fromUUID && ct.ContactUUID == toUUID
+	}) {
+		now := time.Now()
+		r.contacts = append(r.contacts,
+			models.Contact{UserUUID: fromUUID, ContactUUID: toUUID, CreatedAt: now},
+			models.Contact{UserUUID: toUUID, ContactUUID: fromUUID, CreatedAt: now},
+		)
+	}
+	return nil
+}
+
+func (r *MemoryContacts) DeleteRequest(_ context.Context, fromUUID, toUUID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, req := range r.requests {
+		if req.FromUUID == fromUUID && req.ToUUID == toUUID {
+			r.requests = slices.Delete(r.requests, i, i+1)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryContacts) ListIncoming(_ context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error) {
+	return r.listRequests(func(req models.ContactRequest) bool { return req.ToUUID == userUUID }), nil
+}
+
+func (r *MemoryContacts) ListOutgoing(_ context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error) {
+	return r.listRequests(func(req models.ContactRequest) bool { return req.FromUUID == userUUID }), nil
+}
+
+func (r *MemoryContacts) listRequests(match func(models.ContactRequest) bool) []models.ContactRequest {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var requests []models.ContactRequest
+	for _, req := range r.requests {
+		if match(req) {
+			requests = append(requests, req)
+		}
+	}
+	slices.Reverse(requests)
+	return requests
+}
+
+func (r *MemoryContacts) ListContacts(_ context.Context, userUUID uuid.UUID) ([]models.Contact, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var contacts []models.Contact
+	for _, ct := range r.contacts {
+		if ct.UserUUID == userUUID {
+			contacts = append(contacts, ct)
+		}
+	}
+	slices.Reverse(contacts)
+	return contacts, nil
+}
+
+func (r *MemoryContacts) Remove(_ context.Context, a, b uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(r.contacts)
+	r.contacts = slices.DeleteFunc(r.contacts, func(ct models.Contact) bool {
+		return (ct.UserUUID == a && ct.ContactUUID == b) || (ct.UserUUID == b && ct.ContactUUID == a)
+	})
+	if len(r.contacts) == n {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MemoryAccounts is an in-process AccountRepository over the other memory stores
+type MemoryAccounts struct {
+	users    *MemoryUsers
+	chats    *MemoryChats
+	messages *MemoryMessages
+	contacts *MemoryContacts
+	blocks   *MemoryBlocks
+}
+
+func NewMemoryAccounts(users *MemoryUsers, chats *MemoryChats, messages *MemoryMessages, contacts *MemoryContacts, blocks *MemoryBlocks) *MemoryAccounts {
+	return &MemoryAccounts{users: users, chats: chats, messages: messages, contacts: contacts, blocks: blocks}
+}
+
+func (r *MemoryAccounts) ScheduleDeletion(_ context.Context, userUUID uuid.UUID, at time.Time) error {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	u, ok := r.users.users[userUUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.DeletionScheduledAt = &at
+	u.UpdatedAt = time.Now()
+	r.users.users[userUUID] = u
+	return nil
+}
+
+func (r *MemoryAccounts) CancelDeletion(_ context.Context, userUUID uuid.UUID) error {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	u, ok := r.users.users[userUUID]
+	if !ok || u.DeletionScheduledAt == nil {
+		return ErrNotFound
+	}
+	u.DeletionScheduledAt = nil
+	u.UpdatedAt = time.Now()
+	r.users.users[userUUID] = u
+	return nil
+}
+
+func (r *MemoryAccounts) DueForDeletion(_ context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	r.users.mu.RLock()
+	defer r.users.mu.RUnlock()
+
+	var due []models.User
+	for _, u := range r.users.users {
+		if u.DeletionScheduledAt != nil && !u.DeletionScheduledAt.After(now) {
+			due = append(due, u)
+		}
+	}
+	slices.SortFunc(due, func(a, b models.User) int {
+		return a.DeletionScheduledAt.Compare(*b.DeletionScheduledAt)
+	})
+
+	ids := make([]uuid.UUID, 0, min(len(due), limit))
+	for _, u := range due[:min(len(due), limit)] {
+		ids = append(ids, u.UUID)
+	}
+	return ids, nil
+}
+
+func (r *MemoryAccounts) Purge(_ context.Context, userUUID uuid.UUID, now time.Time) error {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	u, ok := r.users.users[userUUID]
+	if !ok || u.DeletionScheduledAt == nil || u.DeletionScheduledAt.After(now) {
+		return ErrNotFound
+	}
+
+	r.messages.mu.Lock()
+	for i := range r.messages.messages {
+		if r.messages.messages[i].SenderUUID == userUUID {
+			r.messages.messages[i].SenderUUID = models.DeletedUserUUID
+		}
+	}
+	r.messages.mu.Unlock()
+
+	r.chats.mu.Lock()
+	for id, chat := range r.chats.chats {
+		if chat.HasParticipant(userUUID) {
+			chat.Participants = slices.DeleteFunc(slices.Clone(chat.Participants), func(p uuid.UUID) bool { return p == userUUID })
+			chat.UpdatedAt = time.Now()
+			r.chats.chats[id] = chat
+		}
+	}
+	r.chats.mu.Unlock()
+
+	// То, что в Postgres удаляется каскадом
+	r.contacts.mu.Lock()
+	r.contacts.contacts = slices.DeleteFunc(r.contacts.contacts, func(ct models.Contact) bool {
+		return ct.UserUUID == userUUID || ct.ContactUUID == userUUID
+	})
+	r.contacts.requests = slices.DeleteFunc(r.contacts.requests, func(req models.ContactRequest) bool {
+		return req.FromUUID == userUUID || req.ToUUID == userUUID
+	})
+	r.contacts.mu.Unlock()
+
+	r.blocks.mu.Lock()
+	r.blocks.blocks = slices.DeleteFunc(r.blocks.blocks, func(b models.Block) bool {
+		return b.BlockerUUID == userUUID || b.BlockedUUID == userUUID
+	})
+	r.blocks.mu.Unlock()
+
+	delete(r.users.users, userUUID)
+	return nil
+}
+
+// MemoryImports writes into the in-memory chats and messages
+type MemoryImports struct {
+	chats    *MemoryChats
+	messages *MemoryMessages
+}
+
+func NewMemoryImports(chats *MemoryChats, messages *MemoryMessages) *MemoryImports {
+	return &MemoryImports{chats: chats, messages: messages}
+}
+
+func (r *MemoryImports) Import(_ context.Context, chat *models.Chat, messages []models.Message) (int, error) {
+	r.chats.mu.Lock()
+	defer r.chats.mu.Unlock()
+	r.messages.mu.Lock()
+	defer r.messages.mu.Unlock()
+
+	if chat.UUID == uuid.Nil {
+		chat.UUID = uuid.New()
+	}
+	if chat.CreatedAt.IsZero() {
+		chat.CreatedAt = time.Now()
+	}
+	if chat.UpdatedAt.IsZero() {
+		chat.UpdatedAt = chat.CreatedAt
+	}
+
+	stored, ok := r.chats.chats[chat.UUID]
+	if !ok {
+		stored = *chat
+		stored.Participants = slices.Clone(chat.Participants)
+	}
+
+	existing := make(map[uuid.UUID]struct{}, len(r.messages.messages))
+	for _, m := range r.messages.messages {
+		existing[m.UUID] = struct{}{}
+	}
+
+	inserted := 0
+	for _, m := range messages {
+		if m.CreatedAt.After(stored.UpdatedAt) {
+			stored.UpdatedAt = m.CreatedAt
+		}
+		if _, dup := existing[m.UUID]; dup {
+			continue
+		}
+		existing[m.UUID] = struct{}{}
+
+		m.ChatUUID = chat.UUID
+		m.SenderName = ""
+		r.messages.messages = append(r.messages.messages, m)
+		inserted++
+	}
+
+	r.chats.chats[chat.UUID] = stored
+	return inserted, nil
+}
+
+// MemoryTwoFactor is an in-process TwoFactorRepository; totp_enabled живёт в
+// MemoryUsers, как колонка в users, чтобы вход по паролю видел включённую 2FA
+type MemoryTwoFactor struct {
+	users *MemoryUsers
+	mu    sync.Mutex
+	state map[uuid.UUID]models.TwoFactor
+	codes map[uuid.UUID]map[string]bool // хэш кода -> уже использован
+}
+
+func NewMemoryTwoFactor(users *MemoryUsers) *MemoryTwoFactor {
+	return &MemoryTwoFactor{
+		users: users,
+		state: make(map[uuid.UUID]models.TwoFactor),
+		codes: make(map[uuid.UUID]map[string]bool),
+	}
+}
+
+// setEnabled mirrors the flag into the user record
+func (r *MemoryTwoFactor) setEnabled(userUUID uuid.UUID, enabled bool) error {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	u, ok := r.users.users[userUUID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.TOTPEnabled = enabled
+	u.UpdatedAt = time.Now()
+	r.users.users[userUUID] = u
+	return nil
+}
+
+func (r *MemoryTwoFactor) Get(ctx context.Context, userUUID uuid.UUID) (*models.TwoFactor, error) {
+	u, err := r.users.GetByUUID(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tf := r.state[userUUID]
+	tf.Enabled = u.TOTPEnabled
+	return &tf, nil
+}
+
+func (r *MemoryTwoFactor) Enroll(_ context.Context, userUUID uuid.UUID, secret string, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.setEnabled(userUUID, false); err != nil {
+		return err
+	}
+	r.state[userUUID] = models.TwoFactor{Secret: secret}
+	codes := make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	r.codes[userUUID] = codes
+	return nil
+}
+
+func (r *MemoryTwoFactor) Enable(_ context.Context, userUUID uuid.UUID, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tf, ok := r.state[userUUID]
+	if !ok || tf.Secret == "" {
+		return ErrNotFound
+	}
+	if err := r.setEnabled(userUUID, true); err != nil {
+		return err
+	}
+	tf.LastUsedStep = step
+	r.state[userUUID] = tf
+	return nil
+}
+
+func (r *MemoryTwoFactor) UseStep(_ context.Context, userUUID uuid.UUID, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tf, ok := r.state[userUUID]
+	if !ok || tf.LastUsedStep >= step {
+		return false, nil
+	}
+	tf.LastUsedStep = step
+	r.state[userUUID] = tf
+	return true, nil
+}
+
+func (r *MemoryTwoFactor) UseRecoveryCode(_ context.Context, userUUID uuid.UUID, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used, ok := r.codes[userUUID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.codes[userUUID][codeHash] = true
+	return true, nil
+}
+
+func (r *MemoryTwoFactor) HasRecoveryCode(_ context.Context, userUUID uuid.UUID, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used, ok := r.codes[userUUID][codeHash]
+	return ok && !used, nil
+}
+
+func (r *MemoryTwoFactor) Disable(_ context.Context, userUUID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.setEnabled(userUUID, false); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	delete(r.state, userUUID)
+	delete(r.codes, userUUID)
+	return nil
+}
+
+// MemoryIdentities is an in-process IdentityRepository over MemoryUsers
+type MemoryIdentities struct {
+	users *MemoryUsers
+	mu    sync.Mutex
+	links map[[2]string]uuid.UUID // issuer, subject -> пользователь
+}
+
+func NewMemoryIdentities(users *MemoryUsers) *MemoryIdentities {
+	return &MemoryIdentities{users: users, links: make(map[[2]string]uuid.UUID)}
+}
+
+func (r *MemoryIdentities) Login(ctx context.Context, identity models.Identity, newUser *models.User, linkByEmail bool) (*models.User, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := [2]string{identity.Issuer, identity.Subject}
+	if userUUID, ok := r.links[key]; ok {
+		user, err := r.users.GetByUUID(ctx, userUUID)
+		return user, false, err
+	}
+
+	user, err := r.users.GetByEmail(ctx, newUser.Email)
+	created := false
+	switch {
+	case err == nil:
+		if !linkByEmail {
+			return nil, false, ErrConflict
+		}
+	case errors.Is(err, ErrNotFound):
+		user = &models.User{Name: newUser.Name, Surname: newUser.Surname, Email: newUser.Email}
+		if err := r.users.Create(ctx, user); err != nil {
+			return nil, false, err
+		}
+		created = true
+	default:
+		return nil, false, err
+	}
+
+	r.links[key] = user.UUID
+	return user, created, nil
+}
+
+func (r *MemoryIdentities) Find(ctx context.Context, issuer, subject string) (*models.User, error) {
+	r.mu.Lock()
+	userUUID, ok := r.links[[2]string{issuer, subject}]
+	r.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.users.GetByUUID(ctx, userUUID)
+}
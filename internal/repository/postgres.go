@@ -0,0 +1,1124 @@
+package repository
+
+import (
+	"chat-app/database"
+	"chat-app/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// PostgresUsers implements UserRepository on the users table
+type PostgresUsers struct {
+	db *database.Database
+}
+
+func NewPostgresUsers(db *database.Database) *PostgresUsers {
+	return &PostgresUsers{db: db}
+}
+
+const userColumns = `uuid, COALESCE(name, ''), COALESCE(surname, ''), email, password_hash, totp_enabled, is_admin, discoverability, message_privacy, bio, locale, avatar_updated_at, deletion_scheduled_at, created_at, updated_at`
+
+func scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
+	var u models.User
+	err := row.Scan(&u.UUID, &u.Name, &u.Surname, &u.Email, &u.PasswordHash, &u.TOTPEnabled, &u.IsAdmin, &u.Discoverability, &u.MessagePrivacy, &u.Bio, &u.Locale, &u.AvatarUpdatedAt, &u.DeletionScheduledAt, &u.CreatedAt, &u.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// isUniqueViolation reports a Postgres unique_violation (23505)
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && state.SQLState() == "23505"
+}
+
+func (r *PostgresUsers) Create(ctx context.Context, user *models.User) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	err := r.db.Pool.QueryRow(ctx, `
+INSERT INTO users (name, surname, email, password_hash)
+VALUES ($1, $2, $3, $4)
+RETURNING uuid, discoverability, message_privacy, locale, created_at, updated_at`,
+		user.Name, user.Surname, user.Email, user.PasswordHash,
+	).Scan(&user.UUID, &user.Discoverability, &user.MessagePrivacy, &user.Locale, &user.CreatedAt, &user.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (r *PostgresUsers) GetByUUID(ctx context.Context, userUUID uuid.UUID) (*models.User, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	return scanUser(r.db.Pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE uuid = $1`, userUUID))
+}
+
+func (r *PostgresUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	return scanUser(r.db.Pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, email))
+}
+
+func (r *PostgresUsers) ListByUUIDs(ctx context.Context, userUUIDs []uuid.UUID) ([]models.User, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.Pool.Query(ctx, `SELECT `+userColumns+` FROM users WHERE uuid = ANY($1)`, userUUIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+func (r *PostgresUsers) Exists(ctx context.Context, userUUID uuid.UUID) (bool, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := r.db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE uuid = $1)`, userUUID).Scan(&exists)
+	return exists, err
+}
+
+func (r *PostgresUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := r.db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`, email).Scan(&exists)
+	return exists, err
+}
+
+func (r *PostgresUsers) UpdatePasswordHash(ctx context.Context, userUUID uuid.UUID, hash string) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users
+SET password_hash = $1, updated_at = NOW()
+WHERE uuid = $2`, hash, userUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUsers) SetDiscoverability(ctx context.Context, userUUID uuid.UUID, value string) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users SET discoverability = $1, updated_at = NOW()
+WHERE uuid = $2`, value, userUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUsers) SetMessagePrivacy(ctx context.Context, userUUID uuid.UUID, value string) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users SET message_privacy = $1, updated_at = NOW()
+WHERE uuid = $2`, value, userUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUsers) UpdateProfile(ctx context.Context, user *models.User) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	err := r.db.Pool.QueryRow(ctx, `
+UPDATE users
+SET name = $1, surname = $2, bio = $3, locale = $4, updated_at = NOW()
+WHERE uuid = $5
+RETURNING updated_at`, user.Name, user.Surname, user.Bio, user.Locale, user.UUID).Scan(&user.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *PostgresUsers) UpdateEmail(ctx context.Context, userUUID uuid.UUID, email string) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users SET email = $1, updated_at = NOW()
+WHERE uuid = $2`, email, userUUID)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUsers) SetAvatar(ctx context.Context, userUUID uuid.UUID, updatedAt *time.Time) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users SET avatar_updated_at = $1, updated_at = NOW()
+WHERE uuid = $2`, updatedAt, userUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// knownBoost lifts people the searcher already talks to above closer strangers
+const knownBoost = 0.5
+
+// Search matches names by trigram word similarity and emails only exactly, so
+// перебором подстрок нельзя вытащить чужие адреса. Выражение имени совпадает
+// с idx_users_full_name_trgm, иначе индекс не используется.
+func (r *PostgresUsers) Search(ctx context.Context, q UserSearch) ([]models.UserHit, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	known, exclude := q.Known, q.Exclude
+	if known == nil {
+		known = []uuid.UUID{}
+	}
+	if exclude == nil {
+		exclude = []uuid.UUID{}
+	}
+
+	rows, err := r.db.Pool.Query(ctx, `
+SELECT uuid, COALESCE(name, ''), COALESCE(surname, ''), score, known
+FROM (
+    SELECT uuid, name, surname,
+           CASE WHEN email = $1 THEN 1.0::float8
+                ELSE word_similarity($1, lower(COALESCE(name, '') || ' ' || COALESCE(surname, '')))::float8
+           END AS score,
+           uuid = ANY($3) AS known
+    FROM users
+    WHERE uuid <> $2
+    AND NOT (uuid = ANY($7))
+    AND discoverability <> 'none'
+    AND (email = $1
+         OR (discoverability = 'name'
+             AND $1 <% lower(COALESCE(name, '') || ' ' || COALESCE(surname, ''))))
+) found
+ORDER BY score + CASE WHEN known THEN $6::float8 ELSE 0 END DESC, uuid
+LIMIT $4 OFFSET $5`, strings.ToLower(q.Query), q.SearcherUUID, known, q.Limit, q.Offset, knownBoost, exclude)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []models.UserHit
+	for rows.Next() {
+		var u models.User
+		var hit models.UserHit
+		if err := rows.Scan(&u.UUID, &u.Name, &u.Surname, &hit.Rank, &hit.SharesChat); err != nil {
+			return nil, err
+		}
+		hit.UUID, hit.Name = u.UUID, u.DisplayName()
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// PostgresChats implements ChatRepository on the chats table.
+// Участники лежат JSON-массивом строк, поиск по ним — через оператор jsonb ?.
+type PostgresChats struct {
+	db *database.Database
+}
+
+func NewPostgresChats(db *database.Database) *PostgresChats {
+	return &PostgresChats{db: db}
+}
+
+func (r *PostgresChats) Create(ctx context.Context, chat *models.Chat) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	if chat.UUID == uuid.Nil {
+		chat.UUID = uuid.New()
+	}
+	if chat.CreatedAt.IsZero() {
+		chat.CreatedAt = time.Now()
+	}
+	chat.UpdatedAt = chat.CreatedAt
+
+	participants, err := json.Marshal(chat.Participants)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Pool.Exec(ctx, `
+INSERT INTO chats (uuid, type, name, participants, creator_uuid, created_at, updated_at)
+VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $6)`,
+		chat.UUID, chat.Type, chat.Name, string(participants), chat.CreatorUUID, chat.CreatedAt)
+	return err
+}
+
+func (r *PostgresChats) Get(ctx context.Context, chatUUID uuid.UUID) (*models.Chat, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	var chat models.Chat
+	var participants string
+	var creator *uuid.UUID
+	err := r.db.Pool.QueryRow(ctx, `
+SELECT uuid, type, COALESCE(name, ''), participants, creator_uuid, created_at, updated_at
+FROM chats
+WHERE uuid = $1`, chatUUID).Scan(&chat.UUID, &chat.Type, &chat.Name, &participants, &creator, &chat.CreatedAt, &chat.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if creator != nil {
+		chat.CreatorUUID = *creator
+	}
+	if err := json.Unmarshal([]byte(participants), &chat.Participants); err != nil {
+		return nil, fmt.Errorf("chat %s participants: %w", chatUUID, err)
+	}
+	return &chat, nil
+}
+
+func (r *PostgresChats) FindDirect(ctx context.Context, participants []uuid.UUID) (uuid.UUID, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	data, err := json.Marshal(participants)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var chatUUID uuid.UUID
+	err = r.db.Pool.QueryRow(ctx, `
+SELECT uuid FROM chats
+WHERE type = 'direct'
+AND participants = $1`, string(data)).Scan(&chatUUID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrNotFound
+	}
+	return chatUUID, err
+}
+
+func (r *PostgresChats) ListForUser(ctx context.Context, userUUID uuid.UUID) ([]models.Chat, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.Pool.Query(ctx, `
+SELECT uuid, type, COALESCE(name, ''), participants, created_at
+FROM chats
+WHERE participants::jsonb ? $1
+ORDER BY created_at DESC`, userUUID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []models.Chat
+	for rows.Next() {
+		var chat models.Chat
+		var participants string
+		if err := rows.Scan(&chat.UUID, &chat.Type, &chat.Name, &participants, &chat.CreatedAt); err != nil {
+			return nil, err
+		}
+		// Битый JSON участников не должен прятать остальные чаты
+		json.Unmarshal([]byte(participants), &chat.Participants)
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+func (r *PostgresChats) IsParticipant(ctx context.Context, chatUUID, userUUID uuid.UUID) (bool, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := r.db.Pool.QueryRow(ctx, `
+SELECT EXISTS(SELECT 1 FROM chats WHERE uuid = $1 AND participants::jsonb ? $2)`,
+		chatUUID, userUUID.String()).Scan(&exists)
+	return exists, err
+}
+
+// PostgresMessages implements MessageRepository on the messages table
+type PostgresMessages struct {
+	db *database.Database
+}
+
+func NewPostgresMessages(db *database.Database) *PostgresMessages {
+	return &PostgresMessages{db: db}
+}
+
+func (r *PostgresMessages) Create(ctx context.Context, msg *models.Message) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	if msg.UUID == uuid.Nil {
+		msg.UUID = uuid.New()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+
+	conn, err := r.db.Prepared(ctx, database.StmtInsertMessage)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, database.StmtInsertMessage,
+		msg.UUID, msg.ChatUUID.String(), msg.SenderUUID, msg.Content, msg.CreatedAt, msg.IsRead)
+	return err
+}
+
+func (r *PostgresMessages) ListByChat(ctx context.Context, chatUUID uuid.UUID) ([]models.Message, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	conn, err := r.db.Prepared(ctx, database.StmtChatHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, database.StmtChatHistory, chatUUID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		m := models.Message{ChatUUID: chatUUID}
+		if err := rows.Scan(&m.UUID, &m.SenderUUID, &m.Content, &m.CreatedAt, &m.IsRead); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (r *PostgresMessages) MarkRead(ctx context.Context, chatUUID, readerUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.Pool.Exec(ctx, `
+UPDATE messages
+SET is_read = true, updated_at = NOW()
+WHERE chat_uuid = $1
+AND sender_uuid != $2
+AND is_read = false`, chatUUID.String(), readerUUID)
+	return err
+}
+
+// searchHeadline wraps matches in <mark>. Текст экранируем до ts_headline: парсер
+// видит &lt; как сущность и не индексирует её, а клиенту можно вставлять фрагмент как HTML.
+const searchHeadline = `ts_headline('chat_search',
+    replace(replace(replace(content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+    query,
+    'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "')`
+
+func (r *PostgresMessages) Search(ctx context.Context, q MessageSearch) ([]models.MessageHit, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	if len(q.ChatUUIDs) == 0 {
+		return nil, nil
+	}
+
+	chatUUIDs := make([]string, len(q.ChatUUIDs))
+	for i, id := range q.ChatUUIDs {
+		chatUUIDs[i] = id.String()
+	}
+
+	args := []any{q.Query, chatUUIDs}
+	where := `m.search_vector @@ q.query AND m.chat_uuid = ANY($2)`
+	if q.SenderUUID != uuid.Nil {
+		args = append(args, q.SenderUUID)
+		where += fmt.Sprintf(" AND m.sender_uuid = $%d", len(args))
+	}
+	if len(q.HideSenders) > 0 && len(q.HideInChats) > 0 {
+		hideIn := make([]string, len(q.HideInChats))
+		for i, id := range q.HideInChats {
+			hideIn[i] = id.String()
+		}
+		args = append(args, q.HideSenders, hideIn)
+		where += fmt.Sprintf(" AND NOT (m.sender_uuid = ANY($%d) AND m.chat_uuid = ANY($%d))", len(args)-1, len(args))
+	}
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		where += fmt.Sprintf(" AND m.created_at >= $%d", len(args))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		where += fmt.Sprintf(" AND m.created_at < $%d", len(args))
+	}
+	args = append(args, q.Limit, q.Offset)
+
+	// Фрагменты строим во внешнем запросе, только для отданной страницы: ts_headline дорогой
+	rows, err := r.db.Pool.Query(ctx, fmt.Sprintf(`
+SELECT uuid, chat_uuid, sender_uuid, content, created_at, is_read, rank, %s
+FROM (
+    SELECT m.uuid, m.chat_uuid, m.sender_uuid, m.content, m.created_at, m.is_read,
+           ts_rank_cd(m.search_vector, q.query, 32) AS rank, q.query
+    FROM messages m, websearch_to_tsquery('chat_search', $1) AS q(query)
+    WHERE %s
+    ORDER BY rank DESC, m.created_at DESC
+    LIMIT $%d OFFSET $%d
+) page
+ORDER BY rank DESC, created_at DESC`, searchHeadline, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []models.MessageHit
+	for rows.Next() {
+		var h models.MessageHit
+		var chatUUID string
+		if err := rows.Scan(&h.UUID, &chatUUID, &h.SenderUUID, &h.Content,
+			&h.CreatedAt, &h.IsRead, &h.Rank, &h.Snippet); err != nil {
+			return nil, err
+		}
+		h.ChatUUID, _ = uuid.Parse(chatUUID)
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// PostgresBlocks implements BlockRepository on the user_blocks table
+type PostgresBlocks struct {
+	db *database.Database
+}
+
+func NewPostgresBlocks(db *database.Database) *PostgresBlocks {
+	return &PostgresBlocks{db: db}
+}
+
+func (r *PostgresBlocks) Block(ctx context.Context, blockerUUID, blockedUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.Pool.Exec(ctx, `
+INSERT INTO user_blocks (blocker_uuid, blocked_uuid)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING`, blockerUUID, blockedUUID)
+	return err
+}
+
+func (r *PostgresBlocks) Unblock(ctx context.Context, blockerUUID, blockedUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+DELETE FROM user_blocks WHERE blocker_uuid = $1 AND blocked_uuid = $2`, blockerUUID, blockedUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresBlocks) ListBlocked(ctx context.Context, blockerUUID uuid.UUID) ([]models.Block, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.Pool.Query(ctx, `
+SELECT blocked_uuid, created_at
+FROM user_blocks
+WHERE blocker_uuid = $1
+ORDER BY created_at DESC`, blockerUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []models.Block
+	for rows.Next() {
+		b := models.Block{BlockerUUID: blockerUUID}
+		if err := rows.Scan(&b.BlockedUUID, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+func (r *PostgresBlocks) ListBlockers(ctx context.Context, userUUID uuid.UUID) ([]uuid.UUID, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.Pool.Query(ctx, `SELECT blocker_uuid FROM user_blocks WHERE blocked_uuid = $1`, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// PostgresContacts implements ContactRepository on the contacts and contact_requests tables
+type PostgresContacts struct {
+	db *database.Database
+}
+
+func NewPostgresContacts(db *database.Database) *PostgresContacts {
+	return &PostgresContacts{db: db}
+}
+
+func (r *PostgresContacts) Request(ctx context.Context, fromUUID, toUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+INSERT INTO contact_requests (from_uuid, to_uuid)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING`, fromUUID, toUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *PostgresContacts) Accept(ctx context.Context, fromUUID, toUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db.Pool, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+DELETE FROM contact_requests WHERE from_uuid = $1 AND to_uuid = $2`, fromUUID, toUUID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+
+		// Встречную заявку тоже убираем: после принятия она не нужна
+		if _, err := tx.Exec(ctx, `
+DELETE FROM contact_requests WHERE from_uuid = $2 AND to_uuid = $1`, fromUUID, toUUID); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `
+INSERT INTO contacts (user_uuid, contact_uuid)
+VALUES ($1, $2), ($2, $1)
+ON CONFLICT DO NOTHING`, fromUUID, toUUID)
+		return err
+	})
+}
+
+func (r *PostgresContacts) DeleteRequest(ctx context.Context, fromUUID, toUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+DELETE FROM contact_requests WHERE from_uuid = $1 AND to_uuid = $2`, fromUUID, toUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresContacts) ListIncoming(ctx context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error) {
+	return r.listRequests(ctx, `WHERE to_uuid = $1`, userUUID)
+}
+
+func (r *PostgresContacts) ListOutgoing(ctx context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error) {
+	return r.listRequests(ctx, `WHERE from_uuid = $1`, userUUID)
+}
+
+func (r *PostgresContacts) listRequests(ctx context.Context, where string, userUUID uuid.UUID) ([]models.ContactRequest, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.Pool.Query(ctx, `
+SELECT from_uuid, to_uuid, created_at
+FROM contact_requests
+`+where+`
+ORDER BY created_at DESC`, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.ContactRequest
+	for rows.Next() {
+		var req models.ContactRequest
+		if err := rows.Scan(&req.FromUUID, &req.ToUUID, &req.CreatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+func (r *PostgresContacts) ListContacts(ctx context.Context, userUUID uuid.UUID) ([]models.Contact, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.Pool.Query(ctx, `
+SELECT contact_uuid, created_at
+FROM contacts
+WHERE user_uuid = $1
+ORDER BY created_at DESC`, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []models.Contact
+	for rows.Next() {
+		ct := models.Contact{UserUUID: userUUID}
+		if err := rows.Scan(&ct.ContactUUID, &ct.CreatedAt); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, ct)
+	}
+	return contacts, rows.Err()
+}
+
+func (r *PostgresContacts) Remove(ctx context.Context, a, b uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+DELETE FROM contacts
+WHERE (user_uuid = $1 AND contact_uuid = $2) OR (user_uuid = $2 AND contact_uuid = $1)`, a, b)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PostgresAccounts implements AccountRepository
+type PostgresAccounts struct {
+	db *database.Database
+}
+
+func NewPostgresAccounts(db *database.Database) *PostgresAccounts {
+	return &PostgresAccounts{db: db}
+}
+
+func (r *PostgresAccounts) ScheduleDeletion(ctx context.Context, userUUID uuid.UUID, at time.Time) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users SET deletion_scheduled_at = $1, updated_at = NOW()
+WHERE uuid = $2`, at, userUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresAccounts) CancelDeletion(ctx context.Context, userUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users SET deletion_scheduled_at = NULL, updated_at = NOW()
+WHERE uuid = $1 AND deletion_scheduled_at IS NOT NULL`, userUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresAccounts) DueForDeletion(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.Pool.Query(ctx, `
+SELECT uuid FROM users
+WHERE deletion_scheduled_at <= $1
+ORDER BY deletion_scheduled_at
+LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+func (r *PostgresAccounts) Purge(ctx context.Context, userUUID uuid.UUID, now time.Time) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db.Pool, func(tx pgx.Tx) error {
+		// Блокировка строки: отмена удаления и второй инстанс ждут, пока мы закончим
+		var locked uuid.UUID
+		err := tx.QueryRow(ctx, `
+SELECT uuid FROM users
+WHERE uuid = $1 AND deletion_scheduled_at <= $2
+FOR UPDATE`, userUUID, now).Scan(&locked)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `
+UPDATE messages SET sender_uuid = $2 WHERE sender_uuid = $1`, userUUID, models.DeletedUserUUID); err != nil {
+			return err
+		}
+
+		// participants — JSON-массив строк, порядок остальных участников сохраняем
+		if _, err := tx.Exec(ctx, `
+UPDATE chats
+SET participants = (
+        SELECT COALESCE(jsonb_agg(p ORDER BY n), '[]'::jsonb)::text
+        FROM jsonb_array_elements_text(participants::jsonb) WITH ORDINALITY AS t(p, n)
+        WHERE p <> $1
+    ),
+    updated_at = NOW()
+WHERE participants::jsonb ? $1`, userUUID.String()); err != nil {
+			return err
+		}
+
+		// Контакты, заявки, блокировки, 2FA и привязки SSO удалятся каскадом
+		_, err = tx.Exec(ctx, `DELETE FROM users WHERE uuid = $1`, userUUID)
+		return err
+	})
+}
+
+type PostgresImports struct {
+	db *database.Database
+}
+
+func NewPostgresImports(db *database.Database) *PostgresImports {
+	return &PostgresImports{db: db}
+}
+
+// importBatch bounds how many inserts go to Postgres in one round trip
+const importBatch = 1000
+
+// Import runs without the per-query timeout: большой архив пишется дольше,
+// срок задаёт вызывающий через ctx
+func (r *PostgresImports) Import(ctx context.Context, chat *models.Chat, messages []models.Message) (int, error) {
+	if chat.UUID == uuid.Nil {
+		chat.UUID = uuid.New()
+	}
+	if chat.CreatedAt.IsZero() {
+		chat.CreatedAt = time.Now()
+	}
+	if chat.UpdatedAt.IsZero() {
+		chat.UpdatedAt = chat.CreatedAt
+	}
+
+	participants, err := json.Marshal(chat.Participants)
+	if err != nil {
+		return 0, err
+	}
+
+	inserted := 0
+	err = pgx.BeginFunc(ctx, r.db.Pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+INSERT INTO chats (uuid, type, name, participants, creator_uuid, created_at, updated_at)
+VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)
+ON CONFLICT (uuid) DO NOTHING`,
+			chat.UUID, chat.Type, chat.Name, string(participants), chat.CreatorUUID, chat.CreatedAt, chat.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		var latest time.Time
+		for start := 0; start < len(messages); start += importBatch {
+			end := min(start+importBatch, len(messages))
+
+			b := &pgx.Batch{}
+			for _, m := range messages[start:end] {
+				b.Queue(`
+INSERT INTO messages (uuid, chat_uuid, sender_uuid, content, created_at, is_read)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (uuid) DO NOTHING`,
+					m.UUID, chat.UUID.String(), m.SenderUUID, m.Content, m.CreatedAt, m.IsRead)
+				if m.CreatedAt.After(latest) {
+					latest = m.CreatedAt
+				}
+			}
+
+			results := tx.SendBatch(ctx, b)
+			for range messages[start:end] {
+				tag, err := results.Exec()
+				if err != nil {
+					results.Close()
+					return err
+				}
+				inserted += int(tag.RowsAffected())
+			}
+			if err := results.Close(); err != nil {
+				return err
+			}
+		}
+
+		// Чат с импортированной историей поднимается в списке, как после нового сообщения
+		if !latest.IsZero() {
+			_, err = tx.Exec(ctx, `
+UPDATE chats SET updated_at = GREATEST(updated_at, $2)
+WHERE uuid = $1`, chat.UUID, latest)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// PostgresTwoFactor implements TwoFactorRepository on users and user_recovery_codes
+type PostgresTwoFactor struct {
+	db *database.Database
+}
+
+func NewPostgresTwoFactor(db *database.Database) *PostgresTwoFactor {
+	return &PostgresTwoFactor{db: db}
+}
+
+func (r *PostgresTwoFactor) Get(ctx context.Context, userUUID uuid.UUID) (*models.TwoFactor, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	var tf models.TwoFactor
+	err := r.db.Pool.QueryRow(ctx, `
+SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_used_step
+FROM users WHERE uuid = $1`, userUUID).Scan(&tf.Secret, &tf.Enabled, &tf.LastUsedStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+func (r *PostgresTwoFactor) Enroll(ctx context.Context, userUUID uuid.UUID, secret string, codeHashes []string) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db.Pool, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+UPDATE users
+SET totp_secret = $1, totp_enabled = false, totp_last_used_step = 0, updated_at = NOW()
+WHERE uuid = $2`, secret, userUUID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_uuid = $1`, userUUID); err != nil {
+			return err
+		}
+		for _, hash := range codeHashes {
+			if _, err := tx.Exec(ctx, `INSERT INTO user_recovery_codes (user_uuid, code_hash) VALUES ($1, $2)`,
+				userUUID, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PostgresTwoFactor) Enable(ctx context.Context, userUUID uuid.UUID, step int64) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users
+SET totp_enabled = true, totp_last_used_step = $1, updated_at = NOW()
+WHERE uuid = $2 AND totp_secret IS NOT NULL`, step, userUUID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UseStep is a single conditional UPDATE, so two parallel requests with the same code can't both pass
+func (r *PostgresTwoFactor) UseStep(ctx context.Context, userUUID uuid.UUID, step int64) (bool, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE users SET totp_last_used_step = $1
+WHERE uuid = $2 AND totp_last_used_step < $1`, step, userUUID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *PostgresTwoFactor) UseRecoveryCode(ctx context.Context, userUUID uuid.UUID, codeHash string) (bool, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.Pool.Exec(ctx, `
+UPDATE user_recovery_codes SET used_at = NOW()
+WHERE user_uuid = $1 AND code_hash = $2 AND used_at IS NULL`, userUUID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *PostgresTwoFactor) HasRecoveryCode(ctx context.Context, userUUID uuid.UUID, codeHash string) (bool, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := r.db.Pool.QueryRow(ctx, `
+SELECT EXISTS(SELECT 1 FROM user_recovery_codes WHERE user_uuid = $1 AND code_hash = $2 AND used_at IS NULL)`,
+		userUUID, codeHash).Scan(&exists)
+	return exists, err
+}
+
+func (r *PostgresTwoFactor) Disable(ctx context.Context, userUUID uuid.UUID) error {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db.Pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+UPDATE users
+SET totp_secret = NULL, totp_enabled = false, totp_last_used_step = 0, updated_at = NOW()
+WHERE uuid = $1`, userUUID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_uuid = $1`, userUUID)
+		return err
+	})
+}
+
+// PostgresIdentities implements IdentityRepository on user_identities
+type PostgresIdentities struct {
+	db *database.Database
+}
+
+func NewPostgresIdentities(db *database.Database) *PostgresIdentities {
+	return &PostgresIdentities{db: db}
+}
+
+func (r *PostgresIdentities) Login(ctx context.Context, identity models.Identity, newUser *models.User, linkByEmail bool) (*models.User, bool, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	var user *models.User
+	created := false
+	err := pgx.BeginFunc(ctx, r.db.Pool, func(tx pgx.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(ctx, `
+SELECT `+userColumns+` FROM users
+WHERE uuid = (SELECT user_uuid FROM user_identities WHERE issuer = $1 AND subject = $2)`,
+			identity.Issuer, identity.Subject))
+		switch {
+		case err == nil:
+			_, err = tx.Exec(ctx, `
+UPDATE user_identities SET last_login = NOW(), email = $3
+WHERE issuer = $1 AND subject = $2`, identity.Issuer, identity.Subject, identity.Email)
+			return err
+		case !errors.Is(err, ErrNotFound):
+			return err
+		}
+
+		user, err = scanUser(tx.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, newUser.Email))
+		switch {
+		case err == nil:
+			if !linkByEmail {
+				return ErrConflict
+			}
+		case errors.Is(err, ErrNotFound):
+			// Пустой password_hash никогда не совпадёт в bcrypt, вход по паролю невозможен
+			user, err = scanUser(tx.QueryRow(ctx, `
+INSERT INTO users (name, surname, email, password_hash)
+VALUES ($1, $2, $3, '')
+RETURNING `+userColumns, newUser.Name, newUser.Surname, newUser.Email))
+			if err != nil {
+				return err
+			}
+			created = true
+		default:
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `
+INSERT INTO user_identities (user_uuid, issuer, subject, email)
+VALUES ($1, $2, $3, $4)`, user.UUID, identity.Issuer, identity.Subject, identity.Email)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return user, created, nil
+}
+
+func (r *PostgresIdentities) Find(ctx context.Context, issuer, subject string) (*models.User, error) {
+	ctx, cancel := r.db.WithTimeout(ctx)
+	defer cancel()
+
+	return scanUser(r.db.Pool.QueryRow(ctx, `
+SELECT `+userColumns+` FROM users
+WHERE uuid = (SELECT user_uuid FROM user_identities WHERE issuer = $1 AND subject = $2)`, issuer, subject))
+}
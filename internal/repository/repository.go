@@ -0,0 +1,186 @@
+// Package repository hides SQL behind small interfaces so handlers and the hub
+// can be built with either Postgres or in-memory implementations.
+package repository
+
+import (
+	"chat-app/internal/models"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+)
+
+// UserRepository stores accounts
+type UserRepository interface {
+	Create(ctx context.Context, user *models.User) error
+	GetByUUID(ctx context.Context, userUUID uuid.UUID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	// ListByUUIDs returns the users that exist among userUUIDs, in no particular order
+	ListByUUIDs(ctx context.Context, userUUIDs []uuid.UUID) ([]models.User, error)
+	Exists(ctx context.Context, userUUID uuid.UUID) (bool, error)
+	EmailExists(ctx context.Context, email string) (bool, error)
+	UpdatePasswordHash(ctx context.Context, userUUID uuid.UUID, hash string) error
+	SetDiscoverability(ctx context.Context, userUUID uuid.UUID, value string) error
+	SetMessagePrivacy(ctx context.Context, userUUID uuid.UUID, value string) error
+	// UpdateProfile saves name, surname, bio and locale
+	UpdateProfile(ctx context.Context, user *models.User) error
+	UpdateEmail(ctx context.Context, userUUID uuid.UUID, email string) error
+	// SetAvatar records when the avatar changed; nil means it was removed
+	SetAvatar(ctx context.Context, userUUID uuid.UUID, updatedAt *time.Time) error
+	// Search finds discoverable users, people from Known first
+	Search(ctx context.Context, q UserSearch) ([]models.UserHit, error)
+}
+
+// UserSearch is a people search on behalf of SearcherUUID
+type UserSearch struct {
+	Query        string
+	SearcherUUID uuid.UUID   // сам себя в выдаче не видит
+	Known        []uuid.UUID // с кем уже есть общие чаты, они поднимаются выше
+	Exclude      []uuid.UUID // не показывать, например заблокированных
+	Limit        int
+	Offset       int
+}
+
+// ChatRepository stores chats and their participants
+type ChatRepository interface {
+	Create(ctx context.Context, chat *models.Chat) error
+	Get(ctx context.Context, chatUUID uuid.UUID) (*models.Chat, error)
+	// FindDirect returns the direct chat with exactly these participants in this order
+	FindDirect(ctx context.Context, participants []uuid.UUID) (uuid.UUID, error)
+	ListForUser(ctx context.Context, userUUID uuid.UUID) ([]models.Chat, error)
+	IsParticipant(ctx context.Context, chatUUID, userUUID uuid.UUID) (bool, error)
+}
+
+// MessageRepository stores chat history
+type MessageRepository interface {
+	// Create inserts the message; a zero UUID is generated by the store
+	Create(ctx context.Context, msg *models.Message) error
+	ListByChat(ctx context.Context, chatUUID uuid.UUID) ([]models.Message, error)
+	// MarkRead marks messages from other senders in the chat as read by readerUUID
+	MarkRead(ctx context.Context, chatUUID, readerUUID uuid.UUID) error
+	// Search runs a full-text query over the given chats, best matches first
+	Search(ctx context.Context, q MessageSearch) ([]models.MessageHit, error)
+}
+
+// MessageSearch is a full-text query over message history.
+// Доступ проверяет вызывающий: сюда передаются только чаты, где пользователь участник.
+type MessageSearch struct {
+	Query      string
+	ChatUUIDs  []uuid.UUID
+	SenderUUID uuid.UUID // uuid.Nil — любой отправитель
+	// HideSenders are left out in HideInChats (группы, где читатель их заблокировал)
+	HideSenders []uuid.UUID
+	HideInChats []uuid.UUID
+	From, To    time.Time // нулевое значение — без границы; To не включается
+	Limit       int
+	Offset      int
+}
+
+// BlockRepository stores who blocked whom
+type BlockRepository interface {
+	// Block is idempotent: повторная блокировка не ошибка
+	Block(ctx context.Context, blockerUUID, blockedUUID uuid.UUID) error
+	Unblock(ctx context.Context, blockerUUID, blockedUUID uuid.UUID) error
+	// ListBlocked returns the users blockerUUID blocked, newest first
+	ListBlocked(ctx context.Context, blockerUUID uuid.UUID) ([]models.Block, error)
+	// ListBlockers returns who blocked userUUID
+	ListBlockers(ctx context.Context, userUUID uuid.UUID) ([]uuid.UUID, error)
+}
+
+// ContactRepository stores mutual contacts and pending contact requests
+type ContactRepository interface {
+	// Request stores a pending request, ErrConflict if it is already pending
+	Request(ctx context.Context, fromUUID, toUUID uuid.UUID) error
+	// Accept turns the pending request into a contact for both users, ErrNotFound if there is none
+	Accept(ctx context.Context, fromUUID, toUUID uuid.UUID) error
+	// DeleteRequest drops a declined or cancelled request, ErrNotFound if there is none
+	DeleteRequest(ctx context.Context, fromUUID, toUUID uuid.UUID) error
+	// ListIncoming returns requests sent to userUUID, newest first
+	ListIncoming(ctx context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error)
+	// ListOutgoing returns requests userUUID sent, newest first
+	ListOutgoing(ctx context.Context, userUUID uuid.UUID) ([]models.ContactRequest, error)
+	// ListContacts returns the user's contacts, newest first
+	ListContacts(ctx context.Context, userUUID uuid.UUID) ([]models.Contact, error)
+	// Remove deletes the contact for both users, ErrNotFound if they weren't contacts
+	Remove(ctx context.Context, a, b uuid.UUID) error
+}
+
+// AccountRepository schedules and carries out account deletion
+type AccountRepository interface {
+	// ScheduleDeletion sets when the account is erased, ErrNotFound if there is no such user
+	ScheduleDeletion(ctx context.Context, userUUID uuid.UUID, at time.Time) error
+	// CancelDeletion clears a pending deletion, ErrNotFound if none was scheduled
+	CancelDeletion(ctx context.Context, userUUID uuid.UUID) error
+	// DueForDeletion returns up to limit accounts whose grace period ended by now
+	DueForDeletion(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error)
+	// Purge erases a due account in one transaction: сообщения переходят к
+	// models.DeletedUserUUID, пользователь выходит из всех чатов, строка удаляется
+	// вместе со всем, что на неё ссылается. ErrNotFound if it is no longer due.
+	Purge(ctx context.Context, userUUID uuid.UUID, now time.Time) error
+}
+
+// ImportRepository loads history migrated from other systems
+type ImportRepository interface {
+	// Import creates the chat unless it already exists and adds messages to it in
+	// one transaction. Сообщения с уже существующим UUID пропускаются, поэтому
+	// повторный импорт того же архива ничего не дублирует. Returns how many were added.
+	Import(ctx context.Context, chat *models.Chat, messages []models.Message) (int, error)
+}
+
+// TwoFactorRepository stores TOTP secrets and recovery codes.
+// Коды восстановления хранятся только хэшами, см. utils.HashRecoveryCode.
+type TwoFactorRepository interface {
+	// Get returns the TOTP state, ErrNotFound if there is no such user
+	Get(ctx context.Context, userUUID uuid.UUID) (*models.TwoFactor, error)
+	// Enroll stores a new, not yet enabled secret and replaces the recovery codes
+	Enroll(ctx context.Context, userUUID uuid.UUID, secret string, codeHashes []string) error
+	// Enable turns 2FA on and marks step, the code that confirmed it, as used
+	Enable(ctx context.Context, userUUID uuid.UUID, step int64) error
+	// UseStep records a TOTP step; false if this or a later step was already used
+	UseStep(ctx context.Context, userUUID uuid.UUID, step int64) (bool, error)
+	// UseRecoveryCode spends an unused code; false if there is no such code
+	UseRecoveryCode(ctx context.Context, userUUID uuid.UUID, codeHash string) (bool, error)
+	// HasRecoveryCode reports whether an unused code exists without spending it
+	HasRecoveryCode(ctx context.Context, userUUID uuid.UUID, codeHash string) (bool, error)
+	// Disable removes the secret and all recovery codes
+	Disable(ctx context.Context, userUUID uuid.UUID) error
+}
+
+// IdentityRepository links accounts to external OpenID identities
+type IdentityRepository interface {
+	// Login resolves the identity to an account in one transaction. Известная
+	// привязка просто обновляется. Иначе аккаунт с тем же email привязывается,
+	// только если linkByEmail (провайдер подтвердил email), а без этого —
+	// ErrConflict; если аккаунта нет, он создаётся из newUser без пароля.
+	// Reports whether the account was created.
+	Login(ctx context.Context, identity models.Identity, newUser *models.User, linkByEmail bool) (*models.User, bool, error)
+	// Find returns the account already linked to the identity or ErrNotFound
+	Find(ctx context.Context, issuer, subject string) (*models.User, error)
+}
+
+var (
+	_ UserRepository      = (*PostgresUsers)(nil)
+	_ ChatRepository      = (*PostgresChats)(nil)
+	_ MessageRepository   = (*PostgresMessages)(nil)
+	_ BlockRepository     = (*PostgresBlocks)(nil)
+	_ ContactRepository   = (*PostgresContacts)(nil)
+	_ AccountRepository   = (*PostgresAccounts)(nil)
+	_ ImportRepository    = (*PostgresImports)(nil)
+	_ TwoFactorRepository = (*PostgresTwoFactor)(nil)
+	_ IdentityRepository  = (*PostgresIdentities)(nil)
+	_ UserRepository      = (*MemoryUsers)(nil)
+	_ ChatRepository      = (*MemoryChats)(nil)
+	_ MessageRepository   = (*MemoryMessages)(nil)
+	_ BlockRepository     = (*MemoryBlocks)(nil)
+	_ ContactRepository   = (*MemoryContacts)(nil)
+	_ AccountRepository   = (*MemoryAccounts)(nil)
+	_ ImportRepository    = (*MemoryImports)(nil)
+	_ TwoFactorRepository = (*MemoryTwoFactor)(nil)
+	_ IdentityRepository  = (*MemoryIdentities)(nil)
+)
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"chat-app/internal/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AdminOnly allows the request only for users with users.is_admin set.
+// Должен стоять после AuthMiddleware.
+func AdminOnly(users repository.UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userUUID, err := uuid.Parse(c.GetString("user_uuid"))
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			c.Abort()
+			return
+		}
+
+		user, err := users.GetByUUID(c.Request.Context(), userUUID)
+		if err != nil || !user.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -1,15 +1,17 @@
 package middleware
 
 import (
-	"fmt"
+	"chat-app/internal/auth"
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware(jwtSecret []byte) gin.HandlerFunc {
+const claimsKey = "auth_claims"
+
+// AuthMiddleware verifies the access token; revocations may be nil when sessions are never revoked
+func AuthMiddleware(tokens *auth.TokenService, revocations *auth.Revocations) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -18,45 +20,50 @@ func AuthMiddleware(jwtSecret []byte) gin.HandlerFunc {
 			return
 		}
 
-		// Безопасно достаём токен
-		tokenString := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer"))
-		if tokenString == "" || tokenString == authHeader {
+		tokenString, ok := auth.BearerToken(authHeader)
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
 			c.Abort()
 			return
 		}
 
-		// Логируем безопасно
-		preview := tokenString
-		if len(tokenString) > 30 {
-			preview = tokenString[:30] + "..."
-		}
-		fmt.Println("→ Токен получен:", preview)
-
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		claims, err := tokens.VerifyAccess(tokenString)
+		if err != nil {
+			msg := "Invalid or expired token"
+			if errors.Is(err, auth.ErrExpiredToken) {
+				msg = "Token expired"
 			}
-			return jwtSecret, nil
-		}, jwt.WithValidMethods([]string{"HS256"}))
-
-		if err != nil || !token.Valid {
-			fmt.Println("JWT ошибка:", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid claims"})
-			c.Abort()
-			return
+		if revocations != nil {
+			if err := revocations.Check(c.Request.Context(), claims); err != nil {
+				if errors.Is(err, auth.ErrRevokedToken) {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked"})
+				} else {
+					// Не можем проверить отзыв — не пускаем, иначе удалённый аккаунт продолжил бы работать
+					c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authorization temporarily unavailable"})
+				}
+				c.Abort()
+				return
+			}
 		}
 
-		fmt.Println("Токен валидный! user_uuid:", claims["user_uuid"])
-		c.Set("user_uuid", claims["user_uuid"])
-		c.Set("email", claims["email"])
+		c.Set(claimsKey, claims)
+		c.Set("user_uuid", claims.UserUUID.String())
+		c.Set("email", claims.Email)
 		c.Next()
 	}
 }
+
+// Claims returns the verified token claims set by AuthMiddleware
+func Claims(c *gin.Context) (*auth.Claims, bool) {
+	v, ok := c.Get(claimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := v.(*auth.Claims)
+	return claims, ok
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"chat-app/internal/ratelimit"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimiter limits requests per authenticated user, or per IP for anonymous routes
+func RateLimiter(client *redis.Client, limit int64, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if userUUID := c.GetString("user_uuid"); userUUID != "" {
+			key = "user:" + userUUID
+		}
+		// Лимит входит в ключ: общий лимит группы и строгий лимит маршрута не делят счётчик
+		key = "ratelimit:" + c.FullPath() + ":" + strconv.FormatInt(limit, 10) + "/" + window.String() + ":" + key
+
+		allowed, retryAfter, err := ratelimit.Allow(c.Request.Context(), client, key, limit, window)
+		if err != nil {
+			// Redis недоступен — лучше пропустить запрос, чем положить API
+			log.Printf("Rate limiter error: %v", err)
+		}
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
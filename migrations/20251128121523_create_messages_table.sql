@@ -19,5 +19,5 @@ CREATE INDEX IF NOT EXISTS idx_messages_sender_uuid ON messages(sender_uuid);
 
 -- +goose Down
 -- +goose StatementBegin
-DROP TABLE IF EXISTS chats CASCADE;
+DROP TABLE IF EXISTS messages;
 -- +goose StatementEnd
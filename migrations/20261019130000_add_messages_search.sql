@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Переписки смешанные: chat_search берёт русский стеммер для кириллицы и
+-- английский для латиницы, так что «сообщения» и «messages» находятся по
+-- любой форме слова. Запросы обязаны использовать ту же конфигурацию.
+CREATE TEXT SEARCH CONFIGURATION chat_search (COPY = pg_catalog.russian);
+ALTER TEXT SEARCH CONFIGURATION chat_search
+    ALTER MAPPING FOR word, hword, hword_part WITH russian_stem;
+ALTER TEXT SEARCH CONFIGURATION chat_search
+    ALTER MAPPING FOR asciiword, asciihword, hword_asciipart WITH english_stem;
+
+-- STORED-колонка переписывает таблицу один раз, дальше считается при INSERT/UPDATE
+ALTER TABLE messages
+    ADD COLUMN search_vector tsvector
+        GENERATED ALWAYS AS (to_tsvector('chat_search'::regconfig, content)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_search_vector;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
+DROP TEXT SEARCH CONFIGURATION IF EXISTS chat_search;
+-- +goose StatementEnd
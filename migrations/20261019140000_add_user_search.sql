@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Кто может найти пользователя в поиске: name — по имени и точному email,
+-- email — только по точному email, none — никак (в общих чатах он по-прежнему виден)
+ALTER TABLE users
+    ADD COLUMN discoverability TEXT NOT NULL DEFAULT 'name'
+        CHECK (discoverability IN ('name', 'email', 'none'));
+
+-- Нечёткий поиск идёт только по имени; email сравнивается целиком и ищется по idx_users_email
+CREATE INDEX IF NOT EXISTS idx_users_full_name_trgm
+    ON users USING GIN ((lower(COALESCE(name, '') || ' ' || COALESCE(surname, ''))) gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_full_name_trgm;
+ALTER TABLE users DROP COLUMN IF EXISTS discoverability;
+-- +goose StatementEnd
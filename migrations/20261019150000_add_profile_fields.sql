@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN bio               TEXT NOT NULL DEFAULT '',
+    ADD COLUMN locale            TEXT NOT NULL DEFAULT 'ru',
+    ADD COLUMN avatar_updated_at TIMESTAMPTZ; -- NULL — аватара нет; время идёт в URL, чтобы сбрасывать кэш браузера
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN IF EXISTS avatar_updated_at,
+    DROP COLUMN IF EXISTS locale,
+    DROP COLUMN IF EXISTS bio;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Имя отправителя больше не хранится в сообщениях: его подставляет names.Service
+-- при чтении, поэтому переименование сразу видно во всей истории.
+--
+-- Переход в два релиза, чтобы не ломать rolling deploy:
+--   1. этот релиз: новый код не пишет и не читает sender_name, колонка остаётся
+--      NOT NULL с пустым значением по умолчанию — старые инстансы, которые ещё
+--      читают её в string, не упадут на новых строках;
+--   2. следующий релиз, когда старых инстансов не осталось:
+--      ALTER TABLE messages DROP COLUMN sender_name;
+ALTER TABLE messages ALTER COLUMN sender_name SET DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Старый код читает имя из колонки: заполняем строки, записанные без него,
+-- по тем же правилам, что models.User.DisplayName
+UPDATE messages m
+SET sender_name = COALESCE(NULLIF(btrim(COALESCE(u.name, '') || ' ' || COALESCE(u.surname, '')), ''), 'пользователь')
+FROM users u
+WHERE m.sender_uuid = u.uuid AND m.sender_name = '';
+
+ALTER TABLE messages ALTER COLUMN sender_name SET DEFAULT 'аноним';
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_uuid UUID NOT NULL REFERENCES users(uuid) ON DELETE CASCADE,
+    blocked_uuid UUID NOT NULL REFERENCES users(uuid) ON DELETE CASCADE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_uuid, blocked_uuid),
+    CHECK (blocker_uuid <> blocked_uuid)
+);
+
+-- Проверка в обратную сторону: кто заблокировал этого пользователя
+CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked ON user_blocks(blocked_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_blocks;
+-- +goose StatementEnd
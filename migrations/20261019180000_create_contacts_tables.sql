@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Кто может писать пользователю в личку: everyone — все, contacts — только контакты
+ALTER TABLE users
+    ADD COLUMN message_privacy TEXT NOT NULL DEFAULT 'everyone'
+        CHECK (message_privacy IN ('everyone', 'contacts'));
+
+-- Заявки в ожидании ответа; принятая или отклонённая заявка удаляется
+CREATE TABLE IF NOT EXISTS contact_requests (
+    from_uuid  UUID NOT NULL REFERENCES users(uuid) ON DELETE CASCADE,
+    to_uuid    UUID NOT NULL REFERENCES users(uuid) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (from_uuid, to_uuid),
+    CHECK (from_uuid <> to_uuid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_contact_requests_to ON contact_requests(to_uuid);
+
+-- Контакт взаимный и хранится двумя строками, чтобы список читался по одному индексу
+CREATE TABLE IF NOT EXISTS contacts (
+    user_uuid    UUID NOT NULL REFERENCES users(uuid) ON DELETE CASCADE,
+    contact_uuid UUID NOT NULL REFERENCES users(uuid) ON DELETE CASCADE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_uuid, contact_uuid),
+    CHECK (user_uuid <> contact_uuid)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS contacts;
+DROP TABLE IF EXISTS contact_requests;
+ALTER TABLE users DROP COLUMN IF EXISTS message_privacy;
+-- +goose StatementEnd
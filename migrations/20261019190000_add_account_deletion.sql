@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Когда аккаунт будет стёрт; до этого момента удаление можно отменить
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMPTZ;
+
+-- Фоновое удаление ищет только запланированные
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled
+    ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_deletion_scheduled;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+-- +goose StatementEnd
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 test key from RFC 6238, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// В RFC коды из 8 цифр, у нас 6 — это последние 6 цифр того же значения
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("T=%d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := TOTPStep(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"one step behind", -1, true},
+		{"one step ahead", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(rfc6238Secret, step+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched, ok := ValidateTOTP(rfc6238Secret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && matched != step+tt.offset {
+				t.Errorf("matched step %d, want %d", matched, step+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870821", "abcdef"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, now); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, " 287 082 ", now); !ok {
+		t.Error("code with spaces rejected")
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", now); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestAcceptTOTPRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := TOTPCode(rfc6238Secret, TOTPStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := AcceptTOTP(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("first use rejected")
+	}
+	if _, ok := AcceptTOTP(rfc6238Secret, code, now, step); ok {
+		t.Error("same code accepted twice")
+	}
+
+	// Код предыдущего окна после входа текущим тоже не годится
+	previous, _ := TOTPCode(rfc6238Secret, step-1)
+	if _, ok := AcceptTOTP(rfc6238Secret, previous, now, step); ok {
+		t.Error("older code accepted after a newer one")
+	}
+
+	next, _ := TOTPCode(rfc6238Secret, step+1)
+	if _, ok := AcceptTOTP(rfc6238Secret, next, now.Add(TOTPPeriod), step); !ok {
+		t.Error("next step rejected")
+	}
+}
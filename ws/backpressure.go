@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SlowConsumerPolicy decides what happens when a client's send buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// PolicyDisconnect closes the socket with CloseResyncRequired
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+	// PolicyDropOldest discards the oldest queued message to make room for the new one
+	PolicyDropOldest SlowConsumerPolicy = "drop_oldest"
+)
+
+// Коды закрытия из приватного диапазона 4000-4999: клиент по ним понимает,
+// что пропустил события и должен заново загрузить историю
+const (
+	CloseResyncRequired = 4001
+	CloseIdleTimeout    = 4002
+)
+
+// ParseSlowConsumerPolicy validates a policy name from configuration
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch p := SlowConsumerPolicy(s); p {
+	case PolicyDisconnect, PolicyDropOldest:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q, expected %s or %s", s, PolicyDisconnect, PolicyDropOldest)
+}
+
+// Stats are hub-wide backpressure counters
+type Stats struct {
+	DroppedMessages    int64  `json:"dropped_messages"`
+	SlowDisconnects    int64  `json:"slow_disconnects"`
+	ConnectedClients   int64  `json:"connected_clients"`
+	ActiveRooms        int64  `json:"active_rooms"`
+	SendBuffer         int    `json:"send_buffer"`
+	SlowConsumerPolicy string `json:"slow_consumer_policy"`
+}
+
+type counters struct {
+	dropped         atomic.Int64
+	slowDisconnects atomic.Int64
+	clients         atomic.Int64
+}
+
+// closeState remembers why the server is closing a client so writePump can
+// send a close frame with a reason
+type closeState struct {
+	once   sync.Once
+	code   int
+	reason string
+}
+
+// markClosing records the close reason and reports whether this call was the first
+func (c *Client) markClosing(code int, reason string) bool {
+	first := false
+	c.closing.once.Do(func() {
+		c.closing.code = code
+		c.closing.reason = reason
+		first = true
+	})
+	return first
+}
+
+// Stats returns a snapshot of the backpressure counters
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	rooms := len(h.rooms)
+	h.mu.RUnlock()
+
+	return Stats{
+		DroppedMessages:    h.counters.dropped.Load(),
+		SlowDisconnects:    h.counters.slowDisconnects.Load(),
+		ConnectedClients:   h.counters.clients.Load(),
+		ActiveRooms:        int64(rooms),
+		SendBuffer:         h.opts.SendBuffer,
+		SlowConsumerPolicy: string(h.opts.SlowPolicy),
+	}
+}
+
+// HandleStats exposes the hub counters (admin only)
+func (h *Hub) HandleStats(c *gin.Context) {
+	c.JSON(200, h.Stats())
+}
+
+// HandlePersistenceStats exposes the message writer queue depth (admin only)
+func (h *Hub) HandlePersistenceStats(c *gin.Context) {
+	if h.messages == nil {
+		c.JSON(503, gin.H{"error": "Message writer is not running"})
+		return
+	}
+	c.JSON(200, h.messages.Stats())
+}
@@ -1,28 +1,51 @@
 package ws
 
 import (
-	"chat-app/database"
-	"chat-app/internal/redis"
+	"chat-app/internal/auth"
+	"chat-app/internal/blocks"
+	"chat-app/internal/broker"
+	"chat-app/internal/contacts"
+	"chat-app/internal/models"
+	"chat-app/internal/names"
+	"chat-app/internal/persistence"
+	"chat-app/internal/presence"
+	"chat-app/internal/repository"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"log"
-	"net/http"
-	"strings"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
 )
 
-var (
-	upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-	JWTSecret []byte // <-- Глобальная переменная для секрета
-)
+// MessageStore accepts messages for durable storage, see persistence.Writer
+type MessageStore interface {
+	Enqueue(r persistence.Record) error
+	Stats() persistence.Stats
+}
+
+// Deps are the services the hub talks to
+type Deps struct {
+	Broker   broker.Broker
+	Names    *names.Service    // подписи отправителей
+	Blocks   *blocks.Service   // кто кого заблокировал; nil — без блокировок
+	Contacts *contacts.Service // кому можно писать в личку; nil — всем
+	Presence *presence.Tracker // кто онлайн; nil — не отслеживать
+	Chats    repository.ChatRepository
+	Tokens   *auth.TokenService // проверка токенов при апгрейде
+	Revoked  *auth.Revocations  // отозванные сессии; nil — не проверять
+	Messages MessageStore       // асинхронная запись сообщений в БД
+	Redis    *redis.Client      // одноразовые билеты для WebSocket
+}
 
 type WMessage struct {
 	UUID       string    `json:"uuid"`
@@ -33,6 +56,9 @@ type WMessage struct {
 	Content    string    `json:"content"`
 	CreatedAt  time.Time `json:"created_at"`
 	IsRead     bool      `json:"is_read"`
+	// Event and Data are set on notifications instead of the chat fields
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
 }
 
 type Client struct {
@@ -40,101 +66,335 @@ type Client struct {
 	conn     *websocket.Conn
 	send     chan WMessage
 	userUUID uuid.UUID
-	chatUUID string // добавлено для фильтрации сообщений
+	chatUUID string       // добавлено для фильтрации сообщений
+	chat     *models.Chat // nil у клиентов из Join и сокетов уведомлений
+	dropped  atomic.Int64
+	closing  closeState
+	lastSeen atomic.Int64    // unix nano последнего сообщения от клиента, для idle timeout
+	ctx      context.Context // живёт, пока открыт сокет; запросы в БД от имени клиента отменяются вместе с ним
+	cancel   context.CancelFunc
 }
 
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan WMessage
+	instanceID string // отличает наши сообщения в брокере от чужих
+	broker     broker.Broker
+	names      *names.Service
+	blocks     *blocks.Service
+	contacts   *contacts.Service
+	presence   *presence.Tracker
+	chats      repository.ChatRepository
+	tokens     *auth.TokenService
+	revoked    *auth.Revocations
+	messages   MessageStore
+	redis      *redis.Client
+	upgrader   websocket.Upgrader
+	opts       Options
+	counters   counters
+	life       lifecycle
+	rooms      map[string]*room // чат -> локальные подписчики
+	mu         sync.RWMutex     // защищает только карту rooms
+	shards     []chan WMessage  // доставка локальным сокетам, шард выбирается по чату
+	outbound   chan WMessage    // публикация в брокер для других инстансов
 	register   chan *Client
 	unregister chan *Client
-	mu         sync.Mutex
-	userNames  sync.Map
 }
 
-var HubInstance = &Hub{
-	clients:    make(map[*Client]bool),
-	broadcast:  make(chan WMessage, 100),
-	register:   make(chan *Client),
-	unregister: make(chan *Client),
+// envelope wraps a message in the broker with the instance that produced it
+type envelope struct {
+	Origin  string   `json:"origin"`
+	Message WMessage `json:"message"`
 }
 
-func SetJWTSecret(secret []byte) {
-	JWTSecret = secret
+// globalChat receives messages delivered to every connected client
+const globalChat = "global"
+
+// userRoom is the notification room of one user; у каждого свой канал в брокере
+func userRoom(userUUID uuid.UUID) string {
+	return "user:" + userUUID.String()
+}
+
+func NewHub(deps Deps, opts Options) *Hub {
+	h := &Hub{
+		instanceID: uuid.NewString(),
+		broker:     deps.Broker,
+		names:      deps.Names,
+		blocks:     deps.Blocks,
+		contacts:   deps.Contacts,
+		presence:   deps.Presence,
+		chats:      deps.Chats,
+		tokens:     deps.Tokens,
+		revoked:    deps.Revoked,
+		messages:   deps.Messages,
+		redis:      deps.Redis,
+		opts:       opts.withDefaults(),
+		rooms:      make(map[string]*room),
+		shards:     make([]chan WMessage, runtime.GOMAXPROCS(0)),
+		outbound:   make(chan WMessage, 100),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		life:       lifecycle{closeAll: make(chan chan struct{})},
+	}
+	for i := range h.shards {
+		h.shards[i] = make(chan WMessage, 100)
+	}
+	h.upgrader = websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	return h
 }
 
 func (h *Hub) Run() {
-	go h.handleLocalBroadcast()
-	go h.publishToRedis()
-	go h.subscribeRedis()
+	for _, shard := range h.shards {
+		go h.handleLocalBroadcast(shard)
+	}
+	go h.publishToBroker()
+
+	// global не привязан к комнате, подписываемся на всё время жизни хаба
+	if _, err := h.broker.Subscribe(context.Background(), globalChat, h.receive); err != nil {
+		log.Printf("Ошибка подписки на %s: %v", globalChat, err)
+	}
 
 	for {
 		select {
 		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
+			h.addClient(client)
 		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-			h.mu.Unlock()
+			h.removeClient(client)
+		case done := <-h.life.closeAll:
+			h.closeAllClients()
+			close(done)
 		}
 	}
 }
 
-func (h *Hub) handleLocalBroadcast() {
-	for msg := range h.broadcast {
-		h.mu.Lock()
-		for client := range h.clients {
-			// Отправляем сообщение только клиентам, подключенным к этому чату
-			if client.chatUUID == msg.ChatUUID || msg.ChatUUID == "global" {
-				select {
-				case client.send <- msg:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
+// Join attaches a client without a WebSocket, e.g. for server-side consumers and load tests
+func (h *Hub) Join(userUUID uuid.UUID, chatUUID string, buffer int) *Client {
+	client := &Client{
+		hub:      h,
+		send:     make(chan WMessage, buffer),
+		userUUID: userUUID,
+		chatUUID: chatUUID,
+	}
+	h.register <- client
+	return client
+}
+
+// Leave detaches a client added with Join
+func (h *Hub) Leave(client *Client) {
+	h.unregister <- client
+}
+
+// Messages returns the client's delivery channel; it is closed when the client leaves
+func (c *Client) Messages() <-chan WMessage {
+	return c.send
+}
+
+// addClient and removeClient run only in the Run goroutine, so room
+// creation, deletion and broker subscriptions never race
+func (h *Hub) addClient(client *Client) {
+	// После остановки новых клиентов сразу закрываем
+	if h.ShuttingDown() {
+		client.markClosing(websocket.CloseServiceRestart, "server restarting")
+		close(client.send)
+		return
+	}
+
+	h.mu.Lock()
+	r, ok := h.rooms[client.chatUUID]
+	if !ok {
+		r = newRoom()
+		h.rooms[client.chatUUID] = r
+	}
+	r.add(client)
+	h.mu.Unlock()
+
+	if client.conn != nil && h.presence != nil {
+		h.presence.Connected(client.userUUID)
+	}
+
+	if !ok {
+		unsubscribe, err := h.broker.Subscribe(context.Background(), client.chatUUID, h.receive)
+		if err != nil {
+			log.Printf("Ошибка подписки на чат %s: %v", client.chatUUID, err)
+			return
 		}
+		r.unsubscribe = unsubscribe
+	}
+}
+
+func (h *Hub) removeClient(client *Client) {
+	h.mu.Lock()
+	r, ok := h.rooms[client.chatUUID]
+	if !ok {
 		h.mu.Unlock()
+		return
+	}
+	removed, empty := r.remove(client)
+	if empty {
+		delete(h.rooms, client.chatUUID)
+	}
+	h.mu.Unlock()
+
+	if removed {
+		h.trackDisconnect(client)
+	}
+
+	if empty && r.unsubscribe != nil {
+		r.unsubscribe()
 	}
 }
 
-func (h *Hub) publishToRedis() {
-	ctx := context.Background()
-	for msg := range h.broadcast {
-		data, err := json.Marshal(msg)
-		if err != nil {
+// trackDisconnect tells presence that one of the user's sockets is gone
+func (h *Hub) trackDisconnect(client *Client) {
+	if client.conn != nil && h.presence != nil {
+		h.presence.Disconnected(client.userUUID)
+	}
+}
+
+// receive handles a payload from the broker
+func (h *Hub) receive(payload []byte) {
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return
+	}
+	// Свои сообщения уже доставлены локально в Broadcast
+	if env.Origin == h.instanceID {
+		return
+	}
+	h.shardFor(env.Message.ChatUUID) <- env.Message
+}
+
+// shardFor keeps all messages of one chat on one goroutine, preserving their order
+func (h *Hub) shardFor(chatUUID string) chan WMessage {
+	f := fnv.New32a()
+	f.Write([]byte(chatUUID))
+	return h.shards[f.Sum32()%uint32(len(h.shards))]
+}
+
+func (h *Hub) handleLocalBroadcast(shard chan WMessage) {
+	for msg := range shard {
+		if msg.ChatUUID == globalChat {
+			h.mu.RLock()
+			rooms := make([]*room, 0, len(h.rooms))
+			for _, r := range h.rooms {
+				rooms = append(rooms, r)
+			}
+			h.mu.RUnlock()
+
+			for _, r := range rooms {
+				h.deliver(r, msg)
+			}
 			continue
 		}
-		channel := "chat:" + msg.ChatUUID
-		redis.Client.Publish(ctx, channel, data)
+
+		// Сообщение касается только подписчиков своего чата
+		h.mu.RLock()
+		r := h.rooms[msg.ChatUUID]
+		h.mu.RUnlock()
+
+		if r != nil {
+			h.deliver(r, msg)
+		}
 	}
 }
 
-func (h *Hub) subscribeRedis() {
-	ctx := context.Background()
-	pubsub := redis.Client.Subscribe(ctx, "chat:*")
-	defer pubsub.Close()
+func (h *Hub) deliver(r *room, msg WMessage) {
+	slow, dropped := r.deliver(msg, h.opts.SlowPolicy, h.hiddenFrom(msg))
+	if dropped > 0 {
+		h.counters.dropped.Add(int64(dropped))
+	}
 
-	for msg := range pubsub.Channel() {
-		var wmsg WMessage
-		if err := json.Unmarshal([]byte(msg.Payload), &wmsg); err != nil {
+	for _, client := range slow {
+		if !client.markClosing(CloseResyncRequired, "slow consumer, resync required") {
+			continue // уже отключается
+		}
+		h.counters.slowDisconnects.Add(1)
+		// Отключаем через Run, чтобы канал закрывался ровно в одном месте
+		go func(c *Client) { h.unregister <- c }(client)
+	}
+}
+
+// hiddenFrom returns the filter of recipients who blocked the sender. В личном
+// чате такое сообщение не дойдёт до рассылки, в группе его не видит только блокирующий.
+func (h *Hub) hiddenFrom(msg WMessage) func(*Client) bool {
+	if h.blocks == nil {
+		return nil
+	}
+	sender, err := uuid.Parse(msg.SenderUUID)
+	if err != nil {
+		return nil
+	}
+	return func(c *Client) bool {
+		return c.userUUID != sender && h.blocks.Blocks(c.userUUID, sender)
+	}
+}
+
+// Notify pushes an event to every notification socket of the user, on any instance
+func (h *Hub) Notify(userUUID uuid.UUID, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if !h.broadcast(WMessage{
+		UUID:      uuid.New().String(),
+		ChatUUID:  userRoom(userUUID),
+		Event:     event,
+		Data:      payload,
+		CreatedAt: time.Now(),
+	}) {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+// Broadcast sends a message produced on this instance to local clients right away
+// and publishes it once for the other instances
+func (h *Hub) Broadcast(msg WMessage) {
+	if !h.broadcast(msg) {
+		log.Printf("Хаб останавливается, сообщение %s не разослано", msg.UUID)
+	}
+}
+
+func (h *Hub) broadcast(msg WMessage) bool {
+	if !h.startPublish() {
+		return false
+	}
+	h.shardFor(msg.ChatUUID) <- msg
+	h.outbound <- msg
+	return true
+}
+
+func (h *Hub) publishToBroker() {
+	ctx := context.Background()
+	for msg := range h.outbound {
+		data, err := json.Marshal(envelope{Origin: h.instanceID, Message: msg})
+		if err != nil {
+			h.life.inflight.Done()
 			continue
 		}
-		h.broadcast <- wmsg
+		if err := h.broker.Publish(ctx, msg.ChatUUID, data); err != nil {
+			log.Printf("Ошибка публикации в брокер: %v", err)
+		}
+		h.life.inflight.Done()
 	}
 }
 
 func (c *Client) readPump() {
 	defer func() {
+		c.cancel()
 		c.hub.unregister <- c
 		c.conn.Close()
+		c.hub.life.readers.Done()
 	}()
 
+	opts := c.hub.opts
+	c.lastSeen.Store(time.Now().UnixNano())
+
+	// Слишком большой фрейм gorilla закроет сама с кодом 1009
+	c.conn.SetReadLimit(opts.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+	})
+
 	for {
 		var input struct {
 			ChatUUID string `json:"chat_uuid"`
@@ -143,10 +403,15 @@ func (c *Client) readPump() {
 
 		err := c.conn.ReadJSON(&input)
 		if err != nil {
-			log.Println("read error:", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("read error:", err)
+			}
 			break
 		}
 
+		c.lastSeen.Store(time.Now().UnixNano())
+		c.conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+
 		log.Printf("Получено сообщение: chat_uuid='%s', content='%s'", input.ChatUUID, input.Text)
 
 		if input.ChatUUID == "" || input.Text == "" {
@@ -154,14 +419,15 @@ func (c *Client) readPump() {
 			continue
 		}
 
-		chatType := "direct"
-		if strings.HasPrefix(input.ChatUUID, "group-") {
-			chatType = "group"
+		// Участие проверено при подключении только для этого чата
+		if c.chat == nil || input.ChatUUID != c.chatUUID {
+			log.Printf("Клиент %s чата %s пишет в чужой чат %s", c.userUUID, c.chatUUID, input.ChatUUID)
+			continue
 		}
+		chatType := c.chat.Type
 
-		senderName, ok := c.hub.getUserName(c.userUUID)
-		if !ok {
-			senderName = "пользователь"
+		if chatType == models.ChatDirect && !c.hub.directAllowed(c.ctx, c.chat, c.userUUID) {
+			continue
 		}
 
 		msg := WMessage{
@@ -169,7 +435,7 @@ func (c *Client) readPump() {
 			ChatUUID:   input.ChatUUID,
 			ChatType:   chatType,
 			SenderUUID: c.userUUID.String(),
-			SenderName: senderName,
+			SenderName: c.hub.names.Name(c.ctx, c.userUUID),
 			Content:    input.Text,
 			CreatedAt:  time.Now(),
 			IsRead:     false,
@@ -177,227 +443,237 @@ func (c *Client) readPump() {
 
 		log.Printf("Создано WMessage: Content='%s'", msg.Content)
 
-		saveMessageToDB(msg)
-		c.hub.broadcast <- msg
+		// Сначала сохраняем: доставленное, но не записанное сообщение пропало бы из истории
+		if err := c.hub.saveMessage(msg); err != nil {
+			log.Printf("Сообщение %s не сохранено и не разослано: %v", msg.UUID, err)
+			continue
+		}
+		c.hub.Broadcast(msg)
 	}
 }
 
 func (c *Client) writePump() {
-	for msg := range c.send {
-		err := c.conn.WriteJSON(msg)
-		if err != nil {
-			break
+	opts := c.hub.opts
+	ticker := time.NewTicker(opts.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				// Канал закрыт хабом: если причина известна, сообщаем её клиенту
+				c.markClosing(websocket.CloseNormalClosure, "")
+				c.writeClose(c.closing.code, c.closing.reason)
+				return
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(opts.WriteWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			// Сокет уведомлений только слушает: молчание клиента там норма, закрывать его
+			// нельзя, иначе пользователь ещё и станет офлайн
+			if opts.IdleTimeout > 0 && c.chat != nil && time.Since(time.Unix(0, c.lastSeen.Load())) > opts.IdleTimeout {
+				c.markClosing(CloseIdleTimeout, "idle timeout")
+				c.writeClose(c.closing.code, c.closing.reason)
+				return
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(opts.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
-	c.conn.Close()
 }
 
-func HandleChat(c *gin.Context) {
-	tokenString := c.Query("token")
-	if tokenString == "" {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			c.JSON(401, gin.H{"error": "unauthorized"})
-			return
+func (c *Client) writeClose(code int, reason string) {
+	frame := websocket.FormatCloseMessage(code, reason)
+	c.conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(c.hub.opts.WriteWait))
+}
+
+// authenticate resolves the socket's user or writes the error response.
+// Браузер не умеет ставить заголовки в WebSocket, поэтому он приходит с билетом;
+// остальные клиенты могут передать токен в Authorization
+func (h *Hub) authenticate(c *gin.Context) (uuid.UUID, bool) {
+	if ticket := c.Query("ticket"); ticket != "" {
+		userUUID, err := h.redeemTicket(c, ticket)
+		if err != nil {
+			c.JSON(401, gin.H{"error": "invalid ticket"})
+			return uuid.Nil, false
 		}
-		tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+		return userUUID, true
 	}
 
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return JWTSecret, nil
-	}, jwt.WithValidMethods([]string{"HS256"}))
+	tokenString, ok := auth.BearerToken(c.GetHeader("Authorization"))
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return uuid.Nil, false
+	}
 
-	if err != nil || !token.Valid {
+	claims, err := h.tokens.VerifyAccess(tokenString)
+	if err != nil {
 		c.JSON(401, gin.H{"error": "invalid token"})
-		return
+		return uuid.Nil, false
+	}
+	// Билеты выдаются за проверенным middleware токеном, а здесь проверяем сами
+	if h.revoked != nil {
+		if err := h.revoked.Check(c.Request.Context(), claims); err != nil {
+			c.JSON(401, gin.H{"error": "invalid token"})
+			return uuid.Nil, false
+		}
 	}
+	return claims.UserUUID, true
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
+func (h *Hub) HandleChat(c *gin.Context) {
+	userUUID, ok := h.authenticate(c)
 	if !ok {
-		c.JSON(401, gin.H{"error": "invalid claims"})
 		return
 	}
 
-	userUUIDStr, ok := claims["user_uuid"].(string)
-	if !ok {
-		c.JSON(401, gin.H{"error": "invalid user_uuid in token"})
+	chatUUIDStr := c.Param("chat_uuid")
+	chatUUID, err := uuid.Parse(chatUUIDStr)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid chat uuid"})
 		return
 	}
 
-	userUUID, err := uuid.Parse(userUUIDStr)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "invalid user uuid"})
+	chat, err := h.chats.Get(c.Request.Context(), chatUUID)
+	if err != nil || !chat.HasParticipant(userUUID) {
+		c.JSON(403, gin.H{"error": "access denied to chat"})
 		return
 	}
 
-	chatUUIDStr := c.Param("chat_uuid")
-	chatUUID, err := uuid.Parse(chatUUIDStr)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "invalid chat uuid"})
+	// Фильтр доставки смотрит только в кэш, поэтому списки получателя грузим заранее
+	if h.blocks != nil {
+		if err := h.blocks.Warm(c.Request.Context(), userUUID); err != nil {
+			log.Printf("Не удалось загрузить блокировки %s: %v", userUUID, err)
+		}
+	}
+
+	client, ok := h.serve(c, userUUID, chatUUIDStr, chat)
+	if !ok {
 		return
 	}
 
-	var count int
-	err = database.DB.QueryRow(`
-		SELECT COUNT(*) FROM chats
-		WHERE uuid = $1 AND participants::jsonb ? $2
-	`, chatUUID, userUUIDStr).Scan(&count)
+	// Имя понадобится при первом сообщении, прогреваем кэш заранее
+	go h.names.Name(client.ctx, client.userUUID)
+}
 
-	if err != nil || count == 0 {
-		c.JSON(403, gin.H{"error": "access denied to chat"})
+// HandleNotifications opens the user's notification socket: заявки в контакты
+// и прочие события, не привязанные к чату. Писать в этот сокет нечего.
+func (h *Hub) HandleNotifications(c *gin.Context) {
+	userUUID, ok := h.authenticate(c)
+	if !ok {
 		return
 	}
+	h.serve(c, userUUID, userRoom(userUUID), nil)
+}
+
+// serve upgrades the connection and attaches the client to room
+func (h *Hub) serve(c *gin.Context, userUUID uuid.UUID, room string, chat *models.Chat) (*Client, bool) {
+	// Во время остановки новые сокеты не принимаем, клиент переподключится к другому узлу
+	if !h.startReader() {
+		c.JSON(503, gin.H{"error": "server restarting"})
+		return nil, false
+	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
+		h.life.readers.Done()
 		log.Println("upgrade:", err)
-		return
+		return nil, false
 	}
 
+	// Контекст запроса отменится, как только хендлер вернётся, поэтому у сокета свой
+	ctx, cancel := context.WithCancel(context.Background())
 	client := &Client{
-		hub:      HubInstance,
+		hub:      h,
 		conn:     conn,
-		send:     make(chan WMessage, 100),
+		send:     make(chan WMessage, h.opts.SendBuffer),
 		userUUID: userUUID,
-		chatUUID: chatUUIDStr,
-	}
-
-	HubInstance.register <- client
-
-	go func() {
-		var name, surname string
-		if err := database.DB.QueryRow(`
-		SELECT COALESCE(name, ''), COALESCE(surname, ''), email
-		FROM users WHERE uuid = $1
-	`, client.userUUID).Scan(&name, &surname); err == nil {
-			var fullName string
-			if name != "" && surname != "" {
-				fullName = name + " " + surname
-			} else if name != "" {
-				fullName = name
-			} else if surname != "" {
-				fullName = surname
-			} else {
-				fullName = "пользователь"
-			}
-			HubInstance.userNames.Store(client.userUUID, fullName)
-		}
-	}()
-
-	rows, err := database.DB.Query(`
-		SELECT uuid, sender_uuid, sender_name, content, created_at, is_read
-		FROM messages
-		WHERE chat_uuid = $1
-		ORDER BY created_at ASC
-		LIMIT 50
-	`, chatUUID)
-
-	if err != nil {
-		log.Printf("Ошибка загрузки истории: %v", err)
-	} else {
-		defer rows.Close()
-		//var history []WMessage
-		//for rows.Next() {
-		//	var m WMessage
-		//	var senderUUID uuid.UUID
-		//	if err := rows.Scan(&m.UUID, &senderUUID, &m.SenderName, &m.Content, &m.CreatedAt, &m.IsRead); err != nil {
-		//		continue
-		//	}
-		//	m.SenderUUID = senderUUID.String()
-		//	m.ChatUUID = chatUUIDStr
-		//	history = append(history, m)
-		//}
-		//
-		//for _, msg := range history {
-		//	client.send <- msg
-		//}
+		chatUUID: room,
+		chat:     chat,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 
+	h.register <- client
+
 	go client.writePump()
 	go client.readPump()
+	return client, true
 }
 
-func saveMessageToDB(msg WMessage) {
-	go func() {
-		senderUUID, err := uuid.Parse(msg.SenderUUID)
-		if err != nil {
-			log.Printf("Ошибка парсинга sender_uuid: %v", err)
-			return
-		}
+// directAllowed reports whether sender may write to the peer of a direct chat:
+// никто из двоих никого не заблокировал, и настройки получателя это разрешают.
+// Если проверить не удалось, сообщение не пропускаем.
+func (h *Hub) directAllowed(ctx context.Context, chat *models.Chat, sender uuid.UUID) bool {
+	peer, ok := chat.Peer(sender)
+	if !ok {
+		return true
+	}
 
-		chatUUID, err := uuid.Parse(msg.ChatUUID)
+	if h.blocks != nil {
+		blocked, err := h.blocks.Between(ctx, sender, peer)
 		if err != nil {
-			log.Printf("Некорректный chat_uuid: %v", err)
-			return
+			log.Printf("Не удалось проверить блокировку %s и %s: %v", sender, peer, err)
+			return false
 		}
-
-		msgUUID, _ := uuid.Parse(msg.UUID)
-
-		senderName := msg.SenderName
-		if senderName == "" || senderName == "аноним" {
-			var name, surname string
-
-			err = database.DB.QueryRow(`
-			SELECT COALESCE(name, ''), COALESCE(surname, '') FROM users WHERE uuid = $1
-		`, senderUUID).Scan(&name, &surname)
-			if err == nil {
-				if name != "" && surname != "" {
-					senderName = name + " " + surname
-				} else if name != "" {
-					senderName = name
-				} else if surname != "" {
-					senderName = surname
-				} else {
-					senderName = "пользователь"
-				}
-			} else {
-				senderName = "пользователь"
-			}
+		if blocked {
+			log.Printf("Сообщение от %s в чат %s отклонено: блокировка", sender, chat.UUID)
+			return false
 		}
+	}
 
-		_, err = database.DB.Exec(`
-			INSERT INTO messages (uuid, chat_uuid, sender_uuid, sender_name, content, created_at, is_read)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-			ON CONFLICT (uuid) DO NOTHING
-		`, msgUUID, chatUUID, senderUUID, senderName, msg.Content, msg.CreatedAt, msg.IsRead)
-
+	if h.contacts != nil {
+		allowed, err := h.contacts.CanMessage(ctx, sender, peer)
 		if err != nil {
-			log.Printf("Ошибка сохранения сообщения в БД: %v", err)
-			log.Printf("Данные: content='%s', sender_name='%s', sender_uuid='%s'",
-				msg.Content, senderName, msg.SenderUUID)
-		} else {
-			log.Printf("✓ Сообщение сохранено: '%s' от '%s'", msg.Content, senderName)
+			log.Printf("Не удалось проверить настройки %s: %v", peer, err)
+			return false
 		}
-	}()
+		if !allowed {
+			log.Printf("Сообщение от %s в чат %s отклонено: получатель принимает только контакты", sender, chat.UUID)
+			return false
+		}
+	}
+	return true
 }
 
-func (h *Hub) getUserName(userUUID uuid.UUID) (string, bool) {
-	if name, ok := h.userNames.Load(userUUID); ok {
-		return name.(string), true
+// saveMessage hands the message to the persistence pipeline. Ошибка означает,
+// что сообщение не сохранено ни в БД, ни в WAL, и рассылать его нельзя.
+func (h *Hub) saveMessage(msg WMessage) error {
+	if h.messages == nil {
+		return errors.New("message store is not configured")
+	}
+
+	senderUUID, err := uuid.Parse(msg.SenderUUID)
+	if err != nil {
+		return fmt.Errorf("invalid sender_uuid: %w", err)
+	}
+
+	chatUUID, err := uuid.Parse(msg.ChatUUID)
+	if err != nil {
+		return fmt.Errorf("invalid chat_uuid: %w", err)
 	}
 
-	var name, surname string
-	err := database.DB.QueryRow(`
-		SELECT COALESCE(name, ''), COALESCE(surname, '') FROM users WHERE uuid = $1
-	`, userUUID).Scan(&name, &surname)
+	msgUUID, err := uuid.Parse(msg.UUID)
 	if err != nil {
-		h.userNames.Store(userUUID, "пользователь")
-		return "пользователь", true
-	}
-	var fullName string
-	if name != "" && surname != "" {
-		fullName = name + " " + surname
-	} else if name != "" {
-		fullName = name
-	} else if surname != "" {
-		fullName = surname
-	} else {
-		fullName = "пользователь"
-	}
-
-	h.userNames.Store(userUUID, fullName)
-	return fullName, true
+		return fmt.Errorf("invalid message uuid: %w", err)
+	}
+
+	return h.messages.Enqueue(persistence.Record{
+		UUID:       msgUUID,
+		ChatUUID:   chatUUID,
+		SenderUUID: senderUUID,
+		Content:    msg.Content,
+		CreatedAt:  msg.CreatedAt,
+		IsRead:     msg.IsRead,
+	})
 }
@@ -0,0 +1,141 @@
+package ws
+
+import (
+	"chat-app/internal/broker"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Два инстанса за одним Redis: каждое сообщение доходит до каждого инстанса
+// ровно один раз, а отправитель не получает своё же сообщение обратно из брокера
+func TestTwoHubsShareRedis(t *testing.T) {
+	brokers := []struct {
+		name string
+		new  func(t *testing.T, client *redis.Client, node string) broker.Broker
+	}{
+		{"pubsub", func(t *testing.T, client *redis.Client, node string) broker.Broker {
+			return broker.NewRedisPubSub(client)
+		}},
+		{"streams", func(t *testing.T, client *redis.Client, node string) broker.Broker {
+			b, err := broker.NewRedisStreams(client, node, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}},
+	}
+
+	for _, tt := range brokers {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+
+			// Close у стримов ждёт конца блокирующего XREADGROUP, закрываем узлы параллельно
+			var closers []func()
+			t.Cleanup(func() {
+				var wg sync.WaitGroup
+				for _, closeNode := range closers {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						closeNode()
+					}()
+				}
+				wg.Wait()
+			})
+			newHub := func(node string) *Hub {
+				client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+				b := tt.new(t, client, node)
+				closers = append(closers, func() {
+					b.Close()
+					client.Close()
+				})
+				h := NewHub(Deps{Broker: b}, Options{SendBuffer: 1000})
+				go h.Run()
+				return h
+			}
+			a, b := newHub("a"), newHub("b")
+
+			chatUUID := uuid.NewString()
+			onA := a.Join(uuid.New(), chatUUID, 1000)
+			onB := b.Join(uuid.New(), chatUUID, 1000)
+
+			// SUBSCRIBE в pub/sub подтверждается асинхронно, ждём обе подписки
+			if tt.name == "pubsub" {
+				waitFor(t, func() bool {
+					return mr.PubSubNumSub(pubsubChannel(chatUUID))[pubsubChannel(chatUUID)] == 2
+				})
+			}
+
+			const n = 50
+			sent := make(map[string]bool, n)
+			for i := 0; i < n; i++ {
+				msg := WMessage{UUID: uuid.NewString(), ChatUUID: chatUUID, Content: fmt.Sprint(i), CreatedAt: time.Now()}
+				sent[msg.UUID] = true
+				a.Broadcast(msg)
+			}
+
+			for name, client := range map[string]*Client{"sender": onA, "peer": onB} {
+				got := collect(t, client, n)
+				for id, count := range got {
+					if !sent[id] {
+						t.Errorf("%s: unexpected message %s", name, id)
+					}
+					if count != 1 {
+						t.Errorf("%s: message %s delivered %d times", name, id, count)
+					}
+				}
+				if len(got) != n {
+					t.Errorf("%s: got %d distinct messages, want %d", name, len(got), n)
+				}
+			}
+
+			// Эхо из брокера пришло бы позже локальной доставки, даём ему время
+			time.Sleep(200 * time.Millisecond)
+			select {
+			case msg := <-onA.Messages():
+				t.Errorf("sender received its own message back: %s", msg.UUID)
+			case msg := <-onB.Messages():
+				t.Errorf("peer received a duplicate: %s", msg.UUID)
+			default:
+			}
+		})
+	}
+}
+
+// pubsubChannel mirrors the broker's channel naming
+func pubsubChannel(chatUUID string) string {
+	return "chat:" + chatUUID
+}
+
+// collect reads n messages from the client and counts them by UUID
+func collect(t *testing.T, client *Client, n int) map[string]int {
+	t.Helper()
+	got := make(map[string]int, n)
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-client.Messages():
+			got[msg.UUID]++
+		case <-timeout:
+			t.Fatalf("received %d of %d messages", i, n)
+		}
+	}
+	return got
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,297 @@
+package ws
+
+import (
+	"chat-app/internal/auth"
+	"chat-app/internal/broker"
+	"chat-app/internal/models"
+	"chat-app/internal/names"
+	"chat-app/internal/persistence"
+	"chat-app/internal/repository"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// memStore is a MessageStore that keeps records in memory
+type memStore struct {
+	mu      sync.Mutex
+	records []persistence.Record
+}
+
+func (s *memStore) Enqueue(r persistence.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *memStore) Stats() persistence.Stats {
+	return persistence.Stats{}
+}
+
+// testEnv is a hub behind an httptest server with one group chat
+type testEnv struct {
+	hub    *Hub
+	srv    *httptest.Server
+	tokens *auth.TokenService
+	store  *memStore // nil, если передано своё хранилище
+	user   uuid.UUID
+	chat   *models.Chat
+}
+
+func newTestEnv(t *testing.T, opts Options) *testEnv {
+	t.Helper()
+	store := &memStore{}
+	env := newTestEnvWithStore(t, opts, store)
+	env.store = store
+	return env
+}
+
+func newTestEnvWithStore(t *testing.T, opts Options, store MessageStore) *testEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &testEnv{
+		tokens: auth.NewTokenService(keys, time.Hour),
+		user:   uuid.New(),
+	}
+
+	chats := repository.NewMemoryChats()
+	env.chat = &models.Chat{Type: models.ChatGroup, Participants: []uuid.UUID{env.user}}
+	if err := chats.Create(context.Background(), env.chat); err != nil {
+		t.Fatal(err)
+	}
+
+	env.hub = NewHub(Deps{
+		Broker:   broker.NewMemory(),
+		Names:    names.New(repository.NewMemoryUsers(), nil),
+		Chats:    chats,
+		Tokens:   env.tokens,
+		Messages: store,
+	}, opts)
+	go env.hub.Run()
+
+	r := gin.New()
+	r.GET("/ws/chat/:chat_uuid", env.hub.HandleChat)
+	r.GET("/ws/notifications", env.hub.HandleNotifications)
+	env.srv = httptest.NewServer(r)
+	t.Cleanup(env.srv.Close)
+	return env
+}
+
+func (e *testEnv) dial(t *testing.T, path string) *websocket.Conn {
+	t.Helper()
+	token, err := e.tokens.IssueAccess(e.user, "ann@example.com", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(e.srv.URL, "http")+path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func (e *testEnv) dialChat(t *testing.T) *websocket.Conn {
+	return e.dial(t, "/ws/chat/"+e.chat.UUID.String())
+}
+
+// readUntilClosed reads in the background and reports the error that ended the connection
+func readUntilClosed(conn *websocket.Conn) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// countPings answers pings like a browser does and counts them; answer=false
+// models a client that stopped responding
+func countPings(conn *websocket.Conn, answer bool) *atomic.Int32 {
+	var pings atomic.Int32
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		if !answer {
+			return nil
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	return &pings
+}
+
+func expectClose(t *testing.T, closed <-chan error, code int, within time.Duration) {
+	t.Helper()
+	select {
+	case err := <-closed:
+		if code == 0 {
+			return
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != code {
+			t.Fatalf("connection ended with %v, want close code %d", err, code)
+		}
+	case <-time.After(within):
+		t.Fatalf("connection still open after %s", within)
+	}
+}
+
+func expectOpen(t *testing.T, closed <-chan error, d time.Duration) {
+	t.Helper()
+	select {
+	case err := <-closed:
+		t.Fatalf("connection closed: %v", err)
+	case <-time.After(d):
+	}
+}
+
+func waitClients(t *testing.T, h *Hub, n int64) {
+	t.Helper()
+	waitFor(t, func() bool { return h.Stats().ConnectedClients == n })
+}
+
+func TestPingPongKeepsConnection(t *testing.T) {
+	env := newTestEnv(t, Options{PongWait: 150 * time.Millisecond, PingInterval: 30 * time.Millisecond})
+	conn := env.dialChat(t)
+	pings := countPings(conn, true)
+	closed := readUntilClosed(conn)
+
+	// Несколько сроков PongWait: соединение живо, пока клиент отвечает
+	expectOpen(t, closed, 600*time.Millisecond)
+	if n := pings.Load(); n < 5 {
+		t.Errorf("got %d pings, want at least 5", n)
+	}
+}
+
+func TestMissingPongClosesConnection(t *testing.T) {
+	env := newTestEnv(t, Options{PongWait: 150 * time.Millisecond, PingInterval: 30 * time.Millisecond})
+	conn := env.dialChat(t)
+	waitClients(t, env.hub, 1)
+	pings := countPings(conn, false)
+	closed := readUntilClosed(conn)
+
+	// Read deadline истекает, сервер рвёт соединение и убирает клиента
+	expectClose(t, closed, 0, 2*time.Second)
+	if pings.Load() == 0 {
+		t.Error("server never pinged")
+	}
+	waitClients(t, env.hub, 0)
+}
+
+func TestReadLimitCloses1009(t *testing.T) {
+	env := newTestEnv(t, Options{MaxMessageSize: 128})
+	conn := env.dialChat(t)
+	closed := readUntilClosed(conn)
+
+	big := `{"chat_uuid":"` + env.chat.UUID.String() + `","text":"` + strings.Repeat("x", 1024) + `"}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, closed, websocket.CloseMessageTooBig, 2*time.Second)
+	waitClients(t, env.hub, 0)
+
+	env.store.mu.Lock()
+	defer env.store.mu.Unlock()
+	if len(env.store.records) != 0 {
+		t.Errorf("oversized message stored: %d records", len(env.store.records))
+	}
+}
+
+func TestWriteDeadlineDropsStuckClient(t *testing.T) {
+	env := newTestEnv(t, Options{WriteWait: 100 * time.Millisecond, SendBuffer: 10000})
+	env.dialChat(t) // клиент ничего не читает, буферы сокета быстро заполнятся
+	waitClients(t, env.hub, 1)
+
+	content := strings.Repeat("x", 64<<10)
+	for i := 0; i < 2000 && env.hub.Stats().ConnectedClients > 0; i++ {
+		env.hub.Broadcast(WMessage{UUID: uuid.NewString(), ChatUUID: env.chat.UUID.String(), Content: content})
+	}
+	waitClients(t, env.hub, 0)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	opts := Options{
+		PongWait:     100 * time.Millisecond,
+		PingInterval: 20 * time.Millisecond,
+		IdleTimeout:  200 * time.Millisecond,
+	}
+
+	t.Run("silent chat socket is closed", func(t *testing.T) {
+		env := newTestEnv(t, opts)
+		conn := env.dialChat(t)
+		countPings(conn, true)
+
+		// Понги не считаются активностью: клиент молчит — закрываем с 4002
+		expectClose(t, readUntilClosed(conn), CloseIdleTimeout, 2*time.Second)
+		waitClients(t, env.hub, 0)
+	})
+
+	t.Run("active chat socket stays", func(t *testing.T) {
+		env := newTestEnv(t, opts)
+		conn := env.dialChat(t)
+		countPings(conn, true)
+		closed := readUntilClosed(conn)
+
+		deadline := time.Now().Add(3 * opts.IdleTimeout)
+		for time.Now().Before(deadline) {
+			if err := conn.WriteJSON(map[string]string{"chat_uuid": env.chat.UUID.String(), "text": "hi"}); err != nil {
+				t.Fatal(err)
+			}
+			expectOpen(t, closed, opts.IdleTimeout/4)
+		}
+	})
+
+	t.Run("silent notification socket stays", func(t *testing.T) {
+		env := newTestEnv(t, opts)
+		conn := env.dial(t, "/ws/notifications")
+		countPings(conn, true)
+		events := make(chan WMessage, 1)
+		closed := make(chan error, 1)
+		go func() {
+			for {
+				var msg WMessage
+				if err := conn.ReadJSON(&msg); err != nil {
+					closed <- err
+					return
+				}
+				events <- msg
+			}
+		}()
+
+		// Сокет уведомлений только слушает, idle timeout его не касается
+		expectOpen(t, closed, 3*opts.IdleTimeout)
+		if err := env.hub.Notify(env.user, "ping", nil); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-events:
+			if msg.Event != "ping" {
+				t.Errorf("event = %q", msg.Event)
+			}
+		case err := <-closed:
+			t.Fatalf("notification socket closed: %v", err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("notification not delivered")
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package ws
+
+import "time"
+
+// Options configures per-client buffering, backpressure and connection liveness
+type Options struct {
+	SendBuffer int
+	SlowPolicy SlowConsumerPolicy
+
+	WriteWait      time.Duration // сколько ждём записи одного фрейма
+	PongWait       time.Duration // без pong дольше этого соединение считается мёртвым
+	PingInterval   time.Duration // должен быть меньше PongWait
+	MaxMessageSize int64         // лимит входящего фрейма, больше — закрываем с 1009
+	IdleTimeout    time.Duration // нет сообщений в чат-сокете дольше этого — закрываем; 0 выключает
+
+	AllowedOrigins []string // пусто — только тот же хост; "*" разрешает всех
+}
+
+// DefaultOptions match the hub's historical buffering with sane liveness limits
+var DefaultOptions = Options{
+	SendBuffer:     100,
+	SlowPolicy:     PolicyDisconnect,
+	WriteWait:      10 * time.Second,
+	PongWait:       60 * time.Second,
+	PingInterval:   54 * time.Second,
+	MaxMessageSize: 32 << 10,
+	IdleTimeout:    30 * time.Minute,
+}
+
+// withDefaults fills unset fields from DefaultOptions
+func (o Options) withDefaults() Options {
+	d := DefaultOptions
+	if o.SendBuffer <= 0 {
+		o.SendBuffer = d.SendBuffer
+	}
+	if o.SlowPolicy == "" {
+		o.SlowPolicy = d.SlowPolicy
+	}
+	if o.WriteWait <= 0 {
+		o.WriteWait = d.WriteWait
+	}
+	if o.PongWait <= 0 {
+		o.PongWait = d.PongWait
+	}
+	if o.PingInterval <= 0 || o.PingInterval >= o.PongWait {
+		o.PingInterval = o.PongWait * 9 / 10
+	}
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = d.MaxMessageSize
+	}
+	return o
+}
@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"log"
+	"sync"
+)
+
+// room holds the local clients of one chat, so a message only touches its subscribers
+type room struct {
+	mu          sync.RWMutex
+	clients     map[*Client]struct{}
+	unsubscribe func() // отписка от брокера, трогается только из Hub.Run
+}
+
+func newRoom() *room {
+	return &room{clients: make(map[*Client]struct{})}
+}
+
+func (r *room) add(client *Client) {
+	r.mu.Lock()
+	r.clients[client] = struct{}{}
+	r.mu.Unlock()
+	client.hub.counters.clients.Add(1)
+}
+
+// remove closes the client's channel. It reports whether the client was still
+// in the room (отключение может прийти дважды) and whether the room is now empty.
+func (r *room) remove(client *Client) (removed, empty bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, removed = r.clients[client]
+	if removed {
+		delete(r.clients, client)
+		close(client.send)
+		client.hub.counters.clients.Add(-1)
+		if n := client.dropped.Load(); n > 0 {
+			log.Printf("Клиент %s в чате %s пропустил %d сообщений", client.userUUID, client.chatUUID, n)
+		}
+	}
+	return removed, len(r.clients) == 0
+}
+
+// deliver queues msg for every client according to policy, except those skip
+// rejects (skip may be nil). It returns the clients to disconnect and how many
+// messages were dropped.
+func (r *room) deliver(msg WMessage, policy SlowConsumerPolicy, skip func(*Client) bool) ([]*Client, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var slow []*Client
+	dropped := 0
+	for client := range r.clients {
+		if skip != nil && skip(client) {
+			continue
+		}
+
+		select {
+		case client.send <- msg:
+			continue
+		default:
+		}
+
+		if policy != PolicyDropOldest {
+			slow = append(slow, client)
+			continue
+		}
+
+		// Выкидываем самое старое и пробуем ещё раз; global может писать параллельно,
+		// поэтому вторая попытка тоже неблокирующая
+		select {
+		case <-client.send:
+			dropped++
+			client.dropped.Add(1)
+		default:
+		}
+		select {
+		case client.send <- msg:
+		default:
+			dropped++
+			client.dropped.Add(1)
+		}
+	}
+	return slow, dropped
+}
@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrShuttingDown is returned by Notify once the hub no longer publishes events
+var ErrShuttingDown = errors.New("hub is shutting down")
+
+// lifecycle tracks work that must finish before the hub can be stopped
+type lifecycle struct {
+	mu       sync.Mutex
+	closed   bool           // новые сокеты не принимаем
+	drained  bool           // читатели завершились, новых сообщений в брокер не берём
+	readers  sync.WaitGroup // активные readPump: каждый может держать принятое сообщение
+	inflight sync.WaitGroup // сообщения, ещё не опубликованные в брокер
+	closeAll chan chan struct{}
+}
+
+// startReader registers a read loop unless the hub is shutting down
+func (h *Hub) startReader() bool {
+	h.life.mu.Lock()
+	defer h.life.mu.Unlock()
+
+	if h.life.closed {
+		return false
+	}
+	h.life.readers.Add(1)
+	return true
+}
+
+// startPublish registers a message for publishing unless Shutdown is already
+// waiting for the publisher. Add и Wait у inflight разделены мьютексом, иначе
+// Notify из HTTP-хендлера мог бы гоняться с inflight.Wait.
+func (h *Hub) startPublish() bool {
+	h.life.mu.Lock()
+	defer h.life.mu.Unlock()
+
+	if h.life.drained {
+		return false
+	}
+	h.life.inflight.Add(1)
+	return true
+}
+
+// ShuttingDown reports whether Shutdown has been called
+func (h *Hub) ShuttingDown() bool {
+	h.life.mu.Lock()
+	defer h.life.mu.Unlock()
+	return h.life.closed
+}
+
+// Shutdown stops accepting clients, closes every socket with a "server restarting"
+// frame and waits until all accepted messages are published, or ctx expires.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.life.mu.Lock()
+	h.life.closed = true
+	h.life.mu.Unlock()
+
+	done := make(chan struct{})
+	select {
+	case h.life.closeAll <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Порядок важен: сначала дожидаемся читателей, чтобы новых сообщений больше не было,
+	// затем — публикации. Очередь записи в БД закрывается отдельно, после хаба.
+	for _, wait := range []func(){
+		func() { <-done },
+		h.life.readers.Wait,
+		func() {
+			h.life.mu.Lock()
+			h.life.drained = true
+			h.life.mu.Unlock()
+			h.life.inflight.Wait()
+		},
+	} {
+		if err := waitContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeAllClients runs in the Run goroutine
+func (h *Hub) closeAllClients() {
+	h.mu.Lock()
+	rooms := h.rooms
+	h.rooms = make(map[string]*room)
+	h.mu.Unlock()
+
+	for _, r := range rooms {
+		r.mu.Lock()
+		for client := range r.clients {
+			client.markClosing(websocket.CloseServiceRestart, "server restarting")
+			delete(r.clients, client)
+			close(client.send)
+			h.counters.clients.Add(-1)
+			h.trackDisconnect(client)
+		}
+		r.mu.Unlock()
+
+		if r.unsubscribe != nil {
+			r.unsubscribe()
+		}
+	}
+}
+
+func waitContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,159 @@
+package ws
+
+import (
+	"chat-app/database"
+	"chat-app/internal/persistence"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// acceptedStore records which messages the writer accepted
+type acceptedStore struct {
+	*persistence.Writer
+	mu       sync.Mutex
+	accepted []uuid.UUID
+	rejected int
+}
+
+func (s *acceptedStore) Enqueue(r persistence.Record) error {
+	err := s.Writer.Enqueue(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.rejected++
+	} else {
+		s.accepted = append(s.accepted, r.UUID)
+	}
+	return err
+}
+
+// unreachableDB is a pool that never connects: every batch ends up in the WAL
+func unreachableDB(t *testing.T) *database.Database {
+	t.Helper()
+	pool, err := pgxpool.New(context.Background(), "postgres://chat@127.0.0.1:1/chat?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return &database.Database{Pool: pool}
+}
+
+func readWAL(t *testing.T, path string) map[uuid.UUID]bool {
+	t.Helper()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got := make(map[uuid.UUID]bool)
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var r persistence.Record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got[r.UUID] = true
+	}
+	return got
+}
+
+// Сообщения, которые клиенты шлют прямо во время остановки, не теряются:
+// всё, что хаб принял, оказывается в БД или в WAL
+func TestShutdownKeepsAcceptedMessages(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "messages.wal")
+	writer, err := persistence.NewWriter(unreachableDB(t), persistence.Options{
+		FlushInterval: 5 * time.Millisecond,
+		MaxRetries:    1,
+		RetryBackoff:  time.Millisecond,
+		WALPath:       walPath,
+		ReplayEvery:   time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Start()
+	store := &acceptedStore{Writer: writer}
+
+	env := newTestEnvWithStore(t, Options{}, store)
+
+	var senders sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		conn := env.dialChat(t)
+		readUntilClosed(conn) // забираем эхо, чтобы клиент не стал медленным
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for i := 0; ; i++ {
+				text := fmt.Sprintf("client %d message %d", c, i)
+				if err := conn.WriteJSON(map[string]string{"chat_uuid": env.chat.UUID.String(), "text": text}); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	// Уведомления из HTTP-хендлеров тоже идут во время остановки
+	notified := make(chan error, 1)
+	go func() {
+		for {
+			if err := env.hub.Notify(env.user, "ping", nil); err != nil {
+				notified <- err
+				return
+			}
+		}
+	}()
+
+	waitFor(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.accepted) >= 100
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := env.hub.Shutdown(ctx); err != nil {
+		t.Fatalf("hub shutdown: %v", err)
+	}
+	if err := writer.Close(ctx); err != nil {
+		t.Fatalf("writer close: %v", err)
+	}
+	senders.Wait()
+
+	if err := <-notified; !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Notify after shutdown = %v, want ErrShuttingDown", err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.rejected > 0 {
+		t.Errorf("%d messages rejected by the writer during shutdown", store.rejected)
+	}
+
+	spilled := readWAL(t, walPath)
+	lost := 0
+	for _, id := range store.accepted {
+		if !spilled[id] {
+			lost++
+		}
+	}
+	if lost > 0 {
+		t.Errorf("%d of %d accepted messages are neither in the database nor in the WAL", lost, len(store.accepted))
+	}
+	if st := writer.Stats(); st.Written > 0 {
+		t.Errorf("written = %d with an unreachable database", st.Written)
+	}
+}
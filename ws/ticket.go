@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Билет живёт недолго и гасится при первом использовании, поэтому его
+// появление в URL и логах не опасно, в отличие от access-токена
+const ticketTTL = 30 * time.Second
+
+var errInvalidTicket = errors.New("invalid or expired ticket")
+
+func ticketKey(ticket string) string {
+	return "ws:ticket:" + ticket
+}
+
+// IssueTicket exchanges the caller's access token for a single-use WebSocket ticket
+func (h *Hub) IssueTicket(c *gin.Context) {
+	userUUID := c.GetString("user_uuid")
+	if userUUID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue ticket"})
+		return
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(buf)
+
+	if err := h.redis.Set(c.Request.Context(), ticketKey(ticket), userUUID, ticketTTL).Err(); err != nil {
+		log.Printf("Не удалось сохранить WS-билет: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": ticketTTL.Seconds(),
+	})
+}
+
+// redeemTicket atomically consumes a ticket and returns its owner
+func (h *Hub) redeemTicket(c *gin.Context, ticket string) (uuid.UUID, error) {
+	userUUIDStr, err := h.redis.GetDel(c.Request.Context(), ticketKey(ticket)).Result()
+	if err != nil {
+		return uuid.Nil, errInvalidTicket
+	}
+	return uuid.Parse(userUUIDStr)
+}
+
+// checkOrigin allows browsers only from the configured origins.
+// Запросы без Origin (не браузерные клиенты) пропускаем: их защищает авторизация.
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	allowed := h.opts.AllowedOrigins
+
+	// Без списка — только тот же хост, как в gorilla по умолчанию
+	if len(allowed) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}